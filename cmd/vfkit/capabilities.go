@@ -0,0 +1,42 @@
+//go:build darwin
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/crc-org/vfkit/pkg/rest"
+	restvf "github.com/crc-org/vfkit/pkg/rest/vf"
+	"github.com/crc-org/vfkit/pkg/vf"
+	"github.com/spf13/cobra"
+)
+
+var capabilitiesCmd = &cobra.Command{
+	Use:   "capabilities",
+	Short: "Print the features supported by vfkit and by the host as JSON",
+	Long: `Print a JSON document describing the vfkit version, the supported device types
+with their options, the bootloaders, the endpoints of the RESTful service, and the
+virtualization features of the host. The same document is returned by the
+GET /capabilities endpoint of the RESTful service.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		// the handlers are never called, only the routes are listed
+		restVM := restvf.NewVzVirtualMachine(nil)
+		caps, err := rest.NewCapabilities(vf.HostCapabilities(), rest.Endpoints(restVM, restVM))
+		if err != nil {
+			return err
+		}
+		capsJSON, err := json.MarshalIndent(caps, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(cmd.OutOrStdout(), string(capsJSON))
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(capabilitiesCmd)
+}
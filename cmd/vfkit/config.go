@@ -0,0 +1,135 @@
+//go:build darwin
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/crc-org/vfkit/pkg/cmdline"
+	"github.com/crc-org/vfkit/pkg/config"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// runtimeFlags are the vfkit options which change how vfkit runs, but which
+// are not part of the virtual machine configuration
+var runtimeFlags = []string{"log-level", "restful-uri", "pidfile", "dry-run"}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Convert and compare virtual machine configurations",
+}
+
+var configFromCmdLineCmd = &cobra.Command{
+	Use:   "from-cmdline -- [vfkit options]",
+	Short: "Print the JSON configuration corresponding to vfkit command line options",
+	Example: `  vfkit config from-cmdline -- --cpus 2 --memory 2048 --bootloader efi,variable-store=efi-store,create \
+      --device virtio-blk,path=disk.img > vm.json`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		vmConfig, err := vmConfigurationFromCmdLine(args)
+		if err != nil {
+			return err
+		}
+		vmJSON, err := json.MarshalIndent(vmConfig, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(cmd.OutOrStdout(), string(vmJSON))
+		return err
+	},
+}
+
+var configToCmdLineCmd = &cobra.Command{
+	Use:          "to-cmdline <config file>",
+	Short:        "Print the vfkit command line corresponding to a JSON or YAML configuration file",
+	Example:      `  vfkit config to-cmdline vm.json`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		vmConfig, err := config.VirtualMachineFromFile(args[0])
+		if err != nil {
+			return err
+		}
+		vmArgs, err := vmConfig.ToCmdLine()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(cmd.OutOrStdout(), formatCmdLine(vmArgs))
+		return err
+	},
+}
+
+var configDiffJSON bool
+
+var configDiffCmd = &cobra.Command{
+	Use:   "diff <config file> <config file>",
+	Short: "Print the differences between two JSON or YAML configuration files",
+	Long: `Print the changes needed to go from the first virtual machine configuration to the second one, one per line.
+Added values are prefixed with '+', removed values with '-' and modified values with '~'.`,
+	Example:      `  vfkit config diff vm.json vm-new.json`,
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		oldConfig, err := config.VirtualMachineFromFile(args[0])
+		if err != nil {
+			return err
+		}
+		newConfig, err := config.VirtualMachineFromFile(args[1])
+		if err != nil {
+			return err
+		}
+		changes, err := oldConfig.Diff(newConfig)
+		if err != nil {
+			return err
+		}
+		if configDiffJSON {
+			changesJSON, err := json.MarshalIndent(changes, "", "  ")
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), string(changesJSON))
+			return err
+		}
+		for _, change := range changes {
+			if _, err := fmt.Fprintln(cmd.OutOrStdout(), change); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// vmConfigurationFromCmdLine parses the vfkit command line options in args,
+// and returns the corresponding virtual machine configuration.
+func vmConfigurationFromCmdLine(args []string) (*config.VirtualMachine, error) {
+	vmOpts := &cmdline.Options{}
+	vfkitCmd := &cobra.Command{Use: "vfkit"}
+	cmdline.AddFlags(vfkitCmd, vmOpts)
+	if err := vfkitCmd.ParseFlags(args); err != nil {
+		return nil, err
+	}
+	if err := vfkitCmd.ValidateFlagGroups(); err != nil {
+		return nil, err
+	}
+	if vfkitCmd.Flags().NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", vfkitCmd.Flags().Args())
+	}
+	if vmOpts.IsSet("cloud-init") {
+		return nil, fmt.Errorf("--cloud-init cannot be converted, a virtio-blk device using a cloud-init ISO image must be used instead")
+	}
+	for _, flag := range runtimeFlags {
+		if vmOpts.IsSet(flag) {
+			log.Warnf("--%s is not part of the virtual machine configuration, ignoring it", flag)
+		}
+	}
+
+	return vmConfigurationFromOptions(vmOpts)
+}
+
+func init() {
+	configDiffCmd.Flags().BoolVar(&configDiffJSON, "json", false, "print the changes in JSON format")
+	configCmd.AddCommand(configFromCmdLineCmd, configToCmdLineCmd, configDiffCmd)
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVMConfigurationFromCmdLine(t *testing.T) {
+	args := []string{
+		"--cpus", "2", "--memory", "2048",
+		"--kernel", "/vmlinuz", "--initrd", "/initrd", "--kernel-cmdline", "console=hvc0 root=/dev/vda",
+		"--device", "virtio-rng",
+		"--device", "virtio-vsock,port=1234,socketURL=/vsock.sock",
+		"--device", "virtio-fs,sharedDir=/Users/user,mountTag=home",
+		"--timesync", "vsockPort=1235",
+		"--ignition", "/config.ign",
+		"--gui",
+	}
+	vmConfig, err := vmConfigurationFromCmdLine(args)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint(2), vmConfig.Vcpus)
+	assert.Equal(t, config.NewLinuxBootloader("/vmlinuz", "console=hvc0 root=/dev/vda", "/initrd"), vmConfig.Bootloader)
+	assert.Len(t, vmConfig.Devices, 5)
+	require.NotNil(t, vmConfig.Ignition)
+	assert.Equal(t, "/config.ign", vmConfig.Ignition.ConfigPath)
+
+	// the command line generated from the configuration is canonical:
+	// converting it again gives the same configuration and command line
+	canonicalArgs, err := vmConfig.ToCmdLine()
+	require.NoError(t, err)
+	canonicalConfig, err := vmConfigurationFromCmdLine(canonicalArgs)
+	require.NoError(t, err)
+	assert.Equal(t, vmConfig, canonicalConfig)
+	canonicalArgs2, err := canonicalConfig.ToCmdLine()
+	require.NoError(t, err)
+	assert.Equal(t, canonicalArgs, canonicalArgs2)
+}
+
+func TestVMConfigurationFromCmdLineErrors(t *testing.T) {
+	_, err := vmConfigurationFromCmdLine([]string{"--bootloader", "efi,variable-store=/efi", "--cloud-init", "/user-data,/meta-data"})
+	require.ErrorContains(t, err, "--cloud-init cannot be converted")
+
+	_, err = vmConfigurationFromCmdLine([]string{"--bootloader", "efi,variable-store=/efi", "extra"})
+	require.ErrorContains(t, err, "unexpected arguments")
+
+	_, err = vmConfigurationFromCmdLine([]string{"--bootloader", "efi,variable-store=/efi", "--kernel", "/vmlinuz"})
+	require.Error(t, err)
+}
+
+func TestConfigConversionCommands(t *testing.T) {
+	var output bytes.Buffer
+	rootCmd.SetOut(&output)
+	defer rootCmd.SetOut(nil)
+
+	rootCmd.SetArgs([]string{"config", "from-cmdline", "--", "--bootloader", "efi,variable-store=/efi-store,create", "--device", "virtio-rng"})
+	err := rootCmd.Execute()
+	require.NoError(t, err)
+
+	var vmConfig config.VirtualMachine
+	err = json.Unmarshal(output.Bytes(), &vmConfig)
+	require.NoError(t, err)
+	assert.Equal(t, config.NewEFIBootloader("/efi-store", true), vmConfig.Bootloader)
+
+	configPath := filepath.Join(t.TempDir(), "vm.json")
+	err = os.WriteFile(configPath, output.Bytes(), 0600)
+	require.NoError(t, err)
+
+	output.Reset()
+	rootCmd.SetArgs([]string{"config", "to-cmdline", configPath})
+	err = rootCmd.Execute()
+	require.NoError(t, err)
+	assert.Equal(t, "vfkit --cpus 1 --memory 512 --bootloader efi,variable-store=/efi-store,create --device virtio-rng", strings.TrimSpace(output.String()))
+}
+
+func TestConfigDiffCommand(t *testing.T) {
+	var output bytes.Buffer
+	rootCmd.SetOut(&output)
+	defer rootCmd.SetOut(nil)
+
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "vm.json")
+	err := os.WriteFile(oldPath, []byte(`{"vcpus": 2, "memoryBytes": 2147483648, "bootloader": {"kind": "efiBootloader", "efiVariableStorePath": "/efi-store"}, "devices": [{"kind": "virtiorng"}]}`), 0600)
+	require.NoError(t, err)
+	newPath := filepath.Join(dir, "vm-new.json")
+	err = os.WriteFile(newPath, []byte(`{"vcpus": 4, "memoryBytes": 2147483648, "bootloader": {"kind": "efiBootloader", "efiVariableStorePath": "/efi-store"}, "devices": [{"kind": "virtiorng"}, {"kind": "virtioballoon"}]}`), 0600)
+	require.NoError(t, err)
+
+	rootCmd.SetArgs([]string{"config", "diff", oldPath, newPath})
+	err = rootCmd.Execute()
+	require.NoError(t, err)
+	assert.Equal(t, "+ devices[1]: {\"kind\":\"virtioballoon\"}\n~ vcpus: 2 -> 4\n", output.String())
+
+	output.Reset()
+	rootCmd.SetArgs([]string{"config", "diff", oldPath, oldPath})
+	err = rootCmd.Execute()
+	require.NoError(t, err)
+	assert.Empty(t, output.String())
+}
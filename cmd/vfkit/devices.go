@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var devicesCmd = &cobra.Command{
+	Use:   "devices [device type]",
+	Short: "List the device types which can be used with --device, or the options of a device type",
+	Example: `  vfkit devices
+  vfkit devices virtio-net`,
+	Args:         cobra.MaximumNArgs(1),
+	SilenceUsage: true,
+	ValidArgsFunction: func(_ *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) != 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		names := []string{}
+		for _, devType := range config.DeviceTypes() {
+			if strings.HasPrefix(devType.Name, toComplete) {
+				names = append(names, devType.Name+"\t"+devType.Description)
+			}
+		}
+		return names, cobra.ShellCompDirectiveNoFileComp
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return printDeviceTypes(cmd.OutOrStdout())
+		}
+		return printDeviceOptions(cmd.OutOrStdout(), args[0])
+	},
+}
+
+// printDeviceTypes writes the list of the registered device types to w.
+func printDeviceTypes(w io.Writer) error {
+	fmt.Fprintln(w, "Device types:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, devType := range config.DeviceTypes() {
+		fmt.Fprintf(tw, "  %s\t%s\n", devType.Name, devType.Description)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w, "\nUse 'vfkit devices <device type>' to list the options of a device type.")
+	return err
+}
+
+// printDeviceOptions writes the description of the device type deviceType and
+// of its options to w.
+func printDeviceOptions(w io.Writer, deviceType string) error {
+	devType, ok := config.LookupDeviceType(deviceType)
+	if !ok {
+		return &config.UnknownDeviceError{DeviceType: deviceType}
+	}
+	help, err := config.DeviceHelp(deviceType)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s: %s\n\nUsage: --device %s[,option=value...]\n\n%s", devType.Name, devType.Description, devType.Name, help)
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(devicesCmd)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDevicesCmd(t *testing.T) {
+	var output bytes.Buffer
+	rootCmd.SetOut(&output)
+	defer rootCmd.SetOut(nil)
+
+	rootCmd.SetArgs([]string{"devices"})
+	err := rootCmd.Execute()
+	require.NoError(t, err)
+	for _, devType := range config.DeviceTypes() {
+		assert.Regexp(t, "\n  "+devType.Name+" +"+devType.Description+"\n", output.String())
+	}
+
+	output.Reset()
+	rootCmd.SetArgs([]string{"devices", "virtio-vsock"})
+	err = rootCmd.Execute()
+	require.NoError(t, err)
+	assert.Equal(t, `virtio-vsock: vsock connection between the host and the guest
+
+Usage: --device virtio-vsock[,option=value...]
+
+virtio-vsock options:
+  id=<string>         unique identifier of the device
+  port=<number>       vsock port (required)
+  socketURL=<string>  path to the unix socket on the host (required)
+  listen|connect      whether the host listens for connections from the guest, or connects to the guest (default: listen)
+`, output.String())
+
+	rootCmd.SetArgs([]string{"devices", "virtio-foo"})
+	err = rootCmd.Execute()
+	require.EqualError(t, err, "unknown device type: virtio-foo")
+}
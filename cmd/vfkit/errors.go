@@ -0,0 +1,32 @@
+//go:build darwin
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/crc-org/vfkit/pkg/config"
+)
+
+// deviceArgErrorDetails returns the --device argument in which err was found,
+// with its invalid part underlined, or an empty string if err is not related
+// to a --device argument.
+func deviceArgErrorDetails(err error) string {
+	var argErr config.DeviceArgError
+	if !errors.As(err, &argErr) {
+		return ""
+	}
+	location := argErr.DeviceArgument()
+	if location.Arg == "" {
+		return ""
+	}
+
+	prefix := "  --device "
+	indent := utf8.RuneCountInString(prefix + location.Arg[:location.Start])
+	width := max(utf8.RuneCountInString(location.Arg[location.Start:location.End]), 1)
+
+	return fmt.Sprintf("%s%s\n%s%s\n", prefix, location.Arg, strings.Repeat(" ", indent), strings.Repeat("^", width))
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceArgErrorDetails(t *testing.T) {
+	vm := &config.VirtualMachine{}
+	err := vm.AddDevicesFromCmdLine([]string{`virtio-fs,sharedDir="/Users/ü,a",foo,mountTag=tag`})
+	require.Error(t, err)
+	assert.Equal(t, ""+
+		`  --device virtio-fs,sharedDir="/Users/ü,a",foo,mountTag=tag`+"\n"+
+		`                                            ^^^`+"\n",
+		deviceArgErrorDetails(fmt.Errorf("wrapped: %w", err)))
+
+	err = vm.AddDevicesFromCmdLine([]string{"virtio-vsock,port=1234"})
+	require.Error(t, err)
+	assert.Equal(t, ""+
+		"  --device virtio-vsock,port=1234\n"+
+		"                                 ^\n",
+		deviceArgErrorDetails(err))
+
+	_, err = (&config.VirtioVsock{Port: 1234}).ToCmdLine()
+	require.Error(t, err)
+	assert.Empty(t, deviceArgErrorDetails(err))
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/containers/common/pkg/strongunits"
+	"github.com/crc-org/vfkit/pkg/image"
+	"github.com/crc-org/vfkit/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+var imageCmd = &cobra.Command{
+	Use:   "image",
+	Short: "Manage the disk images used by the virtual machines",
+}
+
+var imageConvertQuiet bool
+
+var imageConvertCmd = &cobra.Command{
+	Use:   "convert <image> <raw image>",
+	Short: "Convert a qcow2, VMDK or VHDX disk image to a sparse raw image",
+	Long: `Convert a qcow2, VMDK or VHDX disk image to a raw image which can be used with the
+virtio-blk, nvme and usb-mass-storage devices. The backing files of qcow2 images are
+read, and the unallocated parts of the image are holes in the raw image.`,
+	Example:      `  vfkit image convert Fedora-Cloud-Base.qcow2 fedora.raw`,
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := image.ConvertOptions{}
+		if !imageConvertQuiet {
+			progress := newProgressPrinter(cmd.ErrOrStderr(), fmt.Sprintf("Converting %s", args[0]))
+			defer progress.finish()
+			opts.Progress = progress.update
+		}
+		return image.Convert(args[0], args[1], opts)
+	},
+}
+
+var imageCreateSize string
+
+var imageCreateCmd = &cobra.Command{
+	Use:   "create --size <size> <raw image>",
+	Short: "Create an empty sparse raw disk image",
+	Long: `Create an empty raw disk image of the given size, which must not exist. The image is a
+sparse file, it only uses disk space once the guest writes data to it.`,
+	Example:      `  vfkit image create --size 20GiB disk.raw`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(_ *cobra.Command, args []string) error {
+		size, err := util.ParseSize(imageCreateSize, strongunits.MiB(1))
+		if err != nil {
+			return err
+		}
+		return image.Create(args[0], size)
+	},
+}
+
+var (
+	imageResizeSize   string
+	imageResizeShrink bool
+)
+
+var imageResizeCmd = &cobra.Command{
+	Use:   "resize --size <size> <raw image>",
+	Short: "Resize a raw disk image",
+	Long: `Resize a raw disk image. When the image has a GUID partition table, its backup header is
+moved to the new end of the disk, so that the guest sees a valid partition table and
+can grow its partitions. Shrinking the image requires --shrink, and fails if it would
+truncate a partition.`,
+	Example:      `  vfkit image resize --size 40GiB disk.raw`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(_ *cobra.Command, args []string) error {
+		size, err := util.ParseSize(imageResizeSize, strongunits.MiB(1))
+		if err != nil {
+			return err
+		}
+		return image.Resize(args[0], size, image.ResizeOptions{Shrink: imageResizeShrink})
+	},
+}
+
+var imageCloneSnapshot string
+
+var imageCloneCmd = &cobra.Command{
+	Use:   "clone <raw image> <clone>",
+	Short: "Create a copy-on-write clone of a raw disk image",
+	Long: `Create a copy-on-write clone of a raw disk image, or of one of its snapshots. The clone
+shares its data with the image until either of them is modified, and can be used as the
+image of virtio-blk, nvme and usb-mass-storage devices. When the filesystem does not
+support copy-on-write clones, the image is copied without its holes.`,
+	Example: `  vfkit image clone golden.raw vm1.raw
+  vfkit image clone --snapshot base golden.raw vm2.raw`,
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE: func(_ *cobra.Command, args []string) error {
+		if imageCloneSnapshot != "" {
+			return image.CloneSnapshot(args[0], imageCloneSnapshot, args[1])
+		}
+		return image.Clone(args[0], args[1])
+	},
+}
+
+var imageSnapshotDelete bool
+
+var imageSnapshotCmd = &cobra.Command{
+	Use:   "snapshot <raw image> [<name>]",
+	Short: "Create, list or delete the snapshots of a raw disk image",
+	Long: `Save the current content of a raw disk image as a snapshot called <name>. The snapshots
+are copy-on-write clones of the image stored in the <image>.snapshots directory, with a
+metadata file tracking the snapshot tree. The snapshots are listed when no name is given.
+The virtual machine using the image must not be running.`,
+	Example: `  vfkit image snapshot disk.raw base
+  vfkit image snapshot disk.raw
+  vfkit image snapshot --delete disk.raw base`,
+	Args:         cobra.RangeArgs(1, 2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch {
+		case len(args) == 1 && imageSnapshotDelete:
+			return fmt.Errorf("the name of the snapshot to delete is missing")
+		case len(args) == 1:
+			tree, err := image.ReadSnapshots(args[0])
+			if err != nil {
+				return err
+			}
+			printSnapshots(cmd.OutOrStdout(), tree, "", 0)
+			return nil
+		case imageSnapshotDelete:
+			return image.DeleteSnapshot(args[0], args[1])
+		default:
+			return image.CreateSnapshot(args[0], args[1])
+		}
+	},
+}
+
+// printSnapshots prints the children of the snapshot called parent, indented
+// according to their depth in the snapshot tree.
+func printSnapshots(w io.Writer, tree *image.SnapshotTree, parent string, depth int) {
+	for _, snapshot := range tree.Children(parent) {
+		current := ""
+		if snapshot.Name == tree.Current {
+			current = " (current)"
+		}
+		fmt.Fprintf(w, "%s%s\t%s%s\n", strings.Repeat("  ", depth), snapshot.Name, snapshot.Created.Format(time.DateTime), current)
+		printSnapshots(w, tree, snapshot.Name, depth+1)
+	}
+}
+
+var imageRevertCmd = &cobra.Command{
+	Use:   "revert <raw image> <snapshot>",
+	Short: "Revert a raw disk image to one of its snapshots",
+	Long: `Replace the content of a raw disk image with one of its snapshots. The changes made to the
+image since the last snapshot are lost. The virtual machine using the image must not be
+running.`,
+	Example:      `  vfkit image revert disk.raw base`,
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE: func(_ *cobra.Command, args []string) error {
+		return image.RevertSnapshot(args[0], args[1])
+	},
+}
+
+// progressPrinter writes the completion percentage of an operation on a
+// single line, each time it changes.
+type progressPrinter struct {
+	w           io.Writer
+	prefix      string
+	lastPercent int
+}
+
+func newProgressPrinter(w io.Writer, prefix string) *progressPrinter {
+	return &progressPrinter{w: w, prefix: prefix, lastPercent: -1}
+}
+
+func (printer *progressPrinter) update(done, total strongunits.B) {
+	percent := 100
+	if total != 0 {
+		percent = int(done * 100 / total)
+	}
+	if percent == printer.lastPercent {
+		return
+	}
+	printer.lastPercent = percent
+	fmt.Fprintf(printer.w, "\r%s: %d%% of %s", printer.prefix, percent, util.FormatSize(total))
+}
+
+// finish ends the progress line, if one was printed.
+func (printer *progressPrinter) finish() {
+	if printer.lastPercent >= 0 {
+		fmt.Fprintln(printer.w)
+	}
+}
+
+func init() {
+	imageConvertCmd.Flags().BoolVarP(&imageConvertQuiet, "quiet", "q", false, "do not show the conversion progress")
+	imageCmd.AddCommand(imageConvertCmd)
+
+	imageCreateCmd.Flags().StringVar(&imageCreateSize, "size", "", "size of the disk image, for example '20GiB', in MiB when there is no unit")
+	_ = imageCreateCmd.MarkFlagRequired("size")
+	imageCmd.AddCommand(imageCreateCmd)
+
+	imageResizeCmd.Flags().StringVar(&imageResizeSize, "size", "", "new size of the disk image, for example '40GiB', in MiB when there is no unit")
+	imageResizeCmd.Flags().BoolVar(&imageResizeShrink, "shrink", false, "allow making the disk image smaller")
+	_ = imageResizeCmd.MarkFlagRequired("size")
+	imageCmd.AddCommand(imageResizeCmd)
+
+	imageCloneCmd.Flags().StringVar(&imageCloneSnapshot, "snapshot", "", "clone this snapshot of the image instead of its current content")
+	imageCmd.AddCommand(imageCloneCmd)
+
+	imageSnapshotCmd.Flags().BoolVar(&imageSnapshotDelete, "delete", false, "delete the snapshot instead of creating it")
+	imageCmd.AddCommand(imageSnapshotCmd)
+
+	imageCmd.AddCommand(imageRevertCmd)
+
+	rootCmd.AddCommand(imageCmd)
+}
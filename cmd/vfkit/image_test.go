@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageConvertCommand(t *testing.T) {
+	var stderr bytes.Buffer
+	rootCmd.SetErr(&stderr)
+	defer rootCmd.SetErr(nil)
+
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "disk.img")
+	data := make([]byte, 4*1024*1024)
+	copy(data[1024*1024:], "vfkit")
+	require.NoError(t, os.WriteFile(srcPath, data, 0600))
+	dstPath := filepath.Join(dir, "disk.raw")
+
+	rootCmd.SetArgs([]string{"image", "convert", srcPath, dstPath})
+	err := rootCmd.Execute()
+	require.NoError(t, err)
+	converted, err := os.ReadFile(dstPath)
+	require.NoError(t, err)
+	assert.Equal(t, data, converted)
+	assert.Contains(t, stderr.String(), "\rConverting "+srcPath+": 25% of 4MiB")
+	assert.Contains(t, stderr.String(), "\rConverting "+srcPath+": 100% of 4MiB\n")
+
+	// the destination is never overwritten
+	rootCmd.SetArgs([]string{"image", "convert", "--quiet", srcPath, dstPath})
+	err = rootCmd.Execute()
+	require.ErrorIs(t, err, os.ErrExist)
+}
+
+func TestImageCreateResizeCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disk.raw")
+	fileSize := func() int64 {
+		stat, err := os.Stat(path)
+		require.NoError(t, err)
+		return stat.Size()
+	}
+
+	rootCmd.SetArgs([]string{"image", "create", "--size", "20GiB", path})
+	require.NoError(t, rootCmd.Execute())
+	assert.Equal(t, int64(20*1024*1024*1024), fileSize())
+
+	rootCmd.SetArgs([]string{"image", "resize", "--size", "40G", path})
+	require.NoError(t, rootCmd.Execute())
+	assert.Equal(t, int64(40*1024*1024*1024), fileSize())
+
+	rootCmd.SetArgs([]string{"image", "resize", "--size", "1GiB", path})
+	require.ErrorContains(t, rootCmd.Execute(), "shrinking it must be explicitly allowed")
+	rootCmd.SetArgs([]string{"image", "resize", "--shrink", "--size", "1GiB", path})
+	require.NoError(t, rootCmd.Execute())
+	assert.Equal(t, int64(1024*1024*1024), fileSize())
+
+	// sizes without unit are in MiB, like --memory
+	rootCmd.SetArgs([]string{"image", "resize", "--size", "2048", path})
+	require.NoError(t, rootCmd.Execute())
+	assert.Equal(t, int64(2*1024*1024*1024), fileSize())
+	rootCmd.SetArgs([]string{"image", "create", "--size", "20", path + ".mib"})
+	require.NoError(t, rootCmd.Execute())
+	stat, err := os.Stat(path + ".mib")
+	require.NoError(t, err)
+	assert.Equal(t, int64(20*1024*1024), stat.Size())
+
+	rootCmd.SetArgs([]string{"image", "create", "--size", "1x", path + ".new"})
+	require.ErrorContains(t, rootCmd.Execute(), "invalid size")
+}
+
+func TestImageSnapshotCommands(t *testing.T) {
+	var stdout bytes.Buffer
+	rootCmd.SetOut(&stdout)
+	defer rootCmd.SetOut(nil)
+
+	dir := t.TempDir()
+	imagePath := filepath.Join(dir, "golden.raw")
+	require.NoError(t, os.WriteFile(imagePath, []byte("base"), 0600))
+	execute := func(args ...string) error {
+		rootCmd.SetArgs(append([]string{"image"}, args...))
+		return rootCmd.Execute()
+	}
+	readFile := func(path string) string {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	require.NoError(t, execute("snapshot", imagePath, "base"))
+	require.NoError(t, os.WriteFile(imagePath, []byte("updated"), 0600))
+	require.NoError(t, execute("snapshot", imagePath, "updated"))
+
+	require.NoError(t, execute("clone", imagePath, filepath.Join(dir, "vm1.raw")))
+	assert.Equal(t, "updated", readFile(filepath.Join(dir, "vm1.raw")))
+	require.NoError(t, execute("clone", "--snapshot", "base", imagePath, filepath.Join(dir, "vm2.raw")))
+	assert.Equal(t, "base", readFile(filepath.Join(dir, "vm2.raw")))
+
+	require.NoError(t, execute("revert", imagePath, "base"))
+	assert.Equal(t, "base", readFile(imagePath))
+
+	require.NoError(t, execute("snapshot", imagePath))
+	assert.Regexp(t, regexp.MustCompile("^base\t.* \\(current\\)\n  updated\t[^(]*\n$"), stdout.String())
+
+	require.ErrorContains(t, execute("snapshot", "--delete", imagePath), "name of the snapshot to delete is missing")
+	require.NoError(t, execute("snapshot", "--delete", imagePath, "updated"))
+	require.NoError(t, execute("snapshot", "--delete", imagePath, "base"))
+	assert.NoDirExists(t, imagePath+".snapshots")
+}
@@ -20,6 +20,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -32,6 +33,7 @@ import (
 	"time"
 
 	"github.com/Code-Hex/vz/v3"
+	"github.com/containers/common/pkg/strongunits"
 	"github.com/crc-org/vfkit/pkg/cmdline"
 	"github.com/crc-org/vfkit/pkg/config"
 	"github.com/crc-org/vfkit/pkg/process"
@@ -44,112 +46,63 @@ import (
 	"github.com/crc-org/vfkit/pkg/util"
 )
 
-func newLegacyBootloader(opts *cmdline.Options) config.Bootloader {
-	if opts.VmlinuzPath == "" && opts.KernelCmdline == "" && opts.InitrdPath == "" {
-		return nil
+func newVMConfiguration(opts *cmdline.Options) (*config.VirtualMachine, error) {
+	cloudInitISO, err := generateCloudInitImage(opts.CloudInitFiles.GetSlice())
+	if err != nil {
+		return nil, err
 	}
 
-	return config.NewLinuxBootloader(
-		opts.VmlinuzPath,
-		opts.KernelCmdline,
-		opts.InitrdPath,
-	)
-}
-
-func newBootloaderConfiguration(opts *cmdline.Options) (config.Bootloader, error) {
-	legacyBootloader := newLegacyBootloader(opts)
-
-	if legacyBootloader != nil {
-		return legacyBootloader, nil
+	// if it generated a valid cloudinit config ISO file we add it to the devices
+	if cloudInitISO != "" {
+		opts.Devices = append(opts.Devices, fmt.Sprintf("virtio-blk,path=%s", cloudInitISO))
 	}
 
-	return config.BootloaderFromCmdLine(opts.Bootloader.GetSlice())
-}
-
-func newVMConfiguration(opts *cmdline.Options) (*config.VirtualMachine, error) {
-	bootloader, err := newBootloaderConfiguration(opts)
+	vmConfig, err := vmConfigurationFromOptions(opts)
 	if err != nil {
 		return nil, err
 	}
 
 	log.Debugf("parsed options: %+v", opts)
-	log.Debugf("boot parameters: %+v", bootloader)
-
-	vmConfig := config.NewVirtualMachine(
-		opts.Vcpus,
-		uint64(opts.MemoryMiB),
-		bootloader,
-	)
-	if opts.Nested && !vz.IsNestedVirtualizationSupported() {
+	log.Debugf("boot parameters: %+v", vmConfig.Bootloader)
+
+	if vmConfig.Nested && !vz.IsNestedVirtualizationSupported() {
 		return nil, fmt.Errorf("nested virtualization is not supported")
 	}
-	vmConfig.Nested = opts.Nested
 	log.Info("virtual machine parameters:")
-	log.Infof("\tvCPUs: %d", opts.Vcpus)
-	log.Infof("\tmemory: %d MiB", opts.MemoryMiB)
+	log.Infof("\tvCPUs: %d", vmConfig.Vcpus)
+	log.Infof("\tmemory: %d MiB", strongunits.ToMib(vmConfig.Memory))
 	log.Info()
 
-	if err := vmConfig.AddTimeSyncFromCmdLine(opts.TimeSync); err != nil {
-		return nil, err
-	}
+	return vmConfig, nil
+}
 
-	cloudInitISO, err := generateCloudInitImage(opts.CloudInitFiles.GetSlice())
+// printVMConfiguration prints vmConfig as JSON, followed by the vfkit command
+// line which can be used to start the same virtual machine.
+func printVMConfiguration(w io.Writer, vmConfig *config.VirtualMachine) error {
+	vmJSON, err := json.MarshalIndent(vmConfig, "", "  ")
 	if err != nil {
-		return nil, err
-	}
-
-	// if it generated a valid cloudinit config ISO file we add it to the devices
-	if cloudInitISO != "" {
-		opts.Devices = append(opts.Devices, fmt.Sprintf("virtio-blk,path=%s", cloudInitISO))
+		return err
 	}
-
-	if opts.PidFile != "" {
-		execPath, err := os.Executable()
-		if err != nil {
-			return nil, fmt.Errorf("could not determine executable path: %w", err)
-		}
-		vfProcess := process.New(os.Args[0], opts.PidFile, execPath)
-		pid := os.Getpid()
-		err = vfProcess.WritePidFile(pid)
-		if err != nil {
-			return nil, fmt.Errorf("could not write PID: %w", err)
-		}
+	args, err := vmConfig.ToCmdLine()
+	if err != nil {
+		return err
 	}
 
-	if err := vmConfig.AddDevicesFromCmdLine(opts.Devices); err != nil {
-		return nil, err
-	}
+	_, err = fmt.Fprintf(w, "%s\n%s\n", vmJSON, formatCmdLine(args))
+	return err
+}
 
-	if opts.UseGUI {
-		if len(vmConfig.VirtioGPUDevices()) == 0 {
-			log.Warnf("--gui flag specified but no virtio-gpu device configured, automatically adding it")
-			dev, err := config.VirtioGPUNew()
-			if err != nil {
-				return nil, fmt.Errorf("failed to add virtio-gpu device: %w", err)
-			}
-			dev.(*config.VirtioGPU).UsesGUI = true
-			err = vmConfig.AddDevice(dev)
-			if err != nil {
-				return nil, fmt.Errorf("failed to add virtio-gpu device: %w", err)
-			}
-		}
-		if len(vmConfig.VirtioInputDevices()) == 0 {
-			log.Warnf("--gui flag specified but no virtio-input device configured, automatically adding it")
-			dev, err := config.VirtioInputNew(config.VirtioInputKeyboardDevice)
-			if err != nil {
-				return nil, fmt.Errorf("failed to add virtio-input device: %w", err)
-			}
-			err = vmConfig.AddDevice(dev)
-			if err != nil {
-				return nil, fmt.Errorf("failed to add virtio-input device: %w", err)
-			}
-		}
+func writePidFile(pidFile string) error {
+	execPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("could not determine executable path: %w", err)
 	}
-
-	if err := vmConfig.AddIgnitionFileFromCmdLine(opts.IgnitionPath); err != nil {
-		return nil, fmt.Errorf("failed to add ignition file: %w", err)
+	vfProcess := process.New(os.Args[0], pidFile, execPath)
+	pid := os.Getpid()
+	if err := vfProcess.WritePidFile(pid); err != nil {
+		return fmt.Errorf("could not write PID: %w", err)
 	}
-	return vmConfig, nil
+	return nil
 }
 
 func waitForVMState(vm *vf.VirtualMachine, state vz.VirtualMachineState, timeout <-chan time.Time) error {
@@ -177,11 +130,6 @@ func runVFKit(vmConfig *config.VirtualMachine, opts *cmdline.Options) error {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
-	gpuDevs := vmConfig.VirtioGPUDevices()
-	if opts.UseGUI && len(gpuDevs) > 0 {
-		gpuDevs[0].UsesGUI = true
-	}
-
 	vfVM, err := vf.NewVirtualMachine(*vmConfig)
 	if err != nil {
 		return err
@@ -2,16 +2,20 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/containers/common/pkg/strongunits"
 	"github.com/crc-org/vfkit/pkg/cmdline"
 	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -196,6 +200,85 @@ func TestGUIWithExistingInputAndNoGPU(t *testing.T) {
 	assert.True(t, gpuDevices[0].UsesGUI)
 }
 
+func TestConfigFile(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "vm.yaml")
+	err := os.WriteFile(configPath, []byte(`
+vcpus: 2
+memoryBytes: 2147483648
+bootloader:
+  kind: efiBootloader
+  efiVariableStorePath: /var/vfkit/efi-store
+devices:
+  - kind: virtiorng
+`), 0600)
+	require.NoError(t, err)
+
+	opts := parseTestVMOptions(t, "--config", configPath, "--device", "virtio-input,keyboard")
+	vmConfig, err := newVMConfiguration(opts)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint(2), vmConfig.Vcpus)
+	assert.Equal(t, strongunits.GiB(2).ToBytes(), vmConfig.Memory)
+	require.IsType(t, &config.EFIBootloader{}, vmConfig.Bootloader)
+	assert.Equal(t, "/var/vfkit/efi-store", vmConfig.Bootloader.(*config.EFIBootloader).EFIVariableStorePath)
+	require.Len(t, vmConfig.Devices, 2)
+	assert.IsType(t, &config.VirtioRng{}, vmConfig.Devices[0])
+	assert.IsType(t, &config.VirtioInput{}, vmConfig.Devices[1])
+}
+
+func TestConfigFileFlagOverrides(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "vm.json")
+	err := os.WriteFile(configPath, []byte(`{"vcpus":2,"memoryBytes":2147483648,"bootloader":{"kind":"efiBootloader","efiVariableStorePath":"/var/vfkit/efi-store"}}`), 0600)
+	require.NoError(t, err)
+
+	opts := parseTestVMOptions(t, "--config", configPath, "--cpus", "4", "--bootloader", "efi,variable-store=/tmp/efi-store")
+	vmConfig, err := newVMConfiguration(opts)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint(4), vmConfig.Vcpus)
+	assert.Equal(t, strongunits.GiB(2).ToBytes(), vmConfig.Memory)
+	require.IsType(t, &config.EFIBootloader{}, vmConfig.Bootloader)
+	assert.Equal(t, "/tmp/efi-store", vmConfig.Bootloader.(*config.EFIBootloader).EFIVariableStorePath)
+}
+
+func TestConfigFileMissingBootloader(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "vm.json")
+	err := os.WriteFile(configPath, []byte(`{"vcpus":2,"memoryBytes":2147483648}`), 0600)
+	require.NoError(t, err)
+
+	opts := parseTestVMOptions(t, "--config", configPath)
+	_, err = newVMConfiguration(opts)
+	require.ErrorContains(t, err, "missing bootloader configuration")
+}
+
+func TestPrintVMConfiguration(t *testing.T) {
+	opts := parseTestVMOptions(t, "--kernel", "/vmlinuz", "--initrd", "/initrd", "--kernel-cmdline", "console=hvc0 root=/dev/vda", "--gui", "--timesync", "vsockPort=1234")
+	vmConfig, err := newVMConfiguration(opts)
+	require.NoError(t, err)
+
+	var output bytes.Buffer
+	err = printVMConfiguration(&output, vmConfig)
+	require.NoError(t, err)
+
+	// the JSON configuration is followed by the command line on the last line
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	require.Greater(t, len(lines), 1)
+	cmdline := lines[len(lines)-1]
+	var printedConfig config.VirtualMachine
+	err = json.Unmarshal([]byte(strings.Join(lines[:len(lines)-1], "\n")), &printedConfig)
+	require.NoError(t, err)
+	assert.Equal(t, vmConfig, &printedConfig)
+
+	assert.Equal(t, "vfkit --cpus 1 --memory 512 --kernel /vmlinuz --initrd /initrd --kernel-cmdline 'console=hvc0 root=/dev/vda' --device virtio-gpu,width=800,height=600 --device virtio-input,keyboard --timesync vsockPort=1234 --gui", cmdline)
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, "virtio-blk,path=/Users/user/disk.img", shellQuote("virtio-blk,path=/Users/user/disk.img"))
+	assert.Equal(t, "''", shellQuote(""))
+	assert.Equal(t, "'console=hvc0 root=/dev/vda'", shellQuote("console=hvc0 root=/dev/vda"))
+	assert.Equal(t, `'it'\''s'`, shellQuote("it's"))
+}
+
 func getTestAssetsDir() (string, error) {
 	currentDir, err := os.Getwd()
 	if err != nil {
@@ -216,3 +299,12 @@ func getTestVMOptions(t *testing.T) *cmdline.Options {
 	require.NoError(t, err)
 	return opts
 }
+
+func parseTestVMOptions(t *testing.T, args ...string) *cmdline.Options {
+	opts := &cmdline.Options{}
+	cmd := &cobra.Command{}
+	cmdline.AddFlags(cmd, opts)
+	err := cmd.ParseFlags(args)
+	require.NoError(t, err)
+	return opts
+}
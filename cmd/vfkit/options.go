@@ -0,0 +1,156 @@
+//go:build darwin
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/containers/common/pkg/strongunits"
+	"github.com/crc-org/vfkit/pkg/cmdline"
+	"github.com/crc-org/vfkit/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+func newLegacyBootloader(opts *cmdline.Options) config.Bootloader {
+	if opts.VmlinuzPath == "" && opts.KernelCmdline == "" && opts.InitrdPath == "" {
+		return nil
+	}
+
+	return config.NewLinuxBootloader(
+		opts.VmlinuzPath,
+		opts.KernelCmdline,
+		opts.InitrdPath,
+	)
+}
+
+func newBootloaderConfiguration(opts *cmdline.Options) (config.Bootloader, error) {
+	legacyBootloader := newLegacyBootloader(opts)
+
+	if legacyBootloader != nil {
+		return legacyBootloader, nil
+	}
+
+	return config.BootloaderFromCmdLine(opts.Bootloader.GetSlice())
+}
+
+// newVMConfigurationFromFile loads the virtual machine configuration file
+// specified with --config, and applies the command line flags which were
+// explicitly set on top of it.
+func newVMConfigurationFromFile(opts *cmdline.Options) (*config.VirtualMachine, error) {
+	vmConfig, err := config.VirtualMachineFromFile(opts.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if newLegacyBootloader(opts) != nil || opts.IsSet("bootloader") {
+		bootloader, err := newBootloaderConfiguration(opts)
+		if err != nil {
+			return nil, err
+		}
+		vmConfig.Bootloader = bootloader
+	}
+	if opts.IsSet("cpus") {
+		vmConfig.Vcpus = opts.Vcpus
+	}
+	if opts.IsSet("memory") {
+		vmConfig.Memory = strongunits.MiB(opts.MemoryMiB).ToBytes()
+	}
+	if opts.IsSet("nested") {
+		vmConfig.Nested = opts.Nested
+	}
+	if vmConfig.Bootloader == nil {
+		return nil, fmt.Errorf("missing bootloader configuration in %s", opts.ConfigPath)
+	}
+
+	return vmConfig, nil
+}
+
+// vmConfigurationFromOptions creates the virtual machine configuration
+// described by opts. It reads the --config file and logs a warning when
+// devices are added for --gui. Unlike newVMConfiguration, it does not create
+// any file, it does not depend on the host capabilities, and it ignores
+// --cloud-init.
+func vmConfigurationFromOptions(opts *cmdline.Options) (*config.VirtualMachine, error) {
+	var vmConfig *config.VirtualMachine
+
+	if opts.ConfigPath != "" {
+		var err error
+		vmConfig, err = newVMConfigurationFromFile(opts)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		bootloader, err := newBootloaderConfiguration(opts)
+		if err != nil {
+			return nil, err
+		}
+		vmConfig = config.NewVirtualMachine(
+			opts.Vcpus,
+			uint64(opts.MemoryMiB),
+			bootloader,
+		)
+		vmConfig.Nested = opts.Nested
+	}
+
+	if err := vmConfig.AddTimeSyncFromCmdLine(opts.TimeSync); err != nil {
+		return nil, err
+	}
+
+	if err := vmConfig.AddDevicesFromCmdLine(opts.Devices); err != nil {
+		return nil, err
+	}
+
+	if opts.UseGUI {
+		if len(vmConfig.VirtioGPUDevices()) == 0 {
+			log.Warnf("--gui flag specified but no virtio-gpu device configured, automatically adding it")
+			dev, err := config.VirtioGPUNew()
+			if err != nil {
+				return nil, fmt.Errorf("failed to add virtio-gpu device: %w", err)
+			}
+			err = vmConfig.AddDevice(dev)
+			if err != nil {
+				return nil, fmt.Errorf("failed to add virtio-gpu device: %w", err)
+			}
+		}
+		vmConfig.VirtioGPUDevices()[0].UsesGUI = true
+		if len(vmConfig.VirtioInputDevices()) == 0 {
+			log.Warnf("--gui flag specified but no virtio-input device configured, automatically adding it")
+			dev, err := config.VirtioInputNew(config.VirtioInputKeyboardDevice)
+			if err != nil {
+				return nil, fmt.Errorf("failed to add virtio-input device: %w", err)
+			}
+			err = vmConfig.AddDevice(dev)
+			if err != nil {
+				return nil, fmt.Errorf("failed to add virtio-input device: %w", err)
+			}
+		}
+	}
+
+	if err := vmConfig.AddIgnitionFileFromCmdLine(opts.IgnitionPath); err != nil {
+		return nil, fmt.Errorf("failed to add ignition file: %w", err)
+	}
+	return vmConfig, nil
+}
+
+// formatCmdLine returns the vfkit command line using args, quoted so that it
+// can be pasted in a shell
+func formatCmdLine(args []string) string {
+	quotedArgs := []string{"vfkit"}
+	for _, arg := range args {
+		quotedArgs = append(quotedArgs, shellQuote(arg))
+	}
+	return strings.Join(quotedArgs, " ")
+}
+
+// shellQuote quotes str so that it's interpreted as a single word by POSIX
+// shells
+func shellQuote(str string) string {
+	if str != "" && strings.IndexFunc(str, func(r rune) bool {
+		return !strings.ContainsRune("-_=+,.:/@%", r) && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) == -1 {
+		return str
+	}
+	return "'" + strings.ReplaceAll(str, "'", `'\''`) + "'"
+}
@@ -29,6 +29,21 @@ var rootCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		if opts.DryRun {
+			defer util.ExecuteExitHandlers()
+			if err := printVMConfiguration(os.Stdout, vmConfig); err != nil {
+				return err
+			}
+			return vmConfig.Validate()
+		}
+		if err := vmConfig.Validate(); err != nil {
+			return err
+		}
+		if opts.PidFile != "" {
+			if err := writePidFile(opts.PidFile); err != nil {
+				return err
+			}
+		}
 		// if vfkit stop execution by itself, i.e. when VM stops by guest OS
 		// we need to call ExecuteExitHandlers to clean up
 		defer util.ExecuteExitHandlers()
@@ -61,6 +76,7 @@ func getLogLevel() (logrus.Level, error) {
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprint(os.Stderr, deviceArgErrorDetails(err))
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,30 @@
+//go:build darwin
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON schema of the virtual machine configuration file",
+	Long: `Print a JSON Schema document describing the format of the virtual machine
+configuration files which can be used with --config.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		schema, err := config.JSONSchema()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(cmd.OutOrStdout(), string(schema))
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+}
@@ -0,0 +1,46 @@
+//go:build darwin
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/crc-org/vfkit/pkg/cmdline"
+	"github.com/crc-org/vfkit/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+var validateOpts = &cmdline.Options{}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the virtual machine configuration without starting it",
+	Long: `Check the virtual machine configuration specified with the same options as
+the ones used to start a virtual machine, and print all the problems found.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		vmConfig, err := newVMConfiguration(validateOpts)
+		// the configuration may have registered cleanup handlers, for
+		// example for the cloud-init ISO image
+		defer util.ExecuteExitHandlers()
+		if err != nil {
+			return err
+		}
+		if validateOpts.DryRun {
+			if err := printVMConfiguration(cmd.OutOrStdout(), vmConfig); err != nil {
+				return err
+			}
+		}
+		if err := vmConfig.Validate(); err != nil {
+			return fmt.Errorf("invalid virtual machine configuration:\n%w", err)
+		}
+		_, err = fmt.Fprintln(cmd.OutOrStdout(), "virtual machine configuration is valid")
+		return err
+	},
+}
+
+func init() {
+	cmdline.AddFlags(validateCmd, validateOpts)
+	rootCmd.AddCommand(validateCmd)
+}
@@ -2,6 +2,7 @@ package cmdline
 
 import (
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 type Options struct {
@@ -31,11 +32,19 @@ type Options struct {
 	Nested bool
 
 	PidFile string
+
+	ConfigPath string
+
+	DryRun bool
+
+	flags *pflag.FlagSet
 }
 
 const DefaultRestfulURI = "none://"
 
 func AddFlags(cmd *cobra.Command, opts *Options) {
+	opts.flags = cmd.Flags()
+
 	cmd.Flags().StringVarP(&opts.VmlinuzPath, "kernel", "k", "", "path to the virtual machine Linux kernel")
 	cmd.Flags().StringVarP(&opts.KernelCmdline, "kernel-cmdline", "C", "", "Linux kernel command line")
 	cmd.Flags().StringVarP(&opts.InitrdPath, "initrd", "i", "", "path to the virtual machine initrd")
@@ -49,11 +58,11 @@ func AddFlags(cmd *cobra.Command, opts *Options) {
 	cmd.MarkFlagsRequiredTogether("kernel", "initrd", "kernel-cmdline")
 
 	cmd.Flags().UintVarP(&opts.Vcpus, "cpus", "c", 1, "number of virtual CPUs")
-	// FIXME: use go-units for parsing
-	cmd.Flags().UintVarP(&opts.MemoryMiB, "memory", "m", 512, "virtual machine RAM size in mibibytes")
+	cmd.Flags().VarP(newMemorySizeValue(512, &opts.MemoryMiB), "memory", "m", "virtual machine RAM size, in mebibytes when no unit such as 'GiB' is given. All units are powers of 1024, 'G' and 'GB' mean 'GiB'")
 
 	cmd.Flags().StringVarP(&opts.TimeSync, "timesync", "t", "", "sync guest time when host wakes up from sleep")
-	cmd.Flags().StringArrayVarP(&opts.Devices, "device", "d", []string{}, "devices")
+	cmd.Flags().StringArrayVarP(&opts.Devices, "device", "d", []string{}, "devices, see 'vfkit devices' for the supported device types")
+	_ = cmd.RegisterFlagCompletionFunc("device", completeDevice)
 
 	cmd.Flags().StringVar(&opts.LogLevel, "log-level", "", "set log level")
 	cmd.Flags().StringVar(&opts.RestfulURI, "restful-uri", DefaultRestfulURI, "URI address for RESTful services")
@@ -62,4 +71,16 @@ func AddFlags(cmd *cobra.Command, opts *Options) {
 	cmd.Flags().VarP(&opts.CloudInitFiles, "cloud-init", "", "path to user-data and meta-data cloud-init configuration files")
 	cmd.Flags().BoolVarP(&opts.Nested, "nested", "n", false, "enable nested virtualization")
 	cmd.Flags().StringVar(&opts.PidFile, "pidfile", "", "path to the pid file")
+	cmd.Flags().StringVar(&opts.ConfigPath, "config", "", "path to a JSON or YAML virtual machine configuration file")
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "print the virtual machine configuration and exit without starting it")
+}
+
+// IsSet returns true if the flag called name was explicitly set on the
+// command line. It always returns false when opts was not registered with
+// AddFlags.
+func (opts *Options) IsSet(name string) bool {
+	if opts.flags == nil {
+		return false
+	}
+	return opts.flags.Changed(name)
 }
@@ -0,0 +1,72 @@
+package cmdline
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+// completeDevice is the shell completion function of --device. It completes
+// the device type, and then the option keys and values of the device.
+func completeDevice(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	// more options can be added after the completed text
+	return deviceCompletions(toComplete), cobra.ShellCompDirectiveNoSpace | cobra.ShellCompDirectiveNoFileComp
+}
+
+// deviceCompletions returns the possible completions of the --device argument
+// arg.
+func deviceCompletions(arg string) []string {
+	completions := []string{}
+	lastComma := strings.LastIndexByte(arg, ',')
+	if lastComma == -1 {
+		for _, devType := range config.DeviceTypes() {
+			if strings.HasPrefix(devType.Name, arg) {
+				completions = append(completions, devType.Name)
+			}
+		}
+		return completions
+	}
+
+	head, current := arg[:lastComma+1], arg[lastComma+1:]
+	fields := strings.Split(head[:lastComma], ",")
+	opts, err := config.DeviceOptions(fields[0])
+	if err != nil {
+		return completions
+	}
+	usedKeys := []string{}
+	for _, field := range fields[1:] {
+		key, _, _ := strings.Cut(field, "=")
+		usedKeys = append(usedKeys, key)
+	}
+
+	if key, value, ok := strings.Cut(current, "="); ok {
+		for _, opt := range opts {
+			if !opt.HasValue() || !slices.Contains(opt.Names, key) {
+				continue
+			}
+			for _, allowed := range opt.Values {
+				if strings.HasPrefix(allowed, value) {
+					completions = append(completions, head+key+"="+allowed)
+				}
+			}
+		}
+		return completions
+	}
+
+	for _, opt := range opts {
+		if slices.ContainsFunc(opt.Names, func(name string) bool { return slices.Contains(usedKeys, name) }) {
+			continue
+		}
+		for _, name := range opt.Names {
+			if opt.HasValue() {
+				name += "="
+			}
+			if strings.HasPrefix(name, current) {
+				completions = append(completions, head+name)
+			}
+		}
+	}
+	return completions
+}
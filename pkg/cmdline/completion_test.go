@@ -0,0 +1,36 @@
+package cmdline
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceCompletions(t *testing.T) {
+	for arg, expected := range map[string][]string{
+		"virtio-s":                       {"virtio-serial"},
+		"n":                              {"nbd", "nvme"},
+		"virtio-vsock,":                  {"virtio-vsock,id=", "virtio-vsock,port=", "virtio-vsock,socketURL=", "virtio-vsock,listen", "virtio-vsock,connect"},
+		"virtio-vsock,port=1024,l":       {"virtio-vsock,port=1024,listen"},
+		"virtio-vsock,connect,":          {"virtio-vsock,connect,id=", "virtio-vsock,connect,port=", "virtio-vsock,connect,socketURL="},
+		"virtio-blk,path=disk.img,type=": {"virtio-blk,path=disk.img,type=image", "virtio-blk,path=disk.img,type=dev"},
+		"nbd,sync=f":                     {"nbd,sync=full"},
+		"virtio-net,nat,vfkitMagic=":     {"virtio-net,nat,vfkitMagic=on", "virtio-net,nat,vfkitMagic=off"},
+		"virtio-fs,sharedDir=":           {},
+		"virtio-foo,":                    {},
+	} {
+		assert.Equal(t, expected, deviceCompletions(arg), arg)
+	}
+}
+
+func TestDeviceFlagCompletion(t *testing.T) {
+	cmd := &cobra.Command{Use: "vfkit"}
+	AddFlags(cmd, &Options{})
+	completion, ok := cmd.GetFlagCompletionFunc("device")
+	require.True(t, ok)
+	completions, directive := completion(cmd, nil, "virtio-r")
+	assert.Equal(t, []string{"virtio-rng"}, completions)
+	assert.Equal(t, cobra.ShellCompDirectiveNoSpace|cobra.ShellCompDirectiveNoFileComp, directive)
+}
@@ -0,0 +1,39 @@
+package cmdline
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/containers/common/pkg/strongunits"
+	"github.com/crc-org/vfkit/pkg/util"
+)
+
+// -- memorySize Value
+// memorySizeValue parses sizes with an optional unit such as '4GiB' or '2G'
+// into a number of mebibytes. Sizes without unit are in mebibytes.
+type memorySizeValue uint
+
+func newMemorySizeValue(val uint, p *uint) *memorySizeValue {
+	*p = val
+	return (*memorySizeValue)(p)
+}
+
+func (m *memorySizeValue) Set(val string) error {
+	size, err := util.ParseSize(val, strongunits.MiB(1))
+	if err != nil {
+		return err
+	}
+	if size%strongunits.MiB(1).ToBytes() != 0 {
+		return fmt.Errorf("invalid size: %s (must be a multiple of 1MiB)", val)
+	}
+	*m = memorySizeValue(strongunits.ToMib(size))
+	return nil
+}
+
+func (m *memorySizeValue) Type() string {
+	return "size"
+}
+
+func (m *memorySizeValue) String() string {
+	return strconv.FormatUint(uint64(*m), 10)
+}
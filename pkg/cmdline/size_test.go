@@ -0,0 +1,30 @@
+package cmdline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemorySize(t *testing.T) {
+	var memoryMiB uint
+	value := newMemorySizeValue(512, &memoryMiB)
+	assert.Equal(t, "512", value.String())
+
+	for str, expected := range map[string]uint{
+		"2048":   2048,
+		"4GiB":   4096,
+		"2G":     2048,
+		"1536M":  1536,
+		"1024KB": 1,
+	} {
+		require.NoError(t, value.Set(str), str)
+		assert.Equal(t, expected, memoryMiB, str)
+	}
+
+	require.NoError(t, value.Set("1024KB"))
+	require.EqualError(t, value.Set("1000KiB"), "invalid size: 1000KiB (must be a multiple of 1MiB)")
+	require.EqualError(t, value.Set("large"), "invalid size: large")
+	assert.Equal(t, uint(1), memoryMiB)
+}
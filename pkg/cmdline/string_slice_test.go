@@ -185,6 +185,28 @@ func TestSSWithComma(t *testing.T) {
 	}
 }
 
+func TestSSWithEscapedQuotes(t *testing.T) {
+	var ss stringSliceValue
+	f := setUpSSFlagSet(&ss)
+
+	err := f.Parse([]string{`--ss=efi,variable-store="/a,b\"c",create`})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	// the quotes and escapes are kept for the option parser
+	expected := []string{"efi", `variable-store="/a,b\"c"`, "create"}
+	values := ss.GetSlice()
+	if len(expected) != len(values) {
+		t.Fatalf("expected number of values to be %d but got: %d", len(expected), len(values))
+	}
+	for i, v := range values {
+		if expected[i] != v {
+			t.Fatalf("expected got ss[%d] to be %s but got: %s", i, expected[i], v)
+		}
+	}
+}
+
 func TestSSWithSquareBrackets(t *testing.T) {
 	var ss stringSliceValue
 	f := setUpSSFlagSet(&ss)
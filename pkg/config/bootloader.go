@@ -11,7 +11,7 @@ import (
 // boot the virtual machine. It is mandatory to set a Bootloader or the virtual
 // machine won't start.
 type Bootloader interface {
-	FromOptions(options []option) error
+	FromOptions(options []Option) error
 	ToCmdLine() ([]string, error)
 }
 
@@ -49,17 +49,17 @@ func NewLinuxBootloader(vmlinuzPath, kernelCmdLine, initrdPath string) *LinuxBoo
 	}
 }
 
-func (bootloader *LinuxBootloader) FromOptions(options []option) error {
+func (bootloader *LinuxBootloader) FromOptions(options []Option) error {
 	for _, option := range options {
-		switch option.key {
+		switch option.Key {
 		case "kernel":
-			bootloader.VmlinuzPath = option.value
+			bootloader.VmlinuzPath = option.Value
 		case "cmdline":
-			bootloader.KernelCmdLine = util.TrimQuotes(option.value)
+			bootloader.KernelCmdLine = util.TrimQuotes(option.Value)
 		case "initrd":
-			bootloader.InitrdPath = option.value
+			bootloader.InitrdPath = option.Value
 		default:
-			return fmt.Errorf("unknown option for Linux bootloaders: %s", option.key)
+			return fmt.Errorf("unknown option for Linux bootloaders: %s", option.Key)
 		}
 	}
 	return nil
@@ -95,18 +95,18 @@ func NewEFIBootloader(efiVariableStorePath string, createVariableStore bool) *EF
 	}
 }
 
-func (bootloader *EFIBootloader) FromOptions(options []option) error {
+func (bootloader *EFIBootloader) FromOptions(options []Option) error {
 	for _, option := range options {
-		switch option.key {
+		switch option.Key {
 		case "variable-store":
-			bootloader.EFIVariableStorePath = option.value
+			bootloader.EFIVariableStorePath = option.Value
 		case "create":
-			if option.value != "" {
-				return fmt.Errorf("unexpected value for EFI bootloader 'create' option: %s", option.value)
+			if option.Value != "" {
+				return fmt.Errorf("unexpected value for EFI bootloader 'create' option: %s", option.Value)
 			}
 			bootloader.CreateVariableStore = true
 		default:
-			return fmt.Errorf("unknown option for EFI bootloaders: %s", option.key)
+			return fmt.Errorf("unknown option for EFI bootloaders: %s", option.Key)
 		}
 	}
 	return nil
@@ -119,7 +119,7 @@ func (bootloader *EFIBootloader) ToCmdLine() ([]string, error) {
 
 	builder := strings.Builder{}
 	builder.WriteString("efi")
-	builder.WriteString(fmt.Sprintf(",variable-store=%s", bootloader.EFIVariableStorePath))
+	builder.WriteString(fmt.Sprintf(",variable-store=%s", quoteOptionValue(bootloader.EFIVariableStorePath)))
 	if bootloader.CreateVariableStore {
 		builder.WriteString(",create")
 	}
@@ -127,17 +127,17 @@ func (bootloader *EFIBootloader) ToCmdLine() ([]string, error) {
 	return []string{"--bootloader", builder.String()}, nil
 }
 
-func (bootloader *MacOSBootloader) FromOptions(options []option) error {
+func (bootloader *MacOSBootloader) FromOptions(options []Option) error {
 	for _, option := range options {
-		switch option.key {
+		switch option.Key {
 		case "machineIdentifierPath":
-			bootloader.MachineIdentifierPath = option.value
+			bootloader.MachineIdentifierPath = option.Value
 		case "hardwareModelPath":
-			bootloader.HardwareModelPath = option.value
+			bootloader.HardwareModelPath = option.Value
 		case "auxImagePath":
-			bootloader.AuxImagePath = option.value
+			bootloader.AuxImagePath = option.Value
 		default:
-			return fmt.Errorf("unknown option for macOS bootloaders: %s", option.key)
+			return fmt.Errorf("unknown option for macOS bootloaders: %s", option.Key)
 		}
 	}
 	return nil
@@ -149,6 +149,15 @@ func (bootloader *MacOSBootloader) ToCmdLine() ([]string, error) {
 	return args, nil
 }
 
+// BootloaderTypes returns the bootloader types which can be used with
+// --bootloader.
+func BootloaderTypes() []string {
+	return []string{"efi", "linux", "macos"}
+}
+
+// BootloaderFromCmdLine parses the --bootloader options, which are already
+// split on the ',' characters which are not quoted. Quoted values are
+// unquoted as described in splitOptions.
 func BootloaderFromCmdLine(optsStrv []string) (Bootloader, error) {
 	var bootloader Bootloader
 
@@ -166,7 +175,15 @@ func BootloaderFromCmdLine(optsStrv []string) (Bootloader, error) {
 	default:
 		return nil, fmt.Errorf("unknown bootloader type: %s", bootloaderType)
 	}
-	options := strvToOptions(optsStrv[1:])
+	opts := make([]string, 0, len(optsStrv)-1)
+	for _, opt := range optsStrv[1:] {
+		unquoted, err := unquoteSplitOption(opt)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, unquoted)
+	}
+	options := strvToOptions(opts)
 	if err := bootloader.FromOptions(options); err != nil {
 		return nil, err
 	}
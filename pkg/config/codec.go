@@ -0,0 +1,617 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// The command line options of the devices are described with struct tags on
+// the device fields:
+//
+//	Path string `option:"path,required" help:"path to the disk image"`
+//
+// The first element of the `option` tag is the option name. Several names
+// separated with '|' describe a choice between options without a value, such
+// as 'listen|connect'. For bool fields, the first choice sets the field to
+// true, and the second one to false. For string fields, the name of the
+// chosen option is stored in the field.
+//
+// The other elements of the tag are:
+//   - flag: the option has no value, it's only valid for bool fields
+//   - required: the option must be set
+//   - oneof=group: exactly one of the options of this group must be set
+//   - min=N: the minimum value of numeric options
+//
+// Mandatory options (required or oneof) are emitted first, followed by the
+// other options with a value, and then by the options without a value. The
+// order of the struct fields is kept within these groups, fields of embedded
+// structs are part of the embedding struct. Fields with a zero value are not
+// emitted. time.Duration values are in milliseconds.
+//
+// The possible values of string types listed in schemaEnums are also
+// enforced.
+
+var (
+	durationType     = reflect.TypeOf(time.Duration(0))
+	hardwareAddrType = reflect.TypeOf(net.HardwareAddr{})
+	fileType         = reflect.TypeOf(&os.File{})
+)
+
+// optionSpec describes a command line option generated from an `option`
+// struct tag.
+type optionSpec struct {
+	names    []string
+	index    []int
+	typ      reflect.Type
+	flag     bool
+	required bool
+	oneOf    string
+	min      *int64
+	help     string
+}
+
+// name is the name of the option used in messages
+func (spec *optionSpec) name() string {
+	return strings.Join(spec.names, "|")
+}
+
+func (spec *optionSpec) isChoice() bool {
+	return len(spec.names) > 1
+}
+
+// deviceOptionSpecs returns the options of the device struct type typ
+func deviceOptionSpecs(typ reflect.Type) ([]*optionSpec, error) {
+	specs := []*optionSpec{}
+	if err := collectOptionSpecs(typ, nil, &specs); err != nil {
+		return nil, fmt.Errorf("%s: %w", typ.Name(), err)
+	}
+	return specs, nil
+}
+
+func collectOptionSpecs(typ reflect.Type, index []int, specs *[]*optionSpec) error {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		fieldIndex := append(slices.Clone(index), i)
+		tag, hasTag := field.Tag.Lookup("option")
+		if !hasTag {
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				if err := collectOptionSpecs(field.Type, fieldIndex, specs); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		spec, err := parseOptionTag(tag)
+		if err != nil {
+			return fmt.Errorf("invalid option tag for %s: %w", field.Name, err)
+		}
+		spec.index = fieldIndex
+		spec.typ = field.Type
+		spec.help = field.Tag.Get("help")
+		if err := spec.checkType(); err != nil {
+			return fmt.Errorf("invalid option tag for %s: %w", field.Name, err)
+		}
+		*specs = append(*specs, spec)
+	}
+
+	return nil
+}
+
+func parseOptionTag(tag string) (*optionSpec, error) {
+	elems := strings.Split(tag, ",")
+	if elems[0] == "" {
+		return nil, fmt.Errorf("missing option name")
+	}
+	spec := &optionSpec{
+		names: strings.Split(elems[0], "|"),
+	}
+	for _, elem := range elems[1:] {
+		key, value, _ := strings.Cut(elem, "=")
+		switch key {
+		case "flag":
+			spec.flag = true
+		case "required":
+			spec.required = true
+		case "oneof":
+			spec.oneOf = value
+		case "min":
+			minValue, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			spec.min = &minValue
+		default:
+			return nil, fmt.Errorf("unknown element '%s'", elem)
+		}
+	}
+
+	return spec, nil
+}
+
+func (spec *optionSpec) checkType() error {
+	if spec.isChoice() {
+		if len(spec.names) != 2 && spec.typ.Kind() == reflect.Bool {
+			return fmt.Errorf("choices for bool fields must have 2 options")
+		}
+		if spec.typ.Kind() != reflect.Bool && spec.typ.Kind() != reflect.String {
+			return fmt.Errorf("choices are only supported for bool and string fields")
+		}
+		return nil
+	}
+	if spec.typ.Kind() == reflect.Bool && !spec.flag {
+		return fmt.Errorf("bool fields must be flags")
+	}
+	if spec.flag && spec.typ.Kind() != reflect.Bool {
+		return fmt.Errorf("flags must be bool fields")
+	}
+	if spec.min != nil && !isIntegerKind(spec.typ.Kind()) {
+		return fmt.Errorf("'min' is only supported for integer fields")
+	}
+	switch spec.typ {
+	case durationType, hardwareAddrType, fileType:
+		return nil
+	}
+	switch kind := spec.typ.Kind(); {
+	case kind == reflect.Bool, kind == reflect.String, isIntegerKind(kind):
+		return nil
+	default:
+		return fmt.Errorf("unsupported type %s", spec.typ)
+	}
+}
+
+func isIntegerKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	default:
+		return false
+	}
+}
+
+// enumValues returns the allowed values for the option, or nil if any value
+// is allowed. Empty values are not included as they mean the option is not
+// set.
+func (spec *optionSpec) enumValues() []string {
+	if spec.isChoice() {
+		return spec.names
+	}
+	values := []string{}
+	for _, value := range schemaEnums[spec.typ] {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// set sets the field to the value of opt. choice is the index of opt.Key in
+// spec.names.
+func (spec *optionSpec) set(devName string, field reflect.Value, opt Option, choice int) error {
+	if spec.flag || spec.isChoice() {
+		if opt.Value != "" {
+			return &InvalidValueError{DeviceType: devName, Key: opt.Key, Value: opt.Value}
+		}
+		if field.Kind() == reflect.Bool {
+			field.SetBool(choice == 0)
+		} else {
+			field.SetString(opt.Key)
+		}
+		return nil
+	}
+	if opt.Value == "" {
+		return &InvalidValueError{DeviceType: devName, Key: opt.Key}
+	}
+	invalidValue := func(expected string) error {
+		return &InvalidValueError{DeviceType: devName, Key: opt.Key, Value: opt.Value, Expected: expected}
+	}
+
+	switch spec.typ {
+	case durationType:
+		ms, err := strconv.ParseInt(opt.Value, 10, 32)
+		if err != nil {
+			return invalidValue("a duration in milliseconds")
+		}
+		field.SetInt(int64(time.Duration(ms) * time.Millisecond))
+		return nil
+	case hardwareAddrType:
+		macAddress, err := net.ParseMAC(opt.Value)
+		if err != nil {
+			return invalidValue("a MAC address")
+		}
+		field.SetBytes(macAddress)
+		return nil
+	case fileType:
+		fd, err := strconv.Atoi(opt.Value)
+		if err != nil || fd < 0 {
+			return invalidValue("a file descriptor number")
+		}
+		field.Set(reflect.ValueOf(os.NewFile(uintptr(fd), fmt.Sprintf("vfkit %s file", devName))))
+		return nil
+	}
+
+	switch kind := field.Kind(); {
+	case kind == reflect.String:
+		if values := spec.enumValues(); values != nil && !slices.Contains(values, opt.Value) {
+			return invalidValue(strings.Join(values, "|"))
+		}
+		field.SetString(opt.Value)
+	case field.CanInt():
+		value, err := strconv.ParseInt(opt.Value, 10, field.Type().Bits())
+		if err != nil {
+			return invalidValue("an integer")
+		}
+		field.SetInt(value)
+	case field.CanUint():
+		value, err := strconv.ParseUint(opt.Value, 10, field.Type().Bits())
+		if err != nil {
+			return invalidValue("a positive integer")
+		}
+		field.SetUint(value)
+	}
+
+	return nil
+}
+
+// value returns the command line representation of the option for field, or
+// an empty string if the option must not be emitted.
+func (spec *optionSpec) value(field reflect.Value) string {
+	if spec.isChoice() && field.Kind() == reflect.Bool {
+		if field.Bool() {
+			return spec.names[0]
+		}
+		return spec.names[1]
+	}
+	if field.IsZero() {
+		return ""
+	}
+	if spec.flag || spec.isChoice() {
+		if field.Kind() == reflect.Bool {
+			return spec.names[0]
+		}
+		return field.String()
+	}
+
+	var value string
+	switch spec.typ {
+	case durationType:
+		ms := time.Duration(field.Int()).Milliseconds()
+		if ms <= 0 {
+			return ""
+		}
+		value = strconv.FormatInt(ms, 10)
+	case hardwareAddrType:
+		value = net.HardwareAddr(field.Bytes()).String()
+	case fileType:
+		value = strconv.FormatUint(uint64(field.Interface().(*os.File).Fd()), 10)
+	default:
+		value = fmt.Sprint(field.Interface())
+	}
+
+	return fmt.Sprintf("%s=%s", spec.names[0], quoteOptionValue(value))
+}
+
+// check validates the value of the option for field.
+func (spec *optionSpec) check(devName string, field reflect.Value) error {
+	if spec.required && field.IsZero() {
+		return &MissingOptionError{DeviceType: devName, Key: spec.name()}
+	}
+	if spec.min != nil {
+		var tooSmall bool
+		if field.CanInt() {
+			tooSmall = field.Int() < *spec.min
+		} else {
+			tooSmall = field.Uint() < uint64(max(*spec.min, 0))
+		}
+		if tooSmall {
+			return &InvalidValueError{DeviceType: devName, Key: spec.name(), Value: fmt.Sprint(field.Interface()), Expected: fmt.Sprintf("a value >= %d", *spec.min)}
+		}
+	}
+	if field.Kind() == reflect.String && field.String() != "" {
+		if values := spec.enumValues(); values != nil && !slices.Contains(values, field.String()) {
+			return &InvalidValueError{DeviceType: devName, Key: spec.name(), Value: field.String(), Expected: strings.Join(values, "|")}
+		}
+	}
+
+	return nil
+}
+
+// optionsFromDevice returns the command line options of dev, without the
+// device type.
+func optionsFromDevice(dev VirtioDevice) ([]string, error) {
+	specs, err := deviceOptionSpecs(reflect.TypeOf(dev).Elem())
+	if err != nil {
+		return nil, err
+	}
+	val := reflect.ValueOf(dev).Elem()
+	var mandatoryOpts, valueOpts, flagOpts []string
+	for _, spec := range specs {
+		value := spec.value(val.FieldByIndex(spec.index))
+		switch {
+		case value == "":
+		case spec.required || spec.oneOf != "":
+			mandatoryOpts = append(mandatoryOpts, value)
+		case spec.flag || spec.isChoice():
+			flagOpts = append(flagOpts, value)
+		default:
+			valueOpts = append(valueOpts, value)
+		}
+	}
+
+	return slices.Concat(mandatoryOpts, valueOpts, flagOpts), nil
+}
+
+// EncodeDeviceOptions generates the --device command line argument for dev
+// from its `option` struct tags. It can be used to implement the ToCmdLine
+// method of the devices registered with RegisterDeviceType.
+func EncodeDeviceOptions(dev VirtioDevice) ([]string, error) {
+	if err := validateDeviceOptions(dev); err != nil {
+		return nil, err
+	}
+	opts, err := optionsFromDevice(dev)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{"--device", strings.Join(append([]string{deviceTypeName(dev)}, opts...), ",")}, nil
+}
+
+// DecodeDeviceOptions sets the fields of dev from the command line options,
+// and validates the resulting device. It can be used to implement the
+// FromOptions method of the devices registered with RegisterDeviceType.
+func DecodeDeviceOptions(dev VirtioDevice, options []Option) error {
+	devName := deviceTypeName(dev)
+	specs, err := deviceOptionSpecs(reflect.TypeOf(dev).Elem())
+	if err != nil {
+		return err
+	}
+	val := reflect.ValueOf(dev).Elem()
+	for _, opt := range options {
+		spec, choice := findOptionSpec(specs, opt.Key)
+		if spec == nil {
+			return &UnknownOptionError{DeviceType: devName, Key: opt.Key}
+		}
+		if err := spec.set(devName, val.FieldByIndex(spec.index), opt, choice); err != nil {
+			return err
+		}
+	}
+
+	return validateDevice(dev)
+}
+
+func findOptionSpec(specs []*optionSpec, key string) (*optionSpec, int) {
+	for _, spec := range specs {
+		if choice := slices.Index(spec.names, key); choice != -1 {
+			return spec, choice
+		}
+	}
+	return nil, -1
+}
+
+// validateDeviceOptions checks the device fields against the constraints of
+// their `option` struct tags.
+func validateDeviceOptions(dev VirtioDevice) error {
+	devName := deviceTypeName(dev)
+	specs, err := deviceOptionSpecs(reflect.TypeOf(dev).Elem())
+	if err != nil {
+		return err
+	}
+	val := reflect.ValueOf(dev).Elem()
+
+	groups := []string{}
+	groupOptions := map[string][]string{}
+	setOptions := map[string][]string{}
+	for _, spec := range specs {
+		field := val.FieldByIndex(spec.index)
+		if err := spec.check(devName, field); err != nil {
+			return err
+		}
+		if spec.oneOf == "" {
+			continue
+		}
+		if _, ok := groupOptions[spec.oneOf]; !ok {
+			groups = append(groups, spec.oneOf)
+		}
+		groupOptions[spec.oneOf] = append(groupOptions[spec.oneOf], spec.name())
+		if !field.IsZero() {
+			setOptions[spec.oneOf] = append(setOptions[spec.oneOf], spec.name())
+		}
+	}
+	for _, group := range groups {
+		switch set := setOptions[group]; len(set) {
+		case 0:
+			return fmt.Errorf("one of %s must be set", quotedList(groupOptions[group], "or"))
+		case 1:
+		default:
+			return fmt.Errorf("'%s' and '%s' cannot be set at the same time", set[0], set[1])
+		}
+	}
+
+	return nil
+}
+
+// validateDevice validates dev using its `option` struct tags, and its
+// validate method for the checks which can't be expressed with tags.
+func validateDevice(dev VirtioDevice) error {
+	if err := validateDeviceOptions(dev); err != nil {
+		return err
+	}
+	if dev, ok := dev.(validator); ok {
+		return dev.validate()
+	}
+	return nil
+}
+
+// quotedList formats items as 'a', 'b' <conj> 'c'
+func quotedList(items []string, conj string) string {
+	quoted := make([]string, 0, len(items))
+	for _, item := range items {
+		quoted = append(quoted, fmt.Sprintf("'%s'", item))
+	}
+	if len(quoted) == 1 {
+		return quoted[0]
+	}
+	return fmt.Sprintf("%s %s %s", strings.Join(quoted[:len(quoted)-1], ", "), conj, quoted[len(quoted)-1])
+}
+
+// DeviceOption describes a command line option of a device type. It's used
+// to generate the help of the devices and shell completions.
+type DeviceOption struct {
+	// Names are the keys of the option. There are several names for a
+	// choice between options without a value, such as 'listen|connect'.
+	Names []string `json:"names"`
+	// Placeholder describes the value of the option, such as '<number>'.
+	// It's empty for options without a value.
+	Placeholder string `json:"placeholder,omitempty"`
+	// Values are the allowed values of the option, nil if any value is
+	// allowed.
+	Values []string `json:"values,omitempty"`
+	// Default is the value used when the option is not set, if any.
+	Default string `json:"default,omitempty"`
+	// Required is true if the option must be set. When ExclusiveWith is
+	// not empty, exactly one of the option and of the options it lists
+	// must be set.
+	Required bool `json:"required,omitempty"`
+	// ExclusiveWith lists the options which cannot be used together with
+	// this option.
+	ExclusiveWith []string `json:"exclusiveWith,omitempty"`
+	Help          string   `json:"help"`
+}
+
+// HasValue returns true if the option takes a value, as in 'key=value'.
+func (opt *DeviceOption) HasValue() bool {
+	return opt.Placeholder != ""
+}
+
+// Usage returns the syntax of the option, such as 'path=<string>'.
+func (opt *DeviceOption) Usage() string {
+	if !opt.HasValue() {
+		return strings.Join(opt.Names, "|")
+	}
+	return fmt.Sprintf("%s=%s", opt.Names[0], opt.Placeholder)
+}
+
+// description returns the help of the option followed by its constraints and
+// its default value.
+func (opt *DeviceOption) description() string {
+	desc := opt.Help
+	switch {
+	case opt.Required && len(opt.ExclusiveWith) != 0:
+		desc += fmt.Sprintf(" (exactly one of %s must be set)", quotedList(append([]string{opt.Names[0]}, opt.ExclusiveWith...), "or"))
+	case opt.Required:
+		desc += " (required)"
+	case len(opt.ExclusiveWith) != 0:
+		desc += fmt.Sprintf(" (cannot be used with %s)", quotedList(opt.ExclusiveWith, "or"))
+	}
+	if opt.Default != "" {
+		desc += fmt.Sprintf(" (default: %s)", opt.Default)
+	}
+	return desc
+}
+
+// extraOptionsHelper is implemented by devices with options which are not
+// described by `option` struct tags.
+type extraOptionsHelper interface {
+	extraOptions() []DeviceOption
+}
+
+// placeholder returns the description of the option value, or an empty string
+// if the option has no value.
+func (spec *optionSpec) placeholder() string {
+	if spec.flag || spec.isChoice() {
+		return ""
+	}
+	switch spec.typ {
+	case durationType:
+		return "<milliseconds>"
+	case hardwareAddrType:
+		return "<MAC address>"
+	case fileType:
+		return "<fd>"
+	}
+	if values := spec.enumValues(); values != nil {
+		return strings.Join(values, "|")
+	}
+	if isIntegerKind(spec.typ.Kind()) {
+		return "<number>"
+	}
+	return "<string>"
+}
+
+// DeviceOptions returns the command line options of the device type
+// deviceType, such as 'virtio-blk'.
+func DeviceOptions(deviceType string) ([]DeviceOption, error) {
+	devType, ok := LookupDeviceType(deviceType)
+	if !ok {
+		return nil, &UnknownDeviceError{DeviceType: deviceType}
+	}
+	dev := devType.New()
+	val := reflect.ValueOf(dev).Elem()
+	specs, err := deviceOptionSpecs(val.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []DeviceOption{}
+	for _, spec := range specs {
+		opt := DeviceOption{
+			Names:       spec.names,
+			Placeholder: spec.placeholder(),
+			Help:        spec.help,
+		}
+		if opt.HasValue() {
+			opt.Values = spec.enumValues()
+		}
+		if !spec.flag {
+			// the options of a choice do not have a value, the
+			// default is the chosen option
+			opt.Default = strings.TrimPrefix(spec.value(val.FieldByIndex(spec.index)), spec.names[0]+"=")
+		}
+		// mandatory options with a default value can be omitted
+		opt.Required = (spec.required || spec.oneOf != "") && opt.Default == ""
+		if spec.oneOf != "" {
+			for _, other := range specs {
+				if other != spec && other.oneOf == spec.oneOf {
+					opt.ExclusiveWith = append(opt.ExclusiveWith, other.name())
+				}
+			}
+		}
+		opts = append(opts, opt)
+	}
+	if dev, ok := dev.(extraOptionsHelper); ok {
+		opts = append(opts, dev.extraOptions()...)
+	}
+
+	return opts, nil
+}
+
+// DeviceHelp returns a description of the command line options of the device
+// type deviceType, such as 'virtio-blk'.
+func DeviceHelp(deviceType string) (string, error) {
+	opts, err := DeviceOptions(deviceType)
+	if err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%s options:\n", deviceType)
+	w := tabwriter.NewWriter(&builder, 0, 0, 2, ' ', 0)
+	for _, opt := range opts {
+		fmt.Fprintf(w, "  %s\t%s\n", opt.Usage(), opt.description())
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+
+	return builder.String(), nil
+}
@@ -0,0 +1,209 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceOptionSpecs(t *testing.T) {
+	for _, devType := range DeviceTypes() {
+		t.Run(devType.Name, func(t *testing.T) {
+			dev := devType.New()
+			_, err := deviceOptionSpecs(reflect.TypeOf(dev).Elem())
+			require.NoError(t, err)
+			assert.Equal(t, devType.Name, deviceTypeName(dev))
+		})
+	}
+}
+
+func TestOptionTagErrors(t *testing.T) {
+	type badFlag struct {
+		Path string `option:"path,flag"`
+	}
+	type badBool struct {
+		Enabled bool `option:"enabled"`
+	}
+	type badElement struct {
+		Path string `option:"path,optional"`
+	}
+	type badType struct {
+		Paths []string `option:"paths"`
+	}
+
+	for _, obj := range []any{badFlag{}, badBool{}, badElement{}, badType{}} {
+		_, err := deviceOptionSpecs(reflect.TypeOf(obj))
+		require.Error(t, err, "%T", obj)
+	}
+}
+
+var deviceOptionsErrorTests = map[string]string{
+	"virtio-rng,foo":                                    "unknown option for virtio-rng devices: foo",
+	"virtio-blk,path=/disk.img,readonly=on":             "unexpected value for virtio-blk 'readonly' option: on",
+	"virtio-vsock,port,socketURL=/vsock":                "missing value for virtio-vsock 'port' option",
+	"virtio-vsock,port=-1,socketURL=/vsock":             "invalid value for virtio-vsock 'port' option: -1 (expected a positive integer)",
+	"virtio-vsock,port=1234":                            "missing mandatory 'socketURL' option for virtio-vsock device",
+	"virtio-vsock,socketURL=/vsock,listen":              "missing mandatory 'port' option for virtio-vsock device",
+	"virtio-vsock,port=1234,socketURL=/vsock,connect=1": "unexpected value for virtio-vsock 'connect' option: 1",
+	"virtio-gpu,width=0":                                "invalid value for virtio-gpu 'width' option: 0 (expected a value >= 1)",
+	"virtio-gpu,height=large":                           "invalid value for virtio-gpu 'height' option: large (expected an integer)",
+	"nbd,uri=nbd://localhost,sync=async":                "invalid value for nbd 'sync' option: async (expected full|none)",
+	"nbd,uri=nbd://localhost,timeout=1s":                "invalid value for nbd 'timeout' option: 1s (expected a duration in milliseconds)",
+	"nvme,path=/disk.img,type=qcow2":                    "invalid value for nvme 'type' option: qcow2 (expected image|dev)",
+	"virtio-net,nat,mac=00:11:22":                       "invalid value for virtio-net 'mac' option: 00:11:22 (expected a MAC address)",
+	"virtio-net,nat,fd=4":                               "'nat' and 'fd' cannot be set at the same time",
+	"virtio-net,mac=00:11:22:33:44:55":                  "one of 'nat', 'fd' or 'unixSocketPath' must be set",
+	"virtio-serial,stdio,pty":                           "'stdio' and 'pty' cannot be set at the same time",
+	"virtio-serial":                                     "one of 'logFilePath', 'stdio' or 'pty' must be set",
+	"virtio-input":                                      "missing mandatory 'pointing|keyboard' option for virtio-input device",
+	"virtio-fs,mountTag=tag":                            "missing mandatory 'sharedDir' option for virtio-fs device",
+	"rosetta,install":                                   "rosetta shares require a mount tag to be specified",
+	"usb-mass-storage,readonly":                         "missing mandatory 'path' option for usb-mass-storage device",
+	`virtio-fs,sharedDir="/a,b`:                         `missing closing quote in "/a,b`,
+	`virtio-fs,sharedDir="/a\"`:                         `missing closing quote in "/a\"`,
+	`virtio-fs,sharedDir="/a"b,mountTag=tag`:            `unexpected character after closing quote in virtio-fs,sharedDir="/a"b,mountTag=tag`,
+}
+
+func TestDeviceOptionsErrors(t *testing.T) {
+	for cmdLine, expectedErr := range deviceOptionsErrorTests {
+		t.Run(cmdLine, func(t *testing.T) {
+			_, err := deviceFromCmdLine(cmdLine)
+			require.EqualError(t, err, expectedErr)
+		})
+	}
+}
+
+func TestDeviceOptionsValidation(t *testing.T) {
+	err := validateDevice(&VirtioInput{InputType: "joystick"})
+	require.EqualError(t, err, "invalid value for virtio-input 'pointing|keyboard' option: joystick (expected pointing|keyboard)")
+
+	_, err = (&VirtioVsock{Port: 1234}).ToCmdLine()
+	require.EqualError(t, err, "missing mandatory 'socketURL' option for virtio-vsock device")
+
+	_, err = (&VirtioBlk{DiskStorageConfig: DiskStorageConfig{ImagePath: "/disk.img", Type: "qcow2"}}).ToCmdLine()
+	require.EqualError(t, err, "invalid value for virtio-blk 'type' option: qcow2 (expected image|dev)")
+}
+
+func TestDeviceOptionsRoundTrip(t *testing.T) {
+	for _, cmdLine := range []string{
+		"nvme,path=/disk.img,id=root,type=dev,readonly",
+		"usb-mass-storage,path=/disk.img,ephemeral",
+		"nbd,uri=nbd://localhost/export,deviceId=data,timeout=1000,sync=none,readonly",
+		"rosetta,mountTag=rosetta,install,ignore-if-missing",
+		"virtio-gpu,id=gpu0,width=1920,height=1080",
+		"virtio-vsock,port=1234,socketURL=/vsock,connect",
+		"virtio-net,nat,mac=00:11:22:33:44:55",
+		"virtio-net,type=unixgram,path=/net.sock,vfkitMagic=off,id=net0",
+	} {
+		dev, err := deviceFromCmdLine(cmdLine)
+		require.NoError(t, err)
+		args, err := dev.ToCmdLine()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"--device", cmdLine}, args)
+	}
+}
+
+func TestDeviceOptionsQuoting(t *testing.T) {
+	for _, path := range []string{
+		"/disk,1.img",
+		"/disk=1.img",
+		"/path with spaces/disk.img",
+		`/"quoted"/disk.img`,
+		`"/disk.img"`,
+		`/back\slash,/disk.img`,
+		`/trailing\`,
+		",=\"\\",
+		"/ünïcödé,disk.img",
+	} {
+		t.Run(path, func(t *testing.T) {
+			for _, dev := range []VirtioDevice{
+				&NVMExpressController{DiskStorageConfig: DiskStorageConfig{StorageConfig: StorageConfig{DevName: "nvme"}, ImagePath: path}},
+				&VirtioFs{DirectorySharingConfig: DirectorySharingConfig{MountTag: path}, SharedDir: path},
+				&VirtioNet{UnixSocketPath: path},
+				&VirtioNet{UnixSocketPath: path, VfkitMagic: true},
+			} {
+				args, err := dev.ToCmdLine()
+				require.NoError(t, err)
+				require.Len(t, args, 2)
+				parsedDev, err := deviceFromCmdLine(args[1])
+				require.NoError(t, err, args[1])
+				assert.Equal(t, dev, parsedDev, args[1])
+			}
+		})
+	}
+
+	for cmdLine, expectedPath := range map[string]string{
+		"virtio-fs,sharedDir=/a=b":              "/a=b",
+		`virtio-fs,sharedDir=/a"b`:              `/a"b`,
+		`virtio-fs,sharedDir=/a\b`:              `/a\b`,
+		`virtio-fs,sharedDir="/a,b"`:            "/a,b",
+		`virtio-fs,sharedDir="/a\"b\\"`:         `/a"b\`,
+		`virtio-fs,"sharedDir=/a,b",mountTag=t`: "/a,b",
+	} {
+		dev, err := deviceFromCmdLine(cmdLine)
+		require.NoError(t, err, cmdLine)
+		assert.Equal(t, expectedPath, dev.(*VirtioFs).SharedDir, cmdLine)
+	}
+
+	args, err := (&VirtioFs{SharedDir: "/a,b", DirectorySharingConfig: DirectorySharingConfig{MountTag: `t"ag`}}).ToCmdLine()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--device", `virtio-fs,sharedDir="/a,b",mountTag="t\"ag"`}, args)
+}
+
+func TestDeviceHelp(t *testing.T) {
+	help, err := DeviceHelp("virtio-vsock")
+	require.NoError(t, err)
+	assert.Equal(t, `virtio-vsock options:
+  id=<string>         unique identifier of the device
+  port=<number>       vsock port (required)
+  socketURL=<string>  path to the unix socket on the host (required)
+  listen|connect      whether the host listens for connections from the guest, or connects to the guest (default: listen)
+`, help)
+
+	help, err = DeviceHelp("virtio-net")
+	require.NoError(t, err)
+	assert.Contains(t, help, "  nat ")
+	assert.Contains(t, help, "  type=unixgram ")
+	assert.Contains(t, help, "(exactly one of 'nat', 'fd' or 'unixSocketPath' must be set)")
+	assert.Contains(t, help, "(default: on)")
+
+	for _, devType := range DeviceTypes() {
+		_, err := DeviceHelp(devType.Name)
+		require.NoError(t, err)
+	}
+
+	_, err = DeviceHelp("virtio-foo")
+	require.EqualError(t, err, "unknown device type: virtio-foo")
+}
+
+func TestDeviceOptions(t *testing.T) {
+	opts, err := DeviceOptions("virtio-serial")
+	require.NoError(t, err)
+	require.Len(t, opts, 4)
+	assert.Equal(t, DeviceOption{
+		Names:         []string{"logFilePath"},
+		Placeholder:   "<string>",
+		Required:      true,
+		ExclusiveWith: []string{"stdio", "pty"},
+		Help:          "path to the file where the serial port output is written",
+	}, opts[1])
+	assert.True(t, opts[1].HasValue())
+	assert.False(t, opts[2].HasValue())
+	assert.Equal(t, "stdio", opts[2].Usage())
+
+	opts, err = DeviceOptions("nbd")
+	require.NoError(t, err)
+	sync := opts[len(opts)-1]
+	assert.Equal(t, "sync=full|none", sync.Usage())
+	assert.Equal(t, []string{"full", "none"}, sync.Values)
+	assert.Equal(t, "full", sync.Default)
+
+	opts, err = DeviceOptions("virtio-vsock")
+	require.NoError(t, err)
+	listen := opts[len(opts)-1]
+	assert.Equal(t, []string{"listen", "connect"}, listen.Names)
+	assert.Equal(t, "listen", listen.Default)
+	assert.False(t, listen.Required)
+}
@@ -0,0 +1,294 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+)
+
+var osFileType = reflect.TypeOf(&os.File{})
+
+// Clone returns a deep copy of vm, which can be modified without changing vm.
+// The *os.File values, such as the socket of VirtioNet devices, are not
+// duplicated, the clone refers to the same files as vm. Closing them through
+// the clone closes them for vm too, callers must not close them while vm is
+// in use.
+func (vm *VirtualMachine) Clone() *VirtualMachine {
+	clone := reflect.New(reflect.TypeOf(vm).Elem())
+	deepCopy(clone.Elem(), reflect.ValueOf(vm).Elem())
+	return clone.Interface().(*VirtualMachine)
+}
+
+// deepCopy sets dst to a deep copy of src.
+func deepCopy(dst reflect.Value, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Pointer:
+		if src.IsNil() || src.Type() == osFileType {
+			dst.Set(src)
+			return
+		}
+		ptr := reflect.New(src.Type().Elem())
+		deepCopy(ptr.Elem(), src.Elem())
+		dst.Set(ptr)
+	case reflect.Interface:
+		if src.IsNil() {
+			dst.Set(src)
+			return
+		}
+		elem := reflect.New(src.Elem().Type()).Elem()
+		deepCopy(elem, src.Elem())
+		dst.Set(elem)
+	case reflect.Struct:
+		// unexported fields cannot be set individually, they are
+		// copied with the struct
+		dst.Set(src)
+		for i := range src.NumField() {
+			if src.Type().Field(i).IsExported() {
+				deepCopy(dst.Field(i), src.Field(i))
+			}
+		}
+	case reflect.Slice:
+		if src.IsNil() {
+			dst.Set(src)
+			return
+		}
+		slice := reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+		for i := range src.Len() {
+			deepCopy(slice.Index(i), src.Index(i))
+		}
+		dst.Set(slice)
+	case reflect.Map:
+		if src.IsNil() {
+			dst.Set(src)
+			return
+		}
+		m := reflect.MakeMapWithSize(src.Type(), src.Len())
+		iter := src.MapRange()
+		for iter.Next() {
+			value := reflect.New(iter.Value().Type()).Elem()
+			deepCopy(value, iter.Value())
+			m.SetMapIndex(iter.Key(), value)
+		}
+		dst.Set(m)
+	default:
+		dst.Set(src)
+	}
+}
+
+// ChangeKind is the type of a Change.
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeRemoved  ChangeKind = "removed"
+	ChangeModified ChangeKind = "modified"
+)
+
+// Change is a difference between two virtual machine configurations, see
+// VirtualMachine.Diff.
+type Change struct {
+	// Path is the location of the change in the JSON representation of
+	// the virtual machine, such as 'devices[1].sharedDir'.
+	Path string     `json:"path"`
+	Kind ChangeKind `json:"kind"`
+	// Old and New are the JSON values before and after the change. Old
+	// is nil for added values, and New is nil for removed values.
+	Old any `json:"old,omitempty"`
+	New any `json:"new,omitempty"`
+}
+
+// String formats the change as '+ path: new', '- path: old' or
+// '~ path: old -> new'.
+func (change Change) String() string {
+	switch change.Kind {
+	case ChangeAdded:
+		return fmt.Sprintf("+ %s: %s", change.Path, formatJSONValue(change.New))
+	case ChangeRemoved:
+		return fmt.Sprintf("- %s: %s", change.Path, formatJSONValue(change.Old))
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", change.Path, formatJSONValue(change.Old), formatJSONValue(change.New))
+	}
+}
+
+func formatJSONValue(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// Diff returns the changes needed to go from vm to other. The configurations
+// are compared using their JSON representation. Devices with the same 'id' are
+// compared with each other, wherever they are in the device lists, and the
+// other devices are compared according to their position among the devices
+// without a matching 'id'. The paths of removed devices use their index in
+// vm, the paths of the other devices use their index in other.
+func (vm *VirtualMachine) Diff(other *VirtualMachine) ([]Change, error) {
+	oldValue, err := toJSONValue(vm)
+	if err != nil {
+		return nil, err
+	}
+	newValue, err := toJSONValue(other)
+	if err != nil {
+		return nil, err
+	}
+	changes := []Change{}
+	diffJSONValues("", oldValue, newValue, &changes)
+
+	return changes, nil
+}
+
+// Equal returns true if vm and other describe the same virtual machine, that
+// is if Diff does not find any change between them.
+func (vm *VirtualMachine) Equal(other *VirtualMachine) bool {
+	changes, err := vm.Diff(other)
+	return err == nil && len(changes) == 0
+}
+
+// toJSONValue returns the JSON representation of vm decoded as maps, slices
+// and json.Number values.
+func toJSONValue(vm *VirtualMachine) (any, error) {
+	data, err := json.Marshal(vm)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func diffJSONValues(path string, oldValue any, newValue any, changes *[]Change) {
+	switch oldValue := oldValue.(type) {
+	case map[string]any:
+		// objects with a different kind, such as two different device
+		// types, are replaced as a whole
+		if newValue, ok := newValue.(map[string]any); ok && objectKind(oldValue) == objectKind(newValue) {
+			diffJSONObjects(path, oldValue, newValue, changes)
+			return
+		}
+	case []any:
+		if newValue, ok := newValue.([]any); ok {
+			diffJSONArrays(path, oldValue, newValue, changes)
+			return
+		}
+	}
+	if !reflect.DeepEqual(oldValue, newValue) {
+		*changes = append(*changes, Change{Path: path, Kind: ChangeModified, Old: oldValue, New: newValue})
+	}
+}
+
+func diffJSONObjects(path string, oldObj map[string]any, newObj map[string]any, changes *[]Change) {
+	keys := []string{}
+	for key := range oldObj {
+		keys = append(keys, key)
+	}
+	for key := range newObj {
+		if _, ok := oldObj[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+		oldValue, inOld := oldObj[key]
+		newValue, inNew := newObj[key]
+		switch {
+		case !inOld:
+			*changes = append(*changes, Change{Path: keyPath, Kind: ChangeAdded, New: newValue})
+		case !inNew:
+			*changes = append(*changes, Change{Path: keyPath, Kind: ChangeRemoved, Old: oldValue})
+		default:
+			diffJSONValues(keyPath, oldValue, newValue, changes)
+		}
+	}
+}
+
+func diffJSONArrays(path string, oldArray []any, newArray []any, changes *[]Change) {
+	itemPath := func(i int) string {
+		return fmt.Sprintf("%s[%d]", path, i)
+	}
+	// oldIndexes associates each item of newArray with the index of the
+	// item of oldArray it's compared with, or -1 if it was added
+	oldIndexes := matchJSONItems(oldArray, newArray)
+	matched := make([]bool, len(oldArray))
+	for i, oldIndex := range oldIndexes {
+		if oldIndex == -1 {
+			*changes = append(*changes, Change{Path: itemPath(i), Kind: ChangeAdded, New: newArray[i]})
+			continue
+		}
+		matched[oldIndex] = true
+		diffJSONValues(itemPath(i), oldArray[oldIndex], newArray[i], changes)
+	}
+	for i, item := range oldArray {
+		if !matched[i] {
+			*changes = append(*changes, Change{Path: itemPath(i), Kind: ChangeRemoved, Old: item})
+		}
+	}
+}
+
+// matchJSONItems returns, for each item of newArray, the index of the item of
+// oldArray with the same 'id'. The other items are matched in order with the
+// items of oldArray which have no 'id' match. The index is -1 for the items
+// which have no match.
+func matchJSONItems(oldArray []any, newArray []any) []int {
+	oldIDs := map[string]int{}
+	for i, item := range oldArray {
+		if id := objectID(item); id != "" {
+			oldIDs[id] = i
+		}
+	}
+	oldIndexes := make([]int, len(newArray))
+	matched := make([]bool, len(oldArray))
+	for i, item := range newArray {
+		oldIndexes[i] = -1
+		if oldIndex, ok := oldIDs[objectID(item)]; ok && !matched[oldIndex] {
+			oldIndexes[i] = oldIndex
+			matched[oldIndex] = true
+		}
+	}
+
+	next := 0
+	for i := range newArray {
+		if oldIndexes[i] != -1 {
+			continue
+		}
+		for next < len(oldArray) && matched[next] {
+			next++
+		}
+		if next == len(oldArray) {
+			break
+		}
+		oldIndexes[i] = next
+		matched[next] = true
+	}
+	return oldIndexes
+}
+
+// objectID returns the 'id' field of a decoded JSON object, or an empty
+// string if value is not an object or has no 'id'.
+func objectID(value any) string {
+	obj, ok := value.(map[string]any)
+	if !ok {
+		return ""
+	}
+	id, _ := obj["id"].(string)
+	return id
+}
+
+// objectKind returns the 'kind' field of a decoded JSON object.
+func objectKind(obj map[string]any) string {
+	kind, _ := obj["kind"].(string)
+	return kind
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCompareTestVM(t *testing.T) *VirtualMachine {
+	vm := NewVirtualMachine(2, 2048, NewEFIBootloader("/efi-store", true))
+	fs, err := VirtioFsNew("/Users/user", "home")
+	require.NoError(t, err)
+	rng, err := VirtioRngNew()
+	require.NoError(t, err)
+	require.NoError(t, vm.AddDevices(fs, rng))
+	return vm
+}
+
+func TestVirtualMachineClone(t *testing.T) {
+	vm := newCompareTestVM(t)
+	net, err := VirtioNetNew("")
+	require.NoError(t, err)
+	net.SetSocket(os.Stdin)
+	require.NoError(t, vm.AddDevice(net))
+
+	clone := vm.Clone()
+	assert.Equal(t, vm, clone)
+	assert.True(t, vm.Equal(clone))
+
+	clone.Vcpus = 4
+	clone.Devices[0].(*VirtioFs).SharedDir = "/tmp"
+	clone.Bootloader.(*EFIBootloader).EFIVariableStorePath = "/other-store"
+	assert.Equal(t, uint(2), vm.Vcpus)
+	assert.Equal(t, "/Users/user", vm.Devices[0].(*VirtioFs).SharedDir)
+	assert.Equal(t, "/efi-store", vm.Bootloader.(*EFIBootloader).EFIVariableStorePath)
+	assert.False(t, vm.Equal(clone))
+
+	// files are shared with the clone
+	assert.Same(t, os.Stdin, clone.Devices[2].(*VirtioNet).Socket)
+}
+
+func TestVirtualMachineDiff(t *testing.T) {
+	vm := newCompareTestVM(t)
+
+	changes, err := vm.Diff(vm.Clone())
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	other := vm.Clone()
+	other.Vcpus = 4
+	other.Devices[0].(*VirtioFs).SharedDir = "/tmp"
+	other.Devices = other.Devices[:1]
+	balloon, err := VirtioBalloonNew()
+	require.NoError(t, err)
+	require.NoError(t, other.AddDevice(balloon))
+	gpu, err := VirtioGPUNew()
+	require.NoError(t, err)
+	require.NoError(t, other.AddDevice(gpu))
+
+	changes, err = vm.Diff(other)
+	require.NoError(t, err)
+	paths := []string{}
+	for _, change := range changes {
+		paths = append(paths, change.Path)
+	}
+	assert.Equal(t, []string{"devices[0].sharedDir", "devices[1]", "devices[2]", "vcpus"}, paths)
+
+	assert.Equal(t, ChangeModified, changes[0].Kind)
+	assert.Equal(t, `~ devices[0].sharedDir: "/Users/user" -> "/tmp"`, changes[0].String())
+	// devices of a different type are replaced as a whole
+	assert.Equal(t, ChangeModified, changes[1].Kind)
+	assert.Equal(t, map[string]any{"kind": "virtiorng"}, changes[1].Old)
+	assert.Equal(t, ChangeAdded, changes[2].Kind)
+	assert.Equal(t, "~ vcpus: 2 -> 4", changes[3].String())
+
+	changes, err = other.Diff(vm)
+	require.NoError(t, err)
+	require.Len(t, changes, 4)
+	assert.Equal(t, ChangeRemoved, changes[2].Kind)
+	assert.Regexp(t, `^- devices\[2\]: \{.*"kind":"virtiogpu".*\}$`, changes[2].String())
+}
+
+func TestVirtualMachineDiffDeviceIDs(t *testing.T) {
+	vm := newCompareTestVM(t)
+	vm.Devices[0].(*VirtioFs).SetDeviceID("home")
+	vm.Devices[1].(*VirtioRng).SetDeviceID("rng")
+
+	// devices with an 'id' are matched wherever they are
+	other := vm.Clone()
+	other.Devices[0], other.Devices[1] = other.Devices[1], other.Devices[0]
+	other.Devices[1].(*VirtioFs).SharedDir = "/tmp"
+	changes, err := vm.Diff(other)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, `~ devices[1].sharedDir: "/Users/user" -> "/tmp"`, changes[0].String())
+
+	// the other devices are compared according to their position
+	other = vm.Clone()
+	other.Devices = other.Devices[1:]
+	balloon, err := VirtioBalloonNew()
+	require.NoError(t, err)
+	require.NoError(t, other.AddDevice(balloon))
+	changes, err = vm.Diff(other)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, ChangeModified, changes[0].Kind)
+	assert.Equal(t, "devices[1]", changes[0].Path)
+	assert.Equal(t, "virtiofs", changes[0].Old.(map[string]any)["kind"])
+
+	// so are the devices whose 'id' is only in one configuration
+	other.Devices[1].(*VirtioBalloon).SetDeviceID("balloon")
+	changes, err = vm.Diff(other)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, "devices[1]", changes[0].Path)
+}
+
+func TestChangeJSON(t *testing.T) {
+	vm := newCompareTestVM(t)
+	other := vm.Clone()
+	other.Memory *= 2
+
+	changes, err := vm.Diff(other)
+	require.NoError(t, err)
+	data, err := json.Marshal(changes)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"path":"memoryBytes","kind":"modified","old":2147483648,"new":4294967296}]`, string(data))
+}
@@ -16,6 +16,7 @@ import (
 	"math"
 	"os"
 	"os/exec"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -49,7 +50,7 @@ type Ignition struct {
 // The VMComponent interface represents a VM element (device, bootloader, ...)
 // which can be converted from/to commandline parameters
 type VMComponent interface {
-	FromOptions([]option) error
+	FromOptions([]Option) error
 	ToCmdLine() ([]string, error)
 }
 
@@ -82,7 +83,29 @@ func roundToMiB(value strongunits.StorageUnits) strongunits.MiB {
 // These arguments will start a virtual machine with the devices/bootloader/...
 // described by vm If the virtual machine configuration described by vm is
 // invalid, an error will be returned.
+//
+// The generated arguments use the oldest syntax supporting the configuration,
+// ToCmdLineFor can be used when the version of the vfkit binary is known.
 func (vm *VirtualMachine) ToCmdLine() ([]string, error) {
+	return vm.toCmdLine("")
+}
+
+// ToCmdLineFor is similar to ToCmdLine, but it generates arguments for the
+// vfkit binary whose version is version, such as 'v0.6.1'. The newest syntax
+// understood by this vfkit version is used. An UnsupportedFeatureError is
+// returned when the configuration needs command line options which are not
+// available in this vfkit version.
+func (vm *VirtualMachine) ToCmdLineFor(version string) ([]string, error) {
+	canonical, err := canonicalVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	return vm.toCmdLine(canonical)
+}
+
+// toCmdLine generates the command line arguments for the vfkit version. The
+// oldest syntax supporting the configuration is used when version is empty.
+func (vm *VirtualMachine) toCmdLine(version string) ([]string, error) {
 	// TODO: missing binary name/path
 	args := []string{}
 
@@ -101,17 +124,40 @@ func (vm *VirtualMachine) ToCmdLine() ([]string, error) {
 		return nil, err
 	}
 	args = append(args, bootloaderArgs...)
+	if efi, ok := vm.Bootloader.(*EFIBootloader); ok {
+		if err := checkQuotedValue(efi.EFIVariableStorePath, version); err != nil {
+			return nil, err
+		}
+	}
 
 	for _, dev := range vm.Devices {
-		devArgs, err := dev.ToCmdLine()
+		devArgs, err := deviceCmdLine(dev, version)
 		if err != nil {
 			return nil, err
 		}
 		args = append(args, devArgs...)
 	}
 
+	if vm.Timesync != nil {
+		timesyncArgs, err := vm.Timesync.ToCmdLine()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, timesyncArgs...)
+	}
+
+	for _, gpu := range vm.VirtioGPUDevices() {
+		if gpu.UsesGUI {
+			args = append(args, "--gui")
+			break
+		}
+	}
+
 	if vm.Ignition != nil {
-		args = append(args, "--ignition", vm.Ignition.ConfigPath)
+		if err := checkQuotedValue(vm.Ignition.ConfigPath, version); err != nil {
+			return nil, err
+		}
+		args = append(args, "--ignition", quoteOptionValue(vm.Ignition.ConfigPath))
 	}
 
 	if vm.Nested {
@@ -145,19 +191,45 @@ func (vm *VirtualMachine) Cmd(vfkitPath string) (*exec.Cmd, error) {
 		return nil, err
 	}
 
+	return vm.newCmd(vfkitPath, args), nil
+}
+
+// CmdFor is similar to Cmd, but the command line is generated for the vfkit
+// version, see ToCmdLineFor. When version is empty, it's obtained by running
+// 'vfkitPath --version'.
+func (vm *VirtualMachine) CmdFor(vfkitPath string, version string) (*exec.Cmd, error) {
+	if version == "" {
+		var err error
+		version, err = VfkitVersion(vfkitPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	args, err := vm.ToCmdLineFor(version)
+	if err != nil {
+		return nil, err
+	}
+
+	return vm.newCmd(vfkitPath, args), nil
+}
+
+func (vm *VirtualMachine) newCmd(vfkitPath string, args []string) *exec.Cmd {
 	cmd := exec.Command(vfkitPath, args...)
 	cmd.ExtraFiles = vm.extraFiles()
 
-	return cmd, nil
+	return cmd
 }
 
 func (vm *VirtualMachine) AddDevicesFromCmdLine(cmdlineOpts []string) error {
-	for _, deviceOpts := range cmdlineOpts {
+	for i, deviceOpts := range cmdlineOpts {
 		dev, err := deviceFromCmdLine(deviceOpts)
 		if err != nil {
+			setDeviceArg(err, i, deviceOpts)
+			return err
+		}
+		if err := vm.AddDevice(dev); err != nil {
 			return err
 		}
-		vm.Devices = append(vm.Devices, dev)
 	}
 	return nil
 }
@@ -204,12 +276,78 @@ func (vm *VirtualMachine) AddDevice(dev VirtioDevice) error {
 	return vm.AddDevices(dev)
 }
 
-// AddDevices adds a list of devices to vm.
-func (vm *VirtualMachine) AddDevices(dev ...VirtioDevice) error {
-	vm.Devices = append(vm.Devices, dev...)
+// AddDevices adds a list of devices to vm. An error is returned if one of the
+// devices uses the same ID as another device.
+func (vm *VirtualMachine) AddDevices(devs ...VirtioDevice) error {
+	ids := map[string]struct{}{}
+	for _, dev := range devs {
+		id := deviceID(dev)
+		if id == "" {
+			continue
+		}
+		if _, ok := ids[id]; ok || vm.DeviceByID(id) != nil {
+			return fmt.Errorf("device ID '%s' is already in use", id)
+		}
+		ids[id] = struct{}{}
+	}
+	vm.Devices = append(vm.Devices, devs...)
 	return nil
 }
 
+// DeviceByID returns the device of vm with the identifier id, or nil if there
+// is no such device.
+func (vm *VirtualMachine) DeviceByID(id string) VirtioDevice {
+	idx := vm.deviceIndexByID(id)
+	if idx == -1 {
+		return nil
+	}
+	return vm.Devices[idx]
+}
+
+// ReplaceDevice replaces the device of vm with the identifier id with dev. dev
+// keeps the position of the device it replaces. If dev has no identifier, it
+// gets the identifier of the replaced device.
+func (vm *VirtualMachine) ReplaceDevice(id string, dev VirtioDevice) error {
+	idx := vm.deviceIndexByID(id)
+	if idx == -1 {
+		return fmt.Errorf("no device with ID '%s'", id)
+	}
+	switch newID := deviceID(dev); newID {
+	case "":
+		if dev, ok := dev.(identifiedDevice); ok {
+			dev.SetDeviceID(id)
+		}
+	case id:
+	default:
+		if vm.DeviceByID(newID) != nil {
+			return fmt.Errorf("device ID '%s' is already in use", newID)
+		}
+	}
+	vm.Devices[idx] = dev
+	return nil
+}
+
+// RemoveDevice removes the device with the identifier id from vm.
+func (vm *VirtualMachine) RemoveDevice(id string) error {
+	idx := vm.deviceIndexByID(id)
+	if idx == -1 {
+		return fmt.Errorf("no device with ID '%s'", id)
+	}
+	vm.Devices = slices.Delete(vm.Devices, idx, idx+1)
+	return nil
+}
+
+// deviceIndexByID returns the index in vm.Devices of the device with the
+// identifier id, or -1 if there is no such device.
+func (vm *VirtualMachine) deviceIndexByID(id string) int {
+	if id == "" {
+		return -1
+	}
+	return slices.IndexFunc(vm.Devices, func(dev VirtioDevice) bool {
+		return deviceID(dev) == id
+	})
+}
+
 func (vm *VirtualMachine) AddTimeSyncFromCmdLine(cmdlineOpts string) error {
 	if cmdlineOpts == "" {
 		return nil
@@ -241,7 +379,10 @@ func (vm *VirtualMachine) AddIgnitionFileFromCmdLine(cmdlineOpts string) error {
 	if cmdlineOpts == "" {
 		return nil
 	}
-	opts := strings.Split(cmdlineOpts, ",")
+	opts, err := splitOptions(cmdlineOpts)
+	if err != nil {
+		return err
+	}
 	if len(opts) != 1 {
 		return fmt.Errorf("ignition only accepts one option in command line argument")
 	}
@@ -272,17 +413,17 @@ func (ts *TimeSync) ToCmdLine() ([]string, error) {
 	return []string{"--timesync", strings.Join(args, ",")}, nil
 }
 
-func (ts *TimeSync) FromOptions(options []option) error {
+func (ts *TimeSync) FromOptions(options []Option) error {
 	for _, option := range options {
-		switch option.key {
+		switch option.Key {
 		case "vsockPort":
-			vsockPort, err := strconv.ParseUint(option.value, 10, 32)
+			vsockPort, err := strconv.ParseUint(option.Value, 10, 32)
 			if err != nil {
 				return err
 			}
 			ts.VsockPort = uint32(vsockPort)
 		default:
-			return fmt.Errorf("unknown option for timesync parameter: %s", option.key)
+			return fmt.Errorf("unknown option for timesync parameter: %s", option.Key)
 		}
 	}
 
@@ -296,7 +437,10 @@ func (ts *TimeSync) FromOptions(options []option) error {
 func timesyncFromCmdLine(optsStr string) (*TimeSync, error) {
 	var timesync TimeSync
 
-	optsStrv := strings.Split(optsStr, ",")
+	optsStrv, err := splitOptions(optsStr)
+	if err != nil {
+		return nil, err
+	}
 	options := strvToOptions(optsStrv)
 
 	if err := timesync.FromOptions(options); err != nil {
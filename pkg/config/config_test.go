@@ -24,6 +24,104 @@ func TestAddIgnitionFile_OneOption(t *testing.T) {
 	assert.Equal(t, ignitionVsockPort, vm.Ignition.VsockPort)
 }
 
+func TestVirtualMachineToCmdLine(t *testing.T) {
+	vm := NewVirtualMachine(2, 2048, NewEFIBootloader("/efi-store", true))
+	vm.Timesync = &TimeSync{VsockPort: 1234}
+	gpu, err := VirtioGPUNew()
+	require.NoError(t, err)
+	gpu.(*VirtioGPU).UsesGUI = true
+	vm.Devices = append(vm.Devices, gpu)
+	vm.Nested = true
+
+	args, err := vm.ToCmdLine()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"--cpus", "2",
+		"--memory", "2048",
+		"--bootloader", "efi,variable-store=/efi-store,create",
+		"--device", "virtio-gpu,width=800,height=600",
+		"--timesync", "vsockPort=1234",
+		"--gui",
+		"--nested",
+	}, args)
+}
+
+func TestVirtualMachineToCmdLineQuoting(t *testing.T) {
+	const (
+		efiStore = `/efi,store"1`
+		ignition = "/config,1.ign"
+	)
+	vm := NewVirtualMachine(2, 2048, NewEFIBootloader(efiStore, false))
+	vm.Ignition = &Ignition{ConfigPath: ignition}
+
+	args, err := vm.ToCmdLine()
+	require.NoError(t, err)
+	assert.Contains(t, args, `efi,variable-store="/efi,store\"1"`)
+	assert.Contains(t, args, `"/config,1.ign"`)
+
+	// the --bootloader argument is already split by the command line parser
+	bootloader, err := BootloaderFromCmdLine([]string{"efi", `variable-store="/efi,store\"1"`})
+	require.NoError(t, err)
+	assert.Equal(t, NewEFIBootloader(efiStore, false), bootloader)
+	parsed := &VirtualMachine{}
+	require.NoError(t, parsed.AddIgnitionFileFromCmdLine(`"/config,1.ign"`))
+	assert.Equal(t, ignition, parsed.Ignition.ConfigPath)
+	require.NoError(t, parsed.AddTimeSyncFromCmdLine(`vsockPort="1234"`))
+	assert.Equal(t, &TimeSync{VsockPort: 1234}, parsed.Timesync)
+
+	_, err = BootloaderFromCmdLine([]string{"efi", `variable-store="/efi`})
+	require.ErrorContains(t, err, "missing closing quote")
+}
+
+func TestVirtualMachineDeviceIDs(t *testing.T) {
+	vm := NewVirtualMachine(2, 2048, NewEFIBootloader("/efi-store", true))
+	err := vm.AddDevicesFromCmdLine([]string{"virtio-rng", "virtio-net,nat,id=net0", "virtio-serial,logFilePath=/serial.log,id=console"})
+	require.NoError(t, err)
+	require.Len(t, vm.Devices, 3)
+	assert.Empty(t, deviceID(vm.Devices[0]))
+
+	dev := vm.DeviceByID("console")
+	require.IsType(t, &VirtioSerial{}, dev)
+	assert.Equal(t, "/serial.log", dev.(*VirtioSerial).LogFile)
+	assert.Nil(t, vm.DeviceByID("nonexistent"))
+	assert.Nil(t, vm.DeviceByID(""))
+
+	args, err := vm.ToCmdLine()
+	require.NoError(t, err)
+	assert.Contains(t, args, "virtio-serial,logFilePath=/serial.log,id=console")
+
+	err = vm.AddDevicesFromCmdLine([]string{"virtio-balloon,id=net0"})
+	require.EqualError(t, err, "device ID 'net0' is already in use")
+	err = vm.AddDevices(&VirtioRng{DeviceInfo: DeviceInfo{ID: "rng"}}, &VirtioBalloon{DeviceInfo: DeviceInfo{ID: "rng"}})
+	require.EqualError(t, err, "device ID 'rng' is already in use")
+	require.Len(t, vm.Devices, 3)
+	_, err = deviceFromCmdLine("virtio-rng,id=")
+	require.EqualError(t, err, "missing value for virtio-rng 'id' option")
+
+	err = vm.ReplaceDevice("console", &VirtioSerial{UsesStdio: true})
+	require.NoError(t, err)
+	assert.Equal(t, &VirtioSerial{DeviceInfo: DeviceInfo{ID: "console"}, UsesStdio: true}, vm.Devices[2])
+	err = vm.ReplaceDevice("console", &VirtioSerial{DeviceInfo: DeviceInfo{ID: "net0"}})
+	require.EqualError(t, err, "device ID 'net0' is already in use")
+	err = vm.ReplaceDevice("nonexistent", &VirtioRng{})
+	require.EqualError(t, err, "no device with ID 'nonexistent'")
+
+	err = vm.RemoveDevice("net0")
+	require.NoError(t, err)
+	require.Len(t, vm.Devices, 2)
+	assert.Nil(t, vm.DeviceByID("net0"))
+	err = vm.RemoveDevice("net0")
+	require.EqualError(t, err, "no device with ID 'net0'")
+
+	// components without identifier, such as timesync, can still be devices
+	timesync, err := TimeSyncNew(1234)
+	require.NoError(t, err)
+	require.NoError(t, vm.AddDevice(timesync))
+	require.NoError(t, vm.ReplaceDevice("console", timesync))
+	assert.Nil(t, vm.DeviceByID("console"))
+	require.NoError(t, vm.Validate())
+}
+
 func TestNetworkBlockDevice(t *testing.T) {
 	vm := &VirtualMachine{}
 	gpu, _ := VirtioGPUNew()
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/crc-org/vfkit/pkg/image"
+)
+
+// DeviceArg locates a problem in a --device command line argument. It's only
+// filled for the errors returned by VirtualMachine.AddDevicesFromCmdLine, Arg
+// is empty otherwise.
+type DeviceArg struct {
+	// Index is the position of the argument in the list passed to
+	// AddDevicesFromCmdLine.
+	Index int
+	// Arg is the --device argument, for example "virtio-fs,sharedDir=/Users".
+	Arg string
+	// Start and End are the byte offsets of the invalid part of Arg. They
+	// are equal when something is missing at the end of Arg.
+	Start int
+	End   int
+}
+
+// DeviceArgument returns the location of the error in the --device argument.
+func (arg *DeviceArg) DeviceArgument() *DeviceArg {
+	return arg
+}
+
+// DeviceArgError is implemented by the errors returned when parsing --device
+// arguments, it can be used with errors.As to find which part of the
+// arguments is invalid.
+type DeviceArgError interface {
+	error
+	DeviceArgument() *DeviceArg
+}
+
+// deviceArgSpanner is implemented by the DeviceArgError types of this package.
+type deviceArgSpanner interface {
+	DeviceArgError
+	// span returns the offsets of the invalid part of arg, which was split
+	// into tokens.
+	span(tokens []optionToken, arg string) (int, int)
+}
+
+// UnknownDeviceError is returned for --device arguments using an unknown
+// device type.
+type UnknownDeviceError struct {
+	DeviceArg
+	// DeviceType is the device type used on the command line
+	DeviceType string
+}
+
+func (err *UnknownDeviceError) Error() string {
+	return fmt.Sprintf("unknown device type: %s", err.DeviceType)
+}
+
+func (err *UnknownDeviceError) span(tokens []optionToken, _ string) (int, int) {
+	return tokens[0].start, tokens[0].end
+}
+
+// UnknownOptionError is returned for options which are not supported by the
+// device.
+type UnknownOptionError struct {
+	DeviceArg
+	// DeviceType is the device type used on the command line, such as
+	// "virtio-blk"
+	DeviceType string
+	Key        string
+}
+
+func (err *UnknownOptionError) Error() string {
+	return fmt.Sprintf("unknown option for %s devices: %s", err.DeviceType, err.Key)
+}
+
+func (err *UnknownOptionError) span(tokens []optionToken, arg string) (int, int) {
+	return optionSpan(tokens, arg, func(opt Option) bool { return opt.Key == err.Key })
+}
+
+// InvalidValueError is returned when the value of an option is missing, is
+// invalid, or when a value is given to an option which does not take one.
+type InvalidValueError struct {
+	DeviceArg
+	// DeviceType is the device type used on the command line, such as
+	// "virtio-blk"
+	DeviceType string
+	Key        string
+	Value      string
+	// Expected describes the valid values. It's empty when the option
+	// does not take a value.
+	Expected string
+}
+
+func (err *InvalidValueError) Error() string {
+	switch {
+	case err.Value == "":
+		return fmt.Sprintf("missing value for %s '%s' option", err.DeviceType, err.Key)
+	case err.Expected == "":
+		return fmt.Sprintf("unexpected value for %s '%s' option: %s", err.DeviceType, err.Key, err.Value)
+	default:
+		return fmt.Sprintf("invalid value for %s '%s' option: %s (expected %s)", err.DeviceType, err.Key, err.Value, err.Expected)
+	}
+}
+
+func (err *InvalidValueError) span(tokens []optionToken, arg string) (int, int) {
+	// for choices such as 'listen|connect', err.Key lists all the options
+	keys := strings.Split(err.Key, "|")
+	return optionSpan(tokens, arg, func(opt Option) bool {
+		return slices.Contains(keys, opt.Key) && opt.Value == err.Value
+	})
+}
+
+// MissingOptionError is returned when a mandatory option of the device is not
+// set.
+type MissingOptionError struct {
+	DeviceArg
+	// DeviceType is the device type used on the command line, such as
+	// "virtio-blk"
+	DeviceType string
+	Key        string
+}
+
+func (err *MissingOptionError) Error() string {
+	return fmt.Sprintf("missing mandatory '%s' option for %s device", err.Key, err.DeviceType)
+}
+
+func (err *MissingOptionError) span(_ []optionToken, arg string) (int, int) {
+	return len(arg), len(arg)
+}
+
+// optionSpan returns the offsets of the first option for which match returns
+// true, or of the whole argument if there is no such option.
+func optionSpan(tokens []optionToken, arg string, match func(opt Option) bool) (int, int) {
+	for _, token := range tokens[1:] {
+		if match(strToOption(token.str)) {
+			return token.start, token.end
+		}
+	}
+	return 0, len(arg)
+}
+
+// setDeviceArg records in err the location of the problem in the --device
+// argument arg, which is at position index in the command line.
+func setDeviceArg(err error, index int, arg string) {
+	var argErr deviceArgSpanner
+	if !errors.As(err, &argErr) {
+		return
+	}
+	tokens, tokenErr := tokenizeOptions(arg)
+	if tokenErr != nil {
+		return
+	}
+	location := argErr.DeviceArgument()
+	location.Index = index
+	location.Arg = arg
+	location.Start, location.End = argErr.span(tokens, arg)
+}
+
+// UnsupportedImageError is returned when a disk image used by a storage
+// device is not a raw image, the error message explains how to convert it.
+type UnsupportedImageError struct {
+	// DevName is the device type, such as "virtio-blk"
+	DevName string
+	Path    string
+	Info    image.Info
+}
+
+func (err *UnsupportedImageError) Error() string {
+	if err.Info.Compression != image.CompressionNone {
+		return fmt.Sprintf("%s image %s is compressed with %s, it must be decompressed first, for example with '%s -dk %s'",
+			err.DevName, err.Path, err.Info.Compression, err.Info.Compression, err.Path)
+	}
+	var hint string
+	switch err.Info.Format {
+	case image.FormatQcow2, image.FormatVMDK, image.FormatVHDX:
+		hint = fmt.Sprintf("vfkit image convert %s <raw image>", err.Path)
+	case image.FormatDMG:
+		hint = fmt.Sprintf("hdiutil convert -format UDTO -o <raw image> %s", err.Path)
+	default:
+		hint = fmt.Sprintf("qemu-img convert -f %s -O raw %s <raw image>", err.Info.Format, err.Path)
+	}
+	return fmt.Sprintf("vfkit does not support %s image format, %s image %s must be converted to raw format, for example with '%s'",
+		err.Info.Format, err.DevName, err.Path, hint)
+}
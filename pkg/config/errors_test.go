@@ -0,0 +1,86 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceArgErrors(t *testing.T) {
+	type expectedError struct {
+		target  DeviceArgError
+		err     string
+		invalid string
+	}
+	tests := map[string]expectedError{
+		"virtio-foo,path=/disk.img": {
+			target:  &UnknownDeviceError{},
+			err:     "unknown device type: virtio-foo",
+			invalid: "virtio-foo",
+		},
+		`virtio-fs,sharedDir="/a,foo",foo,mountTag=tag`: {
+			target:  &UnknownOptionError{},
+			err:     "unknown option for virtio-fs devices: foo",
+			invalid: "foo",
+		},
+		"virtio-vsock,port=1,port=-1,socketURL=/vsock": {
+			target:  &InvalidValueError{},
+			err:     "invalid value for virtio-vsock 'port' option: -1 (expected a positive integer)",
+			invalid: "port=-1",
+		},
+		"virtio-blk,path=/disk.img,readonly=on": {
+			target:  &InvalidValueError{},
+			err:     "unexpected value for virtio-blk 'readonly' option: on",
+			invalid: "readonly=on",
+		},
+		"virtio-gpu,width=0": {
+			target:  &InvalidValueError{},
+			err:     "invalid value for virtio-gpu 'width' option: 0 (expected a value >= 1)",
+			invalid: "width=0",
+		},
+		"virtio-vsock,port=1234": {
+			target: &MissingOptionError{},
+			err:    "missing mandatory 'socketURL' option for virtio-vsock device",
+		},
+	}
+
+	for arg, expected := range tests {
+		t.Run(arg, func(t *testing.T) {
+			vm := &VirtualMachine{}
+			err := vm.AddDevicesFromCmdLine([]string{"virtio-rng", arg})
+			require.EqualError(t, err, expected.err)
+
+			target := expected.target
+			require.True(t, errors.As(err, &target), "%T", err)
+			assert.IsType(t, expected.target, target)
+			location := target.DeviceArgument()
+			assert.Equal(t, 1, location.Index)
+			assert.Equal(t, arg, location.Arg)
+			assert.Equal(t, expected.invalid, arg[location.Start:location.End])
+			if expected.invalid == "" {
+				assert.Equal(t, len(arg), location.Start)
+			}
+		})
+	}
+}
+
+func TestDeviceArgErrorFields(t *testing.T) {
+	vm := &VirtualMachine{}
+	err := vm.AddDevicesFromCmdLine([]string{"nbd,uri=nbd://localhost,sync=async"})
+
+	var valueErr *InvalidValueError
+	require.ErrorAs(t, err, &valueErr)
+	assert.Equal(t, "nbd", valueErr.DeviceType)
+	assert.Equal(t, "sync", valueErr.Key)
+	assert.Equal(t, "async", valueErr.Value)
+	assert.Equal(t, "full|none", valueErr.Expected)
+
+	// errors which are not from a command line argument have no location
+	_, err = (&VirtioVsock{Port: 1234}).ToCmdLine()
+	var missingErr *MissingOptionError
+	require.ErrorAs(t, err, &missingErr)
+	assert.Equal(t, "socketURL", missingErr.Key)
+	assert.Empty(t, missingErr.Arg)
+}
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// These configuration file fields are used to combine several configuration
+// files, they are not part of the VirtualMachine JSON format.
+const (
+	// extendsField is the path to the base configuration file. Like the
+	// other paths, it's relative to the directory of the file which uses it
+	// and it can reference environment variables.
+	extendsField = "extends"
+	// removeDevicesField lists the IDs of the devices from the base
+	// configuration file which must be removed.
+	removeDevicesField = "removeDevices"
+)
+
+// VirtualMachineFromFile creates a VirtualMachine instance from the
+// configuration file at path. The file uses the same JSON format as the one
+// returned by the /vm/inspect REST endpoint. Files with a .yaml or .yml
+// extension are parsed as YAML documents using the same field names.
+//
+// A configuration file can use the 'extends' field to inherit the
+// configuration from a base file. The base configuration is then merged with
+// the configuration file using these rules:
+//   - fields which are set in the configuration file override the base fields.
+//     Objects such as the bootloader are replaced as a whole
+//   - devices listed in 'removeDevices' are removed from the base devices
+//   - a device with the same 'id' as a base device replaces it, at the same
+//     position. Other devices are added after the base devices
+//
+// Devices without 'id' are matched with their legacy identifier: the
+// 'deviceIdentifier' field for virtio-blk and nbd devices, and the 'mountTag'
+// field for virtio-fs and rosetta devices.
+func VirtualMachineFromFile(path string) (*VirtualMachine, error) {
+	return virtualMachineFromFile(path, nil)
+}
+
+// virtualMachineFromFile loads the configuration file at path. extendedBy is
+// the list of files which extend this one, it's used to detect cycles.
+func virtualMachineFromFile(path string, extendedBy []string) (*VirtualMachine, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(extendedBy, absPath) {
+		return nil, fmt.Errorf("circular 'extends' reference to %s", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		data, err = yamlToJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+
+	vm, err := mergeConfigFile(absPath, data, append(extendedBy, absPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return vm, nil
+}
+
+// mergeConfigFile creates a VirtualMachine from the JSON document data read
+// from the file at path, after merging it with its base configuration files.
+// The host paths set in each file are resolved relative to the directory of
+// this file.
+func mergeConfigFile(path string, data []byte, extendedBy []string) (*VirtualMachine, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+
+	var extends string
+	if rawMsg, ok := doc[extendsField]; ok {
+		if err := json.Unmarshal(rawMsg, &extends); err != nil {
+			return nil, fmt.Errorf("invalid '%s' field: %w", extendsField, err)
+		}
+		delete(doc, extendsField)
+	}
+	var removeDevices []string
+	if rawMsg, ok := doc[removeDevicesField]; ok {
+		if err := json.Unmarshal(rawMsg, &removeDevices); err != nil {
+			return nil, fmt.Errorf("invalid '%s' field: %w", removeDevicesField, err)
+		}
+		delete(doc, removeDevicesField)
+	}
+
+	vm := &VirtualMachine{}
+	if extends != "" {
+		extends, err := expandPath(extends, dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid '%s' field: %w", extendsField, err)
+		}
+		vm, err = virtualMachineFromFile(extends, extendedBy)
+		if err != nil {
+			return nil, err
+		}
+	} else if len(removeDevices) != 0 {
+		return nil, fmt.Errorf("'%s' can only be used together with '%s'", removeDevicesField, extendsField)
+	}
+
+	// the components with host paths are unmarshalled separately so that
+	// only the paths set in this file are resolved, the other fields are
+	// applied on top of the base configuration by VirtualMachine.UnmarshalJSON
+	overlayDoc := map[string]json.RawMessage{}
+	for _, field := range []string{"bootloader", "ignition", "devices"} {
+		if rawMsg, ok := doc[field]; ok {
+			overlayDoc[field] = rawMsg
+			delete(doc, field)
+		}
+	}
+	if err := unmarshalConfigDoc(doc, vm); err != nil {
+		return nil, err
+	}
+	overlay := VirtualMachine{}
+	// the overlay may need to be migrated to the current schema version
+	if schemaVersion, ok := doc["schemaVersion"]; ok {
+		overlayDoc["schemaVersion"] = schemaVersion
+	}
+	if err := unmarshalConfigDoc(overlayDoc, &overlay); err != nil {
+		return nil, err
+	}
+	if overlay.Bootloader != nil {
+		if err := resolveHostPaths(overlay.Bootloader, "bootloader", dir); err != nil {
+			return nil, err
+		}
+		vm.Bootloader = overlay.Bootloader
+	}
+	if overlay.Ignition != nil {
+		if err := resolveHostPaths(overlay.Ignition, "ignition", dir); err != nil {
+			return nil, err
+		}
+		vm.Ignition = overlay.Ignition
+	}
+
+	for _, id := range removeDevices {
+		idx := vm.deviceIndexByID(id)
+		if idx == -1 {
+			idx = vm.deviceIndexByIdentifier(id)
+		}
+		if idx == -1 {
+			return nil, fmt.Errorf("cannot remove device '%s': no such device", id)
+		}
+		vm.Devices = slices.Delete(vm.Devices, idx, idx+1)
+	}
+
+	for i, dev := range overlay.Devices {
+		if err := resolveHostPaths(dev, fmt.Sprintf("devices[%d]", i), dir); err != nil {
+			return nil, err
+		}
+		if err := vm.mergeDevice(dev); err != nil {
+			return nil, err
+		}
+	}
+
+	return vm, nil
+}
+
+func unmarshalConfigDoc(doc map[string]json.RawMessage, vm *VirtualMachine) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, vm)
+}
+
+// mergeDevice adds dev to vm, or replaces the base device with the same 'id'.
+// When dev has no 'id', the device with the same legacy identifier is
+// replaced, and dev inherits its 'id'.
+func (vm *VirtualMachine) mergeDevice(dev VirtioDevice) error {
+	if id := deviceID(dev); id != "" {
+		if vm.DeviceByID(id) == nil {
+			vm.Devices = append(vm.Devices, dev)
+			return nil
+		}
+		return vm.ReplaceDevice(id, dev)
+	}
+	idx := vm.deviceIndexByIdentifier(deviceIdentifier(dev))
+	switch {
+	case idx == -1:
+		vm.Devices = append(vm.Devices, dev)
+	case deviceID(vm.Devices[idx]) != "":
+		return vm.ReplaceDevice(deviceID(vm.Devices[idx]), dev)
+	default:
+		vm.Devices[idx] = dev
+	}
+	return nil
+}
+
+// deviceIdentifier returns the legacy identifier used to match devices without
+// 'id' when merging configuration files, or an empty string if dev has no
+// such identifier.
+func deviceIdentifier(dev VirtioDevice) string {
+	switch dev := dev.(type) {
+	case *VirtioBlk:
+		return dev.DeviceIdentifier
+	case *NetworkBlockDevice:
+		return dev.DeviceIdentifier
+	case *VirtioFs:
+		return dev.MountTag
+	case *RosettaShare:
+		return dev.MountTag
+	default:
+		return ""
+	}
+}
+
+// deviceIndexByIdentifier returns the index in vm.Devices of the device whose
+// legacy identifier is id, or -1 if there is no such device.
+func (vm *VirtualMachine) deviceIndexByIdentifier(id string) int {
+	if id == "" {
+		return -1
+	}
+	return slices.IndexFunc(vm.Devices, func(dev VirtioDevice) bool {
+		return deviceIdentifier(dev) == id
+	})
+}
+
+// yamlToJSON converts a YAML document to JSON so that it can be deserialized
+// with the custom JSON unmarshallers of this package.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("empty configuration")
+	}
+
+	return json.Marshal(doc)
+}
@@ -0,0 +1,322 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/common/pkg/strongunits"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var virtualMachineFromFileTests = map[string]struct {
+	fileName string
+	contents string
+}{
+	"JSON": {
+		fileName: "vm.json",
+		contents: `{"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","kernelCmdLine":"console=hvc0","initrdPath":"/initrd"},"devices":[{"kind":"virtiorng"},{"kind":"virtioblk","devName":"virtio-blk","imagePath":"/disk.img","readOnly":false,"deviceIdentifier":""}]}`,
+	},
+	"YAML": {
+		fileName: "vm.yaml",
+		contents: `
+vcpus: 3
+memoryBytes: 4194304000
+bootloader:
+  kind: linuxBootloader
+  vmlinuzPath: /vmlinuz
+  kernelCmdLine: console=hvc0
+  initrdPath: /initrd
+devices:
+  - kind: virtiorng
+  - kind: virtioblk
+    devName: virtio-blk
+    imagePath: /disk.img
+`,
+	},
+	"YML": {
+		fileName: "vm.yml",
+		contents: `{vcpus: 3, memoryBytes: 4194304000, bootloader: {kind: linuxBootloader, vmlinuzPath: /vmlinuz, kernelCmdLine: console=hvc0, initrdPath: /initrd}, devices: [{kind: virtiorng}, {kind: virtioblk, devName: virtio-blk, imagePath: /disk.img}]}`,
+	},
+}
+
+func TestVirtualMachineFromFile(t *testing.T) {
+	for name, test := range virtualMachineFromFileTests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.fileName)
+			require.NoError(t, os.WriteFile(path, []byte(test.contents), 0600))
+
+			vm, err := VirtualMachineFromFile(path)
+			require.NoError(t, err)
+
+			assert.Equal(t, uint(3), vm.Vcpus)
+			assert.Equal(t, strongunits.B(4194304000), vm.Memory)
+			assert.Equal(t, NewLinuxBootloader("/vmlinuz", "console=hvc0", "/initrd"), vm.Bootloader)
+			require.Len(t, vm.Devices, 2)
+			assert.IsType(t, &VirtioRng{}, vm.Devices[0])
+			require.IsType(t, &VirtioBlk{}, vm.Devices[1])
+			assert.Equal(t, "/disk.img", vm.Devices[1].(*VirtioBlk).ImagePath)
+		})
+	}
+}
+
+func TestVirtualMachineFromFileErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := VirtualMachineFromFile(filepath.Join(dir, "missing.json"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	path := filepath.Join(dir, "empty.yaml")
+	require.NoError(t, os.WriteFile(path, []byte{}, 0600))
+	_, err = VirtualMachineFromFile(path)
+	require.ErrorContains(t, err, "empty configuration")
+
+	path = filepath.Join(dir, "invalid.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"vcpus":`), 0600))
+	_, err = VirtualMachineFromFile(path)
+	require.ErrorContains(t, err, "failed to parse")
+}
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+	}
+	return dir
+}
+
+const baseConfig = `
+vcpus: 2
+memoryBytes: 2147483648
+nested: true
+bootloader:
+  kind: efiBootloader
+  efiVariableStorePath: /efi-store
+  createVariableStore: true
+timesync:
+  vsockPort: 1234
+devices:
+  - kind: virtioblk
+    id: root
+    devName: virtio-blk
+    imagePath: /base.img
+  - kind: virtionet
+    nat: true
+    macAddress: "5a:94:ef:e4:0c:dd"
+  - kind: virtiofs
+    id: home
+    sharedDir: /Users/user
+    mountTag: home
+  - kind: virtioserial
+    logFile: /serial.log
+`
+
+func TestVirtualMachineFromFileExtends(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"profiles/base.yaml": baseConfig,
+		"vm1.json": `{
+			"extends": "profiles/base.yaml",
+			"memoryBytes": 4294967296,
+			"bootloader": {"kind":"efiBootloader","efiVariableStorePath":"/vm1-efi-store"},
+			"removeDevices": ["home"],
+			"devices": [
+				{"kind":"virtioblk","id":"root","devName":"virtio-blk","imagePath":"/vm1.img"},
+				{"kind":"virtiorng"}
+			]
+		}`,
+	})
+
+	vm, err := VirtualMachineFromFile(filepath.Join(dir, "vm1.json"))
+	require.NoError(t, err)
+
+	assert.Equal(t, uint(2), vm.Vcpus)
+	assert.Equal(t, strongunits.GiB(4).ToBytes(), vm.Memory)
+	assert.True(t, vm.Nested)
+	// objects are replaced, not merged
+	assert.Equal(t, NewEFIBootloader("/vm1-efi-store", false), vm.Bootloader)
+	assert.Equal(t, &TimeSync{VsockPort: 1234}, vm.Timesync)
+
+	require.Len(t, vm.Devices, 4)
+	require.IsType(t, &VirtioBlk{}, vm.Devices[0])
+	assert.Equal(t, "/vm1.img", vm.Devices[0].(*VirtioBlk).ImagePath)
+	assert.Equal(t, "root", deviceID(vm.Devices[0]))
+	assert.IsType(t, &VirtioNet{}, vm.Devices[1])
+	assert.IsType(t, &VirtioSerial{}, vm.Devices[2])
+	assert.IsType(t, &VirtioRng{}, vm.Devices[3])
+}
+
+func TestVirtualMachineFromFileExtendsLegacyIdentifier(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"base.yaml": `
+devices:
+  - kind: virtioblk
+    devName: virtio-blk
+    imagePath: /base.img
+    deviceIdentifier: data
+  - kind: virtiofs
+    sharedDir: /Users/user
+    mountTag: share
+  - kind: virtiofs
+    id: home
+    sharedDir: /Users/user
+    mountTag: home
+`,
+		"vm.yaml": `
+extends: base.yaml
+removeDevices: [share]
+devices:
+  - kind: virtioblk
+    devName: virtio-blk
+    imagePath: /vm.img
+    deviceIdentifier: data
+  - kind: virtiofs
+    sharedDir: /Users/vm
+    mountTag: home
+`,
+	})
+
+	vm, err := VirtualMachineFromFile(filepath.Join(dir, "vm.yaml"))
+	require.NoError(t, err)
+
+	// devices without 'id' replace the base device with the same
+	// deviceIdentifier or mountTag
+	require.Len(t, vm.Devices, 2)
+	require.IsType(t, &VirtioBlk{}, vm.Devices[0])
+	assert.Equal(t, "/vm.img", vm.Devices[0].(*VirtioBlk).ImagePath)
+	require.IsType(t, &VirtioFs{}, vm.Devices[1])
+	assert.Equal(t, "/Users/vm", vm.Devices[1].(*VirtioFs).SharedDir)
+	assert.Equal(t, "home", deviceID(vm.Devices[1]))
+}
+
+func TestVirtualMachineFromFileExtendsChain(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"base.yaml":  baseConfig,
+		"large.yaml": "extends: base.yaml\nvcpus: 8\n",
+		"vm.yaml":    "extends: large.yaml\nmemoryBytes: 8589934592\n",
+	})
+
+	vm, err := VirtualMachineFromFile(filepath.Join(dir, "vm.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, uint(8), vm.Vcpus)
+	assert.Equal(t, strongunits.GiB(8).ToBytes(), vm.Memory)
+	assert.Len(t, vm.Devices, 4)
+}
+
+func TestVirtualMachineFromFileExtendsErrors(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"base.yaml":         baseConfig,
+		"cycle1.yaml":       "extends: cycle2.yaml\n",
+		"cycle2.yaml":       "extends: cycle1.yaml\n",
+		"self.yaml":         "extends: ./self.yaml\n",
+		"missing.yaml":      "extends: nonexistent.yaml\n",
+		"remove.yaml":       "extends: base.yaml\nremoveDevices: [nonexistent]\n",
+		"removenobase.yaml": "removeDevices: [root]\n",
+	})
+
+	for name, expectedErr := range map[string]string{
+		"cycle1.yaml":       "circular 'extends' reference",
+		"self.yaml":         "circular 'extends' reference",
+		"missing.yaml":      "no such file or directory",
+		"remove.yaml":       "cannot remove device 'nonexistent'",
+		"removenobase.yaml": "'removeDevices' can only be used together with 'extends'",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := VirtualMachineFromFile(filepath.Join(dir, name))
+			require.ErrorContains(t, err, expectedErr)
+		})
+	}
+}
+
+func TestVirtualMachineFromFilePaths(t *testing.T) {
+	t.Setenv("VFKIT_TEST_STATE_DIR", "/var/vfkit")
+	t.Setenv("VFKIT_TEST_EMPTY", "")
+	dir := writeConfigFiles(t, map[string]string{
+		"profiles/base.yaml": `
+vcpus: 2
+memoryBytes: 2GiB
+bootloader:
+  kind: linuxBootloader
+  vmlinuzPath: vmlinuz
+  initrdPath: ${VFKIT_TEST_STATE_DIR}/initrd
+  kernelCmdLine: console=hvc0
+devices:
+  - kind: virtioblk
+    id: root
+    devName: virtio-blk
+    imagePath: images/base.img
+  - kind: virtiofs
+    sharedDir: ${VFKIT_TEST_STATE_DIR}/$share
+    mountTag: share
+`,
+		"vm.yaml": `
+extends: ${VFKIT_TEST_EMPTY}profiles/base.yaml
+ignition:
+  configPath: config.ign
+devices:
+  - kind: virtioserial
+    logFile: logs/serial.log
+  - kind: virtiosock
+    port: 1024
+    socketURL: ${VFKIT_TEST_STATE_DIR}/vsock.sock
+    listen: true
+`,
+	})
+
+	vm, err := VirtualMachineFromFile(filepath.Join(dir, "vm.yaml"))
+	require.NoError(t, err)
+
+	profilesDir := filepath.Join(dir, "profiles")
+	assert.Equal(t, NewLinuxBootloader(filepath.Join(profilesDir, "vmlinuz"), "console=hvc0", "/var/vfkit/initrd"), vm.Bootloader)
+	assert.Equal(t, filepath.Join(dir, "config.ign"), vm.Ignition.ConfigPath)
+	require.Len(t, vm.Devices, 4)
+	assert.Equal(t, filepath.Join(profilesDir, "images/base.img"), vm.Devices[0].(*VirtioBlk).ImagePath)
+	assert.Equal(t, "/var/vfkit/$share", vm.Devices[1].(*VirtioFs).SharedDir)
+	assert.Equal(t, filepath.Join(dir, "logs/serial.log"), vm.Devices[2].(*VirtioSerial).LogFile)
+	assert.Equal(t, "/var/vfkit/vsock.sock", vm.Devices[3].(*VirtioVsock).SocketURL)
+}
+
+func TestVirtualMachineFromFilePathsInherited(t *testing.T) {
+	// the expanded base paths contain a '${' which must not be expanded again
+	t.Setenv("VFKIT_TEST_REFERENCE", "${VFKIT_TEST_UNDEFINED}")
+	dir := writeConfigFiles(t, map[string]string{
+		"base.yaml": `
+bootloader:
+  kind: efiBootloader
+  efiVariableStorePath: /store/${VFKIT_TEST_REFERENCE}
+ignition:
+  configPath: /ignition/${VFKIT_TEST_REFERENCE}
+`,
+		"vm.yaml": "extends: base.yaml\nbootloader: null\nignition: null\nvcpus: 2\n",
+	})
+
+	vm, err := VirtualMachineFromFile(filepath.Join(dir, "vm.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, NewEFIBootloader("/store/${VFKIT_TEST_UNDEFINED}", false), vm.Bootloader)
+	assert.Equal(t, "/ignition/${VFKIT_TEST_UNDEFINED}", vm.Ignition.ConfigPath)
+}
+
+func TestVirtualMachineFromFilePathsErrors(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"undefined.yaml":  "devices: [{kind: virtiorng}, {kind: virtiofs, sharedDir: '${VFKIT_TEST_UNDEFINED}/share'}]\n",
+		"unclosed.yaml":   "bootloader: {kind: efiBootloader, efiVariableStorePath: '${HOME/efi'}\n",
+		"empty.yaml":      "ignition: {configPath: '${}/config.ign'}\n",
+		"extends.yaml":    "extends: ${VFKIT_TEST_UNDEFINED}/base.yaml\n",
+		"nestedbase.yaml": "extends: undefined.yaml\n",
+	})
+
+	for name, expectedErr := range map[string]string{
+		"undefined.yaml":  "devices[1].sharedDir: undefined environment variable VFKIT_TEST_UNDEFINED",
+		"unclosed.yaml":   "bootloader.efiVariableStorePath: missing '}' after '${' in ${HOME/efi",
+		"empty.yaml":      "ignition.configPath: empty environment variable name",
+		"extends.yaml":    "invalid 'extends' field: undefined environment variable VFKIT_TEST_UNDEFINED",
+		"nestedbase.yaml": "undefined.yaml: devices[1].sharedDir: undefined environment variable VFKIT_TEST_UNDEFINED",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := VirtualMachineFromFile(filepath.Join(dir, name))
+			require.ErrorContains(t, err, expectedErr)
+		})
+	}
+}
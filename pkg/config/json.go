@@ -1,9 +1,16 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/containers/common/pkg/strongunits"
+	"github.com/crc-org/vfkit/pkg/util"
 )
 
 // The technique for json (de)serialization was explained here:
@@ -15,6 +22,7 @@ const (
 	// Bootloader kinds
 	efiBootloader   vmComponentKind = "efiBootloader"
 	linuxBootloader vmComponentKind = "linuxBootloader"
+	macosBootloader vmComponentKind = "macosBootloader"
 
 	// VirtIO device kinds
 	vfNet          vmComponentKind = "virtionet"
@@ -41,33 +49,29 @@ func kind(k vmComponentKind) jsonKind {
 	return jsonKind{Kind: k}
 }
 
+// bootloaderKinds associates each bootloader 'kind' with a function returning
+// an empty instance of the corresponding Go type.
+var bootloaderKinds = map[vmComponentKind]func() Bootloader{
+	efiBootloader:   func() Bootloader { return &EFIBootloader{} },
+	linuxBootloader: func() Bootloader { return &LinuxBootloader{} },
+	macosBootloader: func() Bootloader { return &MacOSBootloader{} },
+}
+
 func unmarshalBootloader(rawMsg json.RawMessage) (Bootloader, error) {
-	var (
-		kind       jsonKind
-		bootloader Bootloader
-		err        error
-	)
+	var kind jsonKind
 	if err := json.Unmarshal(rawMsg, &kind); err != nil {
 		return nil, err
 	}
-	switch kind.Kind {
-	case efiBootloader:
-		var efi EFIBootloader
-		err = json.Unmarshal(rawMsg, &efi)
-		if err == nil {
-			bootloader = &efi
-		}
-	case linuxBootloader:
-		var linux LinuxBootloader
-		err = json.Unmarshal(rawMsg, &linux)
-		if err == nil {
-			bootloader = &linux
-		}
-	default:
-		err = fmt.Errorf("unknown 'kind' field: '%s'", kind)
+	newBootloader, ok := bootloaderKinds[kind.Kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown 'kind' field: '%s'", kind)
+	}
+	bootloader := newBootloader()
+	if err := json.Unmarshal(rawMsg, bootloader); err != nil {
+		return nil, err
 	}
 
-	return bootloader, err
+	return bootloader, nil
 }
 
 func unmarshalDevices(rawMsg json.RawMessage) ([]VirtioDevice, error) {
@@ -99,158 +103,182 @@ func unmarshalIgnition(rawMsg json.RawMessage) (Ignition, error) {
 	if err != nil {
 		return Ignition{}, err
 	}
+	// VsockPort is not serialized as it's hardcoded in ignition source code
+	ignition.VsockPort = ignitionVsockPort
 
 	return ignition, nil
 }
 
-// VirtioNet needs a custom unmarshaller as net.HardwareAddress is not
-// serialized/unserialized in its expected format, instead of
-// '00:11:22:33:44:55', it's serialized as base64-encoded raw bytes such as
-// 'ABEiM0RV'. This custom (un)marshalling code will use the desired format.
-func unmarshalVirtioNet(rawMsg json.RawMessage) (*VirtioNet, error) {
-	var dev virtioNetForMarshalling
-
-	// defaults to true for backwards compatibility with vfkit versions which did not have this field
-	dev.VfkitMagic = true
-
-	err := json.Unmarshal(rawMsg, &dev)
-	if err != nil {
+func unmarshalDevice(rawMsg json.RawMessage) (VirtioDevice, error) {
+	var kind jsonKind
+	if err := json.Unmarshal(rawMsg, &kind); err != nil {
 		return nil, err
 	}
-	if dev.MacAddress != "" {
-		macAddr, err := net.ParseMAC(dev.MacAddress)
-		if err != nil {
-			return nil, err
-		}
-		dev.VirtioNet.MacAddress = macAddr
+	devType, ok := deviceTypeByKind(kind.Kind)
+	if !ok {
+		return nil, fmt.Errorf("unknown 'kind' field: '%s'", kind)
 	}
-	// vfkitMagic is only useful in combination with unixSocketPath
-	if dev.UnixSocketPath == "" {
-		dev.VfkitMagic = false
+	dev := devType.newEmptyDevice()
+	if err := json.Unmarshal(rawMsg, dev); err != nil {
+		return nil, err
 	}
-	return &dev.VirtioNet, nil
+
+	return dev, nil
 }
 
-func unmarshalDevice(rawMsg json.RawMessage) (VirtioDevice, error) {
-	var (
-		kind jsonKind
-		dev  VirtioDevice
-		err  error
-	)
-	if err := json.Unmarshal(rawMsg, &kind); err != nil {
-		return nil, err
+// unmarshalSize parses a size which is either a number of bytes, or a string
+// with a unit such as "4GiB". Strings without unit are rejected, since they
+// would be in bytes here but in mebibytes with --memory.
+func unmarshalSize(rawMsg json.RawMessage) (strongunits.B, error) {
+	var size uint64
+	if err := json.Unmarshal(rawMsg, &size); err == nil {
+		return strongunits.B(size), nil
 	}
-	switch kind.Kind {
-	case vfNet:
-		dev, err = unmarshalVirtioNet(rawMsg)
-	case vfVsock:
-		var newDevice VirtioVsock
-		err = json.Unmarshal(rawMsg, &newDevice)
-		dev = &newDevice
-	case vfBlk:
-		var newDevice VirtioBlk
-		err = json.Unmarshal(rawMsg, &newDevice)
-		dev = &newDevice
-	case nvme:
-		var newDevice NVMExpressController
-		err = json.Unmarshal(rawMsg, &newDevice)
-		dev = &newDevice
-	case vfFs:
-		var newDevice VirtioFs
-		err = json.Unmarshal(rawMsg, &newDevice)
-		dev = &newDevice
-	case rosetta:
-		var newDevice RosettaShare
-		err = json.Unmarshal(rawMsg, &newDevice)
-		dev = &newDevice
-	case vfRng:
-		var newDevice VirtioRng
-		err = json.Unmarshal(rawMsg, &newDevice)
-		dev = &newDevice
-	case vfBalloon:
-		var newDevice VirtioBalloon
-		err = json.Unmarshal(rawMsg, &newDevice)
-		dev = &newDevice
-	case vfSerial:
-		var newDevice VirtioSerial
-		err = json.Unmarshal(rawMsg, &newDevice)
-		dev = &newDevice
-	case vfGpu:
-		var newDevice VirtioGPU
-		err = json.Unmarshal(rawMsg, &newDevice)
-		dev = &newDevice
-	case vfInput:
-		var newDevice VirtioInput
-		err = json.Unmarshal(rawMsg, &newDevice)
-		dev = &newDevice
-	case usbMassStorage:
-		var newDevice USBMassStorage
-		err = json.Unmarshal(rawMsg, &newDevice)
-		dev = &newDevice
-	case vfNbd:
-		var newDevice NetworkBlockDevice
-		err = json.Unmarshal(rawMsg, &newDevice)
-		dev = &newDevice
-	default:
-		err = fmt.Errorf("unknown 'kind' field: '%s'", kind)
+	var str string
+	if err := json.Unmarshal(rawMsg, &str); err != nil {
+		return 0, fmt.Errorf("invalid size: %s", rawMsg)
 	}
-
-	if err != nil {
-		return nil, err
+	if _, err := strconv.ParseUint(strings.TrimSpace(str), 10, 64); err == nil {
+		return 0, fmt.Errorf("invalid size: %s (a unit such as 'MiB' is required for sizes given as strings)", str)
 	}
-	return dev, nil
+	return util.ParseSize(str, strongunits.B(1))
+}
+
+// isJSONNull returns true if rawMsg is empty or is the JSON null value
+func isJSONNull(rawMsg json.RawMessage) bool {
+	return len(rawMsg) == 0 || bytes.Equal(rawMsg, []byte("null"))
 }
 
 // UnmarshalJSON is a custom deserializer for VirtualMachine.  The custom work
 // is needed because VirtualMachine uses interfaces in its struct and JSON cannot
 // determine which implementation of the interface to deserialize to.
+// Fields which are not present in the JSON data are left untouched.
+// Documents using an older schema version are migrated to the current one
+// before being deserialized.
 func (vm *VirtualMachine) UnmarshalJSON(b []byte) error {
-	var (
-		err   error
-		input map[string]*json.RawMessage
-	)
+	b, err := migrateSchema(b)
+	if err != nil {
+		return err
+	}
+
+	// virtualMachine has the same fields as VirtualMachine without its
+	// UnmarshalJSON method, this avoids infinite recursion
+	type virtualMachine VirtualMachine
+	input := struct {
+		virtualMachine
+		Memory     json.RawMessage `json:"memoryBytes"`
+		Bootloader json.RawMessage `json:"bootloader"`
+		Devices    json.RawMessage `json:"devices"`
+		Timesync   json.RawMessage `json:"timesync"`
+		Ignition   json.RawMessage `json:"ignition"`
+	}{
+		virtualMachine: virtualMachine(*vm),
+	}
 
 	if err := json.Unmarshal(b, &input); err != nil {
 		return err
 	}
+	newVM := VirtualMachine(input.virtualMachine)
 
-	for idx, rawMsg := range input {
-		if rawMsg == nil {
-			continue
+	if !isJSONNull(input.Memory) {
+		memory, err := unmarshalSize(input.Memory)
+		if err != nil {
+			return fmt.Errorf("invalid memoryBytes value: %w", err)
+		}
+		newVM.Memory = memory
+	}
+	if !isJSONNull(input.Bootloader) {
+		bootloader, err := unmarshalBootloader(input.Bootloader)
+		if err != nil {
+			return err
 		}
-		switch idx {
-		case "vcpus":
-			err = json.Unmarshal(*rawMsg, &vm.Vcpus)
-		case "memoryBytes":
-			err = json.Unmarshal(*rawMsg, &vm.Memory)
-		case "bootloader":
-			var bootloader Bootloader
-			bootloader, err = unmarshalBootloader(*rawMsg)
-			if err == nil {
-				vm.Bootloader = bootloader
-			}
-		case "timesync":
-			err = json.Unmarshal(*rawMsg, &vm.Timesync)
-		case "devices":
-			var devices []VirtioDevice
-			devices, err = unmarshalDevices(*rawMsg)
-			if err == nil {
-				vm.Devices = devices
-			}
-		case "ignition":
-			ignition, err := unmarshalIgnition(*rawMsg)
-			if err == nil {
-				vm.Ignition = &ignition
-			}
+		newVM.Bootloader = bootloader
+	}
+	if !isJSONNull(input.Devices) {
+		devices, err := unmarshalDevices(input.Devices)
+		if err != nil {
+			return err
 		}
-
+		newVM.Devices = devices
+	}
+	if !isJSONNull(input.Timesync) {
+		// a new TimeSync is allocated so that vm.Timesync is replaced
+		// instead of being modified in place
+		var timesync TimeSync
+		if err := json.Unmarshal(input.Timesync, &timesync); err != nil {
+			return err
+		}
+		newVM.Timesync = &timesync
+	}
+	if !isJSONNull(input.Ignition) {
+		ignition, err := unmarshalIgnition(input.Ignition)
 		if err != nil {
 			return err
 		}
+		newVM.Ignition = &ignition
 	}
+
+	*vm = newVM
+
 	return nil
 }
 
+// MarshalJSON is a custom serializer for VirtualMachine which adds the
+// schema version to the JSON document.
+func (vm *VirtualMachine) MarshalJSON() ([]byte, error) {
+	type virtualMachine VirtualMachine
+	input := virtualMachine(*vm)
+	if vm.Devices != nil {
+		input.Devices = make([]VirtioDevice, 0, len(vm.Devices))
+		for _, dev := range vm.Devices {
+			input.Devices = append(input.Devices, kindDevice{dev})
+		}
+	}
+	return json.Marshal(struct {
+		SchemaVersion int `json:"schemaVersion"`
+		virtualMachine
+	}{
+		SchemaVersion:  SchemaVersion,
+		virtualMachine: input,
+	})
+}
+
+// kindDevice adds the 'kind' field of the device type to the JSON
+// representation of devices which don't set it in their MarshalJSON method,
+// such as the devices registered by other packages.
+type kindDevice struct {
+	VirtioDevice
+}
+
+func (dev kindDevice) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(dev.VirtioDevice)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("%T must be serialized as a JSON object", dev.VirtioDevice)
+	}
+	if _, ok := fields["kind"]; ok {
+		return data, nil
+	}
+
+	devType, ok := DeviceTypeOf(dev.VirtioDevice)
+	if !ok {
+		return nil, fmt.Errorf("unregistered device type %T", dev.VirtioDevice)
+	}
+	kindData, err := json.Marshal(kind(vmComponentKind(devType.Kind)))
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return kindData, nil
+	}
+	// insert the 'kind' field first, as done by the devices of this package
+	trimmed := bytes.TrimSpace(data)
+	return slices.Concat(kindData[:len(kindData)-1], []byte(","), trimmed[1:]), nil
+}
+
 func (bootloader *EFIBootloader) MarshalJSON() ([]byte, error) {
 	type blWithKind struct {
 		jsonKind
@@ -273,9 +301,35 @@ func (bootloader *LinuxBootloader) MarshalJSON() ([]byte, error) {
 	})
 }
 
+func (bootloader *MacOSBootloader) MarshalJSON() ([]byte, error) {
+	type blWithKind struct {
+		jsonKind
+		MacOSBootloader
+	}
+	return json.Marshal(blWithKind{
+		jsonKind:        kind(macosBootloader),
+		MacOSBootloader: *bootloader,
+	})
+}
+
+// virtioNet has the same fields as VirtioNet, but none of its methods
+type virtioNet VirtioNet
+
+// VirtioNet needs a custom (un)marshaller as net.HardwareAddress is not
+// serialized/unserialized in its expected format, instead of
+// '00:11:22:33:44:55', it's serialized as base64-encoded raw bytes such as
+// 'ABEiM0RV'. This custom (un)marshalling code will use the desired format.
+// The socket file is serialized using its file descriptor number, similarly
+// to the 'fd' command line option. It's only informative, the file descriptor
+// is only valid in the process using the device, so it's ignored when
+// deserializing the device, which then has no socket.
 type virtioNetForMarshalling struct {
-	VirtioNet
+	virtioNet
 	MacAddress string `json:"macAddress,omitempty"`
+	// vfkitMagic defaulted to true when it was missing in older documents,
+	// so it must always be serialized when it's relevant
+	VfkitMagic *bool    `json:"vfkitMagic,omitempty"`
+	Fd         *uintptr `json:"fd,omitempty"`
 }
 
 func (dev *VirtioNet) MarshalJSON() ([]byte, error) {
@@ -283,15 +337,49 @@ func (dev *VirtioNet) MarshalJSON() ([]byte, error) {
 		jsonKind
 		virtioNetForMarshalling
 	}
+	netDev := virtioNetForMarshalling{
+		virtioNet:  virtioNet(*dev),
+		MacAddress: dev.MacAddress.String(),
+	}
+	if dev.UnixSocketPath != "" {
+		vfkitMagic := dev.VfkitMagic
+		netDev.VfkitMagic = &vfkitMagic
+	}
+	if dev.Socket != nil {
+		fd := dev.Socket.Fd()
+		netDev.Fd = &fd
+	}
 	return json.Marshal(devWithKind{
-		jsonKind: kind(vfNet),
-		virtioNetForMarshalling: virtioNetForMarshalling{
-			VirtioNet:  *dev,
-			MacAddress: dev.MacAddress.String(),
-		},
+		jsonKind:                kind(vfNet),
+		virtioNetForMarshalling: netDev,
 	})
 }
 
+func (dev *VirtioNet) UnmarshalJSON(b []byte) error {
+	var netDev virtioNetForMarshalling
+
+	err := json.Unmarshal(b, &netDev)
+	if err != nil {
+		return err
+	}
+	newDev := VirtioNet(netDev.virtioNet)
+	if netDev.MacAddress != "" {
+		macAddr, err := net.ParseMAC(netDev.MacAddress)
+		if err != nil {
+			return err
+		}
+		newDev.MacAddress = macAddr
+	}
+	// vfkitMagic is only useful in combination with unixSocketPath. Older
+	// documents where it was omitted are handled by migrateV0ToV1
+	newDev.VfkitMagic = newDev.UnixSocketPath != "" && netDev.VfkitMagic != nil && *netDev.VfkitMagic
+	// the file descriptor number is ignored, it's only valid in the process
+	// which serialized the device
+	*dev = newDev
+
+	return nil
+}
+
 func (dev *VirtioVsock) MarshalJSON() ([]byte, error) {
 	type devWithKind struct {
 		jsonKind
@@ -3,10 +3,13 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"reflect"
 	"slices"
 	"testing"
 
+	"github.com/containers/common/pkg/strongunits"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -60,11 +63,11 @@ type jsonTest struct {
 var jsonTests = map[string]jsonTest{
 	"TestLinuxVM": {
 		newVM:        newLinuxVM,
-		expectedJSON: `{"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","initrdPath":"/initrd","kernelCmdLine":"console=hvc0"}}`,
+		expectedJSON: `{"schemaVersion":1,"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","initrdPath":"/initrd","kernelCmdLine":"console=hvc0"}}`,
 	},
 	"TestUEFIVM": {
 		newVM:        newUEFIVM,
-		expectedJSON: `{"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"efiBootloader","efiVariableStorePath":"/variable-store","createVariableStore":false}}`,
+		expectedJSON: `{"schemaVersion":1,"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"efiBootloader","efiVariableStorePath":"/variable-store","createVariableStore":false}}`,
 	},
 	"TestTimeSync": {
 		newVM: func(t *testing.T) *VirtualMachine {
@@ -74,7 +77,7 @@ var jsonTests = map[string]jsonTest{
 			vm.Timesync = timesync.(*TimeSync)
 			return vm
 		},
-		expectedJSON: `{"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","initrdPath":"/initrd","kernelCmdLine":"console=hvc0"},"timesync":{"vsockPort":1234}}`,
+		expectedJSON: `{"schemaVersion":1,"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","initrdPath":"/initrd","kernelCmdLine":"console=hvc0"},"timesync":{"vsockPort":1234}}`,
 	},
 	"TestIgnition": {
 		newVM: func(t *testing.T) *VirtualMachine {
@@ -82,10 +85,28 @@ var jsonTests = map[string]jsonTest{
 			ignition, err := IgnitionNew("config", "socket")
 			require.NoError(t, err)
 			vm.Ignition = ignition
-			ignition.VsockPort = 0 // slight hack for the test to pass as VsockPort is not serialized
 			return vm
 		},
-		expectedJSON: `{"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","initrdPath":"/initrd","kernelCmdLine":"console=hvc0"}, "ignition":{"kind":"ignition","configPath":"config"}}`,
+		expectedJSON: `{"schemaVersion":1,"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","initrdPath":"/initrd","kernelCmdLine":"console=hvc0"}, "ignition":{"kind":"ignition","configPath":"config"}}`,
+	},
+	"TestMacOSVM": {
+		newVM: func(t *testing.T) *VirtualMachine {
+			bootloader := &MacOSBootloader{
+				MachineIdentifierPath: "/machine-identifier",
+				HardwareModelPath:     "/hardware-model",
+				AuxImagePath:          "/aux-image",
+			}
+			return NewVirtualMachine(3, 4_000, bootloader)
+		},
+		expectedJSON: `{"schemaVersion":1,"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"macosBootloader","machineIdentifierPath":"/machine-identifier","hardwareModelPath":"/hardware-model","auxImagePath":"/aux-image"}}`,
+	},
+	"TestNested": {
+		newVM: func(t *testing.T) *VirtualMachine {
+			vm := newLinuxVM(t)
+			vm.Nested = true
+			return vm
+		},
+		expectedJSON: `{"schemaVersion":1,"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","initrdPath":"/initrd","kernelCmdLine":"console=hvc0"},"nested":true}`,
 	},
 	"TestVirtioRNG": {
 		newVM: func(t *testing.T) *VirtualMachine {
@@ -96,7 +117,7 @@ var jsonTests = map[string]jsonTest{
 			require.NoError(t, err)
 			return vm
 		},
-		expectedJSON: `{"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","initrdPath":"/initrd","kernelCmdLine":"console=hvc0"},"devices":[{"kind":"virtiorng"}]}`,
+		expectedJSON: `{"schemaVersion":1,"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","initrdPath":"/initrd","kernelCmdLine":"console=hvc0"},"devices":[{"kind":"virtiorng"}]}`,
 	},
 	"TestVirtioBalloon": {
 		newVM: func(t *testing.T) *VirtualMachine {
@@ -107,7 +128,7 @@ var jsonTests = map[string]jsonTest{
 			require.NoError(t, err)
 			return vm
 		},
-		expectedJSON: `{"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","initrdPath":"/initrd","kernelCmdLine":"console=hvc0"},"devices":[{"kind":"virtioballoon"}]}`,
+		expectedJSON: `{"schemaVersion":1,"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","initrdPath":"/initrd","kernelCmdLine":"console=hvc0"},"devices":[{"kind":"virtioballoon"}]}`,
 	},
 	"TestMultipleVirtioBlk": {
 		newVM: func(t *testing.T) *VirtualMachine {
@@ -123,7 +144,7 @@ var jsonTests = map[string]jsonTest{
 			require.NoError(t, err)
 			return vm
 		},
-		expectedJSON: `{"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","initrdPath":"/initrd","kernelCmdLine":"console=hvc0"},"devices":[{"kind":"virtioblk","devName":"virtio-blk","imagePath":"/virtioblk1"},{"kind":"virtioblk","devName":"virtio-blk","imagePath":"/virtioblk2","deviceIdentifier":"virtio-blk2"}]}`,
+		expectedJSON: `{"schemaVersion":1,"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","initrdPath":"/initrd","kernelCmdLine":"console=hvc0"},"devices":[{"kind":"virtioblk","devName":"virtio-blk","imagePath":"/virtioblk1"},{"kind":"virtioblk","devName":"virtio-blk","imagePath":"/virtioblk2","deviceIdentifier":"virtio-blk2"}]}`,
 	},
 	"TestAllVirtioDevices": {
 		newVM: func(t *testing.T) *VirtualMachine {
@@ -181,7 +202,7 @@ var jsonTests = map[string]jsonTest{
 
 			return vm
 		},
-		expectedJSON: `{"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","initrdPath":"/initrd","kernelCmdLine":"console=hvc0"},"devices":[{"kind":"virtioserial","logFile":"/virtioserial"},{"kind":"virtioinput","inputType":"keyboard"},{"kind":"virtiogpu","usesGUI":false,"width":800,"height":600},{"kind":"virtionet","nat":true,"macAddress":"00:11:22:33:44:55"},{"kind":"virtiorng"},{"kind":"virtioblk","devName":"virtio-blk","imagePath":"/virtioblk"},{"kind":"virtiosock","port":1234,"socketURL":"/virtiovsock"},{"kind":"virtiofs","mountTag":"tag","sharedDir":"/virtiofs"},{"kind":"usbmassstorage","devName":"usb-mass-storage","imagePath":"/usbmassstorage","readOnly":true},{"kind":"rosetta","mountTag":"vz-rosetta","installRosetta":false,"ignoreIfMissing":false},{"kind":"nbd", "devName":"nbd", "uri":"uri", "DeviceIdentifier":"", "SynchronizationMode":"full","Timeout":1000000}]}`,
+		expectedJSON: `{"schemaVersion":1,"vcpus":3,"memoryBytes":4194304000,"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","initrdPath":"/initrd","kernelCmdLine":"console=hvc0"},"devices":[{"kind":"virtioserial","logFile":"/virtioserial"},{"kind":"virtioinput","inputType":"keyboard"},{"kind":"virtiogpu","usesGUI":false,"width":800,"height":600},{"kind":"virtionet","nat":true,"macAddress":"00:11:22:33:44:55"},{"kind":"virtiorng"},{"kind":"virtioblk","devName":"virtio-blk","imagePath":"/virtioblk"},{"kind":"virtiosock","port":1234,"socketURL":"/virtiovsock"},{"kind":"virtiofs","mountTag":"tag","sharedDir":"/virtiofs"},{"kind":"usbmassstorage","devName":"usb-mass-storage","imagePath":"/usbmassstorage","readOnly":true},{"kind":"rosetta","mountTag":"vz-rosetta","installRosetta":false,"ignoreIfMissing":false},{"kind":"nbd", "devName":"nbd", "uri":"uri", "DeviceIdentifier":"", "SynchronizationMode":"full","Timeout":1000000}]}`,
 	},
 }
 
@@ -224,36 +245,36 @@ var jsonStabilityTests = map[string]jsonStabilityTest{
 			return vm
 		},
 		skipFields:   []string{"Bootloader", "Devices", "Timesync", "Ignition", "Nested", "PidFile"},
-		expectedJSON: `{"vcpus":3,"memoryBytes":3,"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","kernelCmdLine":"console=hvc0","initrdPath":"/initrd"},"devices":[{"kind":"virtiorng"}],"timesync":{"vsockPort":1234}}`,
+		expectedJSON: `{"schemaVersion":1,"vcpus":3,"memoryBytes":3,"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","kernelCmdLine":"console=hvc0","initrdPath":"/initrd"},"devices":[{"kind":"virtiorng"}],"timesync":{"vsockPort":1234}}`,
 	},
 	"RosettaShare": {
 		obj:          &RosettaShare{},
-		expectedJSON: `{"kind":"rosetta","mountTag":"MountTag","installRosetta":true,"ignoreIfMissing":true}`,
+		expectedJSON: `{"kind":"rosetta","id":"ID","mountTag":"MountTag","installRosetta":true,"ignoreIfMissing":true}`,
 	},
 	"VirtioFs": {
 		obj:          &VirtioFs{},
-		expectedJSON: `{"kind":"virtiofs","mountTag":"MountTag","sharedDir":"SharedDir"}`,
+		expectedJSON: `{"kind":"virtiofs","id":"ID","mountTag":"MountTag","sharedDir":"SharedDir"}`,
 	},
 	"VirtioGPU": {
 		obj:          &VirtioGPU{},
-		expectedJSON: `{"kind":"virtiogpu","usesGUI":true,"width":2,"height":2}`,
+		expectedJSON: `{"kind":"virtiogpu","id":"ID","usesGUI":true,"width":2,"height":2}`,
 	},
 	"VirtioNet": {
 		obj:          &VirtioNet{},
 		skipFields:   []string{"Socket"},
-		expectedJSON: `{"kind":"virtionet","nat":true,"unixSocketPath":"UnixSocketPath","vfkitMagic":true,"macAddress":"00:11:22:33:44:55"}`,
+		expectedJSON: `{"kind":"virtionet","id":"ID","nat":true,"unixSocketPath":"UnixSocketPath","vfkitMagic":true,"macAddress":"00:11:22:33:44:55"}`,
 	},
 	"VirtioRNG": {
 		obj:          &VirtioRng{},
-		expectedJSON: `{"kind":"virtiorng"}`,
+		expectedJSON: `{"kind":"virtiorng","id":"ID"}`,
 	},
 	"VirtioSerial": {
 		obj:          &VirtioSerial{},
-		expectedJSON: `{"kind":"virtioserial","logFile":"LogFile","ptyName":"PtyName","usesPty":true,"usesStdio":true}`,
+		expectedJSON: `{"kind":"virtioserial","id":"ID","logFile":"LogFile","ptyName":"PtyName","usesPty":true,"usesStdio":true}`,
 	},
 	"VirtioVsock": {
 		obj:          &VirtioVsock{},
-		expectedJSON: `{"kind":"virtiosock","port":3,"socketURL":"SocketURL","listen":true}`,
+		expectedJSON: `{"kind":"virtiosock","id":"ID","port":3,"socketURL":"SocketURL","listen":true}`,
 	},
 	"VirtioInput/keyboard": {
 		newObjectFunc: func(t *testing.T) any {
@@ -262,7 +283,7 @@ var jsonStabilityTests = map[string]jsonStabilityTest{
 			return input
 		},
 		skipFields:   []string{"InputType"},
-		expectedJSON: `{"kind":"virtioinput","inputType":"keyboard"}`,
+		expectedJSON: `{"kind":"virtioinput","id":"ID","inputType":"keyboard"}`,
 	},
 	"VirtioInput/pointingDevice": {
 		newObjectFunc: func(t *testing.T) any {
@@ -271,7 +292,7 @@ var jsonStabilityTests = map[string]jsonStabilityTest{
 			return input
 		},
 		skipFields:   []string{"InputType"},
-		expectedJSON: `{"kind":"virtioinput","inputType":"pointing"}`,
+		expectedJSON: `{"kind":"virtioinput","id":"ID","inputType":"pointing"}`,
 	},
 	"VirtioBlk": {
 		newObjectFunc: func(t *testing.T) any {
@@ -282,7 +303,7 @@ var jsonStabilityTests = map[string]jsonStabilityTest{
 		},
 
 		skipFields:   []string{"DevName", "URI", "Type"},
-		expectedJSON: `{"kind":"virtioblk","devName":"virtio-blk","imagePath":"ImagePath","readOnly":true,"type":"image","deviceIdentifier":"DeviceIdentifier"}`,
+		expectedJSON: `{"kind":"virtioblk","id":"ID","devName":"virtio-blk","imagePath":"ImagePath","readOnly":true,"type":"image","ephemeral":true,"deviceIdentifier":"DeviceIdentifier"}`,
 	},
 	"USBMassStorage": {
 		newObjectFunc: func(t *testing.T) any {
@@ -292,7 +313,7 @@ var jsonStabilityTests = map[string]jsonStabilityTest{
 			return usb
 		},
 		skipFields:   []string{"DevName", "URI", "Type"},
-		expectedJSON: `{"kind":"usbmassstorage","devName":"usb-mass-storage","imagePath":"ImagePath","readOnly":true,"type":"image"}`,
+		expectedJSON: `{"kind":"usbmassstorage","id":"ID","devName":"usb-mass-storage","imagePath":"ImagePath","readOnly":true,"type":"image","ephemeral":true}`,
 	},
 	"NVMExpressController": {
 		newObjectFunc: func(t *testing.T) any {
@@ -302,7 +323,7 @@ var jsonStabilityTests = map[string]jsonStabilityTest{
 			return nvme
 		},
 		skipFields:   []string{"DevName", "URI", "Type"},
-		expectedJSON: `{"kind":"nvme","devName":"nvme","imagePath":"ImagePath","readOnly":true,"type":"image"}`,
+		expectedJSON: `{"kind":"nvme","id":"ID","devName":"nvme","imagePath":"ImagePath","readOnly":true,"type":"image","ephemeral":true}`,
 	},
 	"LinuxBootloader": {
 		obj:          &LinuxBootloader{},
@@ -312,6 +333,10 @@ var jsonStabilityTests = map[string]jsonStabilityTest{
 		obj:          &EFIBootloader{},
 		expectedJSON: `{"kind":"efiBootloader","efiVariableStorePath":"EFIVariableStorePath","createVariableStore":true}`,
 	},
+	"MacOSBootloader": {
+		obj:          &MacOSBootloader{},
+		expectedJSON: `{"kind":"macosBootloader","machineIdentifierPath":"MachineIdentifierPath","hardwareModelPath":"HardwareModelPath","auxImagePath":"AuxImagePath"}`,
+	},
 	"TimeSync": {
 		obj:          &TimeSync{},
 		expectedJSON: `{"vsockPort":3}`,
@@ -323,7 +348,7 @@ var jsonStabilityTests = map[string]jsonStabilityTest{
 			return nbd
 		},
 		skipFields:   []string{"DevName", "ImagePath"},
-		expectedJSON: `{"kind":"nbd","DeviceIdentifier":"DeviceIdentifier","devName":"nbd","uri":"URI","readOnly":true,"SynchronizationMode":"SynchronizationMode","Timeout":2}`,
+		expectedJSON: `{"kind":"nbd","id":"ID","DeviceIdentifier":"DeviceIdentifier","devName":"nbd","uri":"URI","readOnly":true,"SynchronizationMode":"SynchronizationMode","Timeout":2}`,
 	},
 }
 
@@ -383,6 +408,135 @@ func TestVirtioNetBackwardsCompat(t *testing.T) {
 	t.Run("VfkitMagicJsonExplicitDefault", func(t *testing.T) { testVirtioNetVfkitMagicJson(t, true, false) })
 }
 
+func TestVirtioNetVfkitMagicFalseRoundTrip(t *testing.T) {
+	dev, err := VirtioNetNew("00:11:22:33:44:55")
+	require.NoError(t, err)
+	dev.SetUnixSocketPath("/some/path/to/socket")
+	dev.VfkitMagic = false
+
+	data, err := json.Marshal(dev)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"kind":"virtionet","nat":false,"unixSocketPath":"/some/path/to/socket","macAddress":"00:11:22:33:44:55","vfkitMagic":false}`, string(data))
+
+	var unmarshalledDev VirtioNet
+	err = json.Unmarshal(data, &unmarshalledDev)
+	require.NoError(t, err)
+	require.Equal(t, *dev, unmarshalledDev)
+}
+
+func TestVirtioNetSocketJSON(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "socket")
+	require.NoError(t, err)
+	defer file.Close()
+
+	dev, err := VirtioNetNew("00:11:22:33:44:55")
+	require.NoError(t, err)
+	dev.SetSocket(file)
+
+	data, err := json.Marshal(dev)
+	require.NoError(t, err)
+	require.JSONEq(t, fmt.Sprintf(`{"kind":"virtionet","nat":false,"macAddress":"00:11:22:33:44:55","fd":%d}`, file.Fd()), string(data))
+
+	var unmarshalledDev VirtioNet
+	err = json.Unmarshal(data, &unmarshalledDev)
+	require.NoError(t, err)
+	require.Nil(t, unmarshalledDev.Socket)
+	unmarshalledDev.Socket = dev.Socket
+	require.Equal(t, *dev, unmarshalledDev)
+
+	// VirtualMachine JSON output, such as the one of /vm/inspect, can be
+	// read back
+	vm := NewVirtualMachine(1, 512, NewEFIBootloader("/efi-store", false))
+	require.NoError(t, vm.AddDevice(dev))
+	data, err = json.Marshal(vm)
+	require.NoError(t, err)
+	var unmarshalledVM VirtualMachine
+	require.NoError(t, json.Unmarshal(data, &unmarshalledVM))
+	require.Len(t, unmarshalledVM.Devices, 1)
+	require.Nil(t, unmarshalledVM.Devices[0].(*VirtioNet).Socket)
+}
+
+// roundTripSkipFields lists the fields which can't be set by fillStruct for
+// each type. They are tested separately.
+var roundTripSkipFields = map[string][]string{
+	"VirtioNet": {"Socket"},
+}
+
+// TestJSONRoundTrip checks that serializing a virtual machine using any
+// bootloader and device type, and deserializing it again is lossless.
+func TestJSONRoundTrip(t *testing.T) {
+	testRoundTrip := func(t *testing.T, vm *VirtualMachine) {
+		data, err := json.Marshal(vm)
+		require.NoError(t, err)
+
+		var unmarshalledVM VirtualMachine
+		err = json.Unmarshal(data, &unmarshalledVM)
+		require.NoError(t, err)
+		require.Equal(t, *vm, unmarshalledVM)
+	}
+
+	for kind, newBootloader := range bootloaderKinds {
+		t.Run(string(kind), func(t *testing.T) {
+			bootloader := newBootloader()
+			fillStruct(t, bootloader, roundTripSkipFields[reflect.TypeOf(bootloader).Elem().Name()])
+			testRoundTrip(t, NewVirtualMachine(3, 4_000, bootloader))
+		})
+	}
+
+	for _, devType := range DeviceTypes() {
+		t.Run(devType.Kind, func(t *testing.T) {
+			dev := devType.newEmptyDevice()
+			fillStruct(t, dev, roundTripSkipFields[reflect.TypeOf(dev).Elem().Name()])
+			vm := newLinuxVM(t)
+			vm.Devices = []VirtioDevice{dev}
+			testRoundTrip(t, vm)
+		})
+	}
+
+	t.Run("VirtualMachine", func(t *testing.T) {
+		vm := newLinuxVM(t)
+		fillStruct(t, vm, []string{"Bootloader", "Devices", "Timesync", "Ignition"})
+		vm.Timesync = &TimeSync{VsockPort: 1234}
+		ignition, err := IgnitionNew("config", "socket")
+		require.NoError(t, err)
+		vm.Ignition = ignition
+		vm.Devices = []VirtioDevice{&VirtioRng{}, &VirtioBalloon{}}
+		testRoundTrip(t, vm)
+	})
+}
+
+func TestJSONUnmarshalKeepsMissingFields(t *testing.T) {
+	vm := newLinuxVM(t)
+	vm.Nested = true
+	err := json.Unmarshal([]byte(`{"vcpus":4}`), vm)
+	require.NoError(t, err)
+
+	expectedVM := newLinuxVM(t)
+	expectedVM.Vcpus = 4
+	expectedVM.Nested = true
+	require.Equal(t, expectedVM, vm)
+}
+
+func TestJSONMemorySize(t *testing.T) {
+	for memory, expected := range map[string]strongunits.B{
+		`4294967296`: strongunits.GiB(4).ToBytes(),
+		`"4GiB"`:     strongunits.GiB(4).ToBytes(),
+		`"2G"`:       strongunits.GiB(2).ToBytes(),
+		`"512 MiB"`:  strongunits.MiB(512).ToBytes(),
+	} {
+		var vm VirtualMachine
+		err := json.Unmarshal([]byte(`{"vcpus":3,"memoryBytes":`+memory+`}`), &vm)
+		require.NoError(t, err, memory)
+		assert.Equal(t, expected, vm.Memory, memory)
+	}
+
+	for _, memory := range []string{`"4PiB"`, `-1`, `true`, `"large"`, `"2048"`} {
+		var vm VirtualMachine
+		err := json.Unmarshal([]byte(`{"vcpus":3,"memoryBytes":`+memory+`}`), &vm)
+		require.ErrorContains(t, err, "invalid memoryBytes value", memory)
+	}
+}
+
 func TestJSON(t *testing.T) {
 	t.Run("json", func(t *testing.T) {
 		for name := range jsonTests {
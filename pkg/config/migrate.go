@@ -0,0 +1,81 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// SchemaVersion is the version of the JSON format used to serialize
+// VirtualMachine. It must be increased, and a new migration must be added to
+// schemaMigrations, every time a change to the format would cause older
+// documents to be deserialized incorrectly.
+const SchemaVersion = 1
+
+// schemaMigrations[i] upgrades a JSON document from schema version i to
+// version i+1. Documents without a schemaVersion field are version 0.
+var schemaMigrations = []func(doc map[string]any) error{
+	migrateV0ToV1,
+}
+
+// migrateSchema upgrades the JSON document b to the current SchemaVersion.
+// Documents which are already at the current version are returned unchanged.
+func migrateSchema(b []byte) ([]byte, error) {
+	var versionInfo struct {
+		SchemaVersion *int `json:"schemaVersion"`
+	}
+	if err := json.Unmarshal(b, &versionInfo); err != nil {
+		return nil, err
+	}
+	version := 0
+	if versionInfo.SchemaVersion != nil {
+		version = *versionInfo.SchemaVersion
+	}
+	switch {
+	case version < 0:
+		return nil, fmt.Errorf("invalid configuration schema version %d", version)
+	case version > SchemaVersion:
+		return nil, fmt.Errorf("configuration schema version %d is too new, this version of vfkit only supports schema versions up to %d", version, SchemaVersion)
+	case version == SchemaVersion:
+		return b, nil
+	}
+
+	var doc map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	// avoid loss of precision for large values such as memoryBytes
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	for ; version < SchemaVersion; version++ {
+		if err := schemaMigrations[version](doc); err != nil {
+			return nil, fmt.Errorf("failed to migrate configuration from schema version %d: %w", version, err)
+		}
+	}
+	doc["schemaVersion"] = SchemaVersion
+
+	return json.Marshal(doc)
+}
+
+// migrateV0ToV1 sets vfkitMagic on virtio-net devices using unixSocketPath.
+// Before schema version 1, vfkitMagic was omitted when it was true.
+func migrateV0ToV1(doc map[string]any) error {
+	devices, ok := doc["devices"].([]any)
+	if !ok {
+		return nil
+	}
+	for _, device := range devices {
+		dev, ok := device.(map[string]any)
+		if !ok || dev["kind"] != string(vfNet) {
+			continue
+		}
+		if unixSocketPath, _ := dev["unixSocketPath"].(string); unixSocketPath == "" {
+			continue
+		}
+		if _, ok := dev["vfkitMagic"]; !ok {
+			dev["vfkitMagic"] = true
+		}
+	}
+
+	return nil
+}
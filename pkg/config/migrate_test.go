@@ -0,0 +1,62 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const migrationTestBootloader = `"bootloader":{"kind":"linuxBootloader","vmlinuzPath":"/vmlinuz","initrdPath":"/initrd","kernelCmdLine":"console=hvc0"}`
+
+var migrationTests = map[string]struct {
+	json               string
+	expectedVfkitMagic bool
+}{
+	"V0VfkitMagicDefault": {
+		json:               `{"vcpus":3,"memoryBytes":4194304000,` + migrationTestBootloader + `,"devices":[{"kind":"virtionet","nat":false,"unixSocketPath":"/socket"}]}`,
+		expectedVfkitMagic: true,
+	},
+	"V0VfkitMagicFalse": {
+		json:               `{"vcpus":3,"memoryBytes":4194304000,` + migrationTestBootloader + `,"devices":[{"kind":"virtionet","nat":false,"unixSocketPath":"/socket","vfkitMagic":false}]}`,
+		expectedVfkitMagic: false,
+	},
+	"V1VfkitMagicMissing": {
+		json:               `{"schemaVersion":1,"vcpus":3,"memoryBytes":4194304000,` + migrationTestBootloader + `,"devices":[{"kind":"virtionet","nat":false,"unixSocketPath":"/socket"}]}`,
+		expectedVfkitMagic: false,
+	},
+}
+
+func TestSchemaMigration(t *testing.T) {
+	for name, test := range migrationTests {
+		t.Run(name, func(t *testing.T) {
+			var vm VirtualMachine
+			err := json.Unmarshal([]byte(test.json), &vm)
+			require.NoError(t, err)
+
+			require.Equal(t, uint(3), vm.Vcpus)
+			require.Equal(t, newLinuxVM(t).Bootloader, vm.Bootloader)
+			netDevs := vm.VirtioNetDevices()
+			require.Len(t, netDevs, 1)
+			require.Equal(t, test.expectedVfkitMagic, netDevs[0].VfkitMagic)
+		})
+	}
+}
+
+func TestSchemaMigrationLargeValues(t *testing.T) {
+	var vm VirtualMachine
+	err := json.Unmarshal([]byte(`{"vcpus":3,"memoryBytes":18014398509481985,`+migrationTestBootloader+`}`), &vm)
+	require.NoError(t, err)
+	require.Equal(t, uint64(18014398509481985), uint64(vm.Memory))
+}
+
+func TestSchemaVersionTooNew(t *testing.T) {
+	var vm VirtualMachine
+	newVersion := SchemaVersion + 1
+	err := json.Unmarshal([]byte(fmt.Sprintf(`{"schemaVersion":%d,"vcpus":3,"memoryBytes":4194304000,%s}`, newVersion, migrationTestBootloader)), &vm)
+	require.ErrorContains(t, err, fmt.Sprintf("configuration schema version %d is too new", newVersion))
+
+	err = json.Unmarshal([]byte(`{"schemaVersion":-1,"vcpus":3}`), &vm)
+	require.ErrorContains(t, err, "invalid configuration schema version")
+}
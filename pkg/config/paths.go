@@ -0,0 +1,128 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hostPath is a field of a virtual machine component which contains a path on
+// the host.
+type hostPath struct {
+	// field is the JSON name of the field
+	field string
+	path  *string
+}
+
+// hostPathsProvider is implemented by the virtual machine components which
+// have fields containing paths on the host.
+type hostPathsProvider interface {
+	hostPaths() []hostPath
+}
+
+func (bootloader *LinuxBootloader) hostPaths() []hostPath {
+	return []hostPath{
+		{"vmlinuzPath", &bootloader.VmlinuzPath},
+		{"initrdPath", &bootloader.InitrdPath},
+	}
+}
+
+func (bootloader *EFIBootloader) hostPaths() []hostPath {
+	return []hostPath{{"efiVariableStorePath", &bootloader.EFIVariableStorePath}}
+}
+
+func (bootloader *MacOSBootloader) hostPaths() []hostPath {
+	return []hostPath{
+		{"machineIdentifierPath", &bootloader.MachineIdentifierPath},
+		{"hardwareModelPath", &bootloader.HardwareModelPath},
+		{"auxImagePath", &bootloader.AuxImagePath},
+	}
+}
+
+func (ign *Ignition) hostPaths() []hostPath {
+	return []hostPath{
+		{"configPath", &ign.ConfigPath},
+		{"socketPath", &ign.SocketPath},
+	}
+}
+
+func (config *DiskStorageConfig) hostPaths() []hostPath {
+	return []hostPath{{"imagePath", &config.ImagePath}}
+}
+
+func (dev *VirtioFs) hostPaths() []hostPath {
+	return []hostPath{{"sharedDir", &dev.SharedDir}}
+}
+
+func (dev *VirtioSerial) hostPaths() []hostPath {
+	return []hostPath{{"logFile", &dev.LogFile}}
+}
+
+func (dev *VirtioVsock) hostPaths() []hostPath {
+	return []hostPath{{"socketURL", &dev.SocketURL}}
+}
+
+func (dev *VirtioNet) hostPaths() []hostPath {
+	return []hostPath{{"unixSocketPath", &dev.UnixSocketPath}}
+}
+
+// resolveHostPaths expands the environment variables in the host paths of
+// component, and makes relative paths relative to dir. field is the name of
+// component in the JSON representation of the virtual machine, it's used in
+// error messages.
+func resolveHostPaths(component any, field string, dir string) error {
+	provider, ok := component.(hostPathsProvider)
+	if !ok {
+		return nil
+	}
+	for _, hostPath := range provider.hostPaths() {
+		if *hostPath.path == "" {
+			continue
+		}
+		path, err := expandPath(*hostPath.path, dir)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", field, hostPath.field, err)
+		}
+		*hostPath.path = path
+	}
+
+	return nil
+}
+
+// expandPath replaces the ${NAME} references to environment variables in path
+// with their value, and makes the resulting path relative to dir if it's not
+// absolute. A '$' which is not followed by '{' is kept as is. Referencing an
+// undefined environment variable is an error.
+func expandPath(path string, dir string) (string, error) {
+	var expanded strings.Builder
+	original := path
+	for {
+		start := strings.Index(path, "${")
+		if start == -1 {
+			expanded.WriteString(path)
+			break
+		}
+		expanded.WriteString(path[:start])
+		path = path[start+len("${"):]
+		end := strings.IndexByte(path, '}')
+		if end == -1 {
+			return "", fmt.Errorf("missing '}' after '${' in %s", original)
+		}
+		name := path[:end]
+		if name == "" {
+			return "", fmt.Errorf("empty environment variable name")
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("undefined environment variable %s", name)
+		}
+		expanded.WriteString(value)
+		path = path[end+1:]
+	}
+
+	if filepath.IsAbs(expanded.String()) {
+		return expanded.String(), nil
+	}
+	return filepath.Join(dir, expanded.String()), nil
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// DeviceType describes a type of device which can be added to the virtual
+// machine. The devices provided by vfkit are registered by this package, other
+// packages can register their own device types with RegisterDeviceType.
+//
+// The command line options of a device are parsed by its FromOptions method,
+// and its JSON representation uses encoding/json with an additional 'kind'
+// field. The `option` struct tags of the device fields are used to generate its
+// help text, see DeviceHelp. On macOS, devices are added to the virtual machine
+// by the converters registered with vf.RegisterDeviceConverter, and
+// vf.NewVirtualMachine fails if a registered device type has no converter.
+type DeviceType struct {
+	// Name is the device type used on the command line, such as
+	// "virtio-blk".
+	Name string
+	// Kind is the value of the 'kind' field of the JSON representation of
+	// the device, such as "virtioblk".
+	Kind string
+	// Description is a short description of the device.
+	Description string
+	// New returns a new device of this type with its default values set.
+	// It must always return a pointer to the same struct type.
+	New func() VirtioDevice
+}
+
+// goType returns the Go type of the devices of this type.
+func (devType *DeviceType) goType() reflect.Type {
+	return reflect.TypeOf(devType.New())
+}
+
+var deviceRegistry = []*DeviceType{}
+
+// RegisterDeviceType adds devType to the device types which can be used in
+// the virtual machine configuration. Its name, kind and Go type must not be
+// used by another device type. It must be called before the virtual machine
+// configuration is parsed, usually from an init function.
+func RegisterDeviceType(devType DeviceType) error {
+	if devType.Name == "" || devType.Kind == "" || devType.New == nil {
+		return fmt.Errorf("device types need a name, a kind and a New function")
+	}
+	if strings.ContainsAny(devType.Name, ",=\"") {
+		return fmt.Errorf("invalid device type name: %s", devType.Name)
+	}
+	typ := devType.goType()
+	if typ.Kind() != reflect.Pointer || typ.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%s devices must be pointers to structs, not %s", devType.Name, typ)
+	}
+	if _, err := deviceOptionSpecs(typ.Elem()); err != nil {
+		return fmt.Errorf("invalid %s device type: %w", devType.Name, err)
+	}
+	for _, registered := range deviceRegistry {
+		switch {
+		case registered.Name == devType.Name:
+			return fmt.Errorf("device type %s is already registered", devType.Name)
+		case registered.Kind == devType.Kind:
+			return fmt.Errorf("device kind %s is already registered", devType.Kind)
+		case registered.goType() == typ:
+			return fmt.Errorf("%s is already registered as %s", typ, registered.Name)
+		}
+	}
+	deviceRegistry = append(deviceRegistry, &devType)
+
+	return nil
+}
+
+// DeviceTypes returns the registered device types, sorted by name.
+func DeviceTypes() []DeviceType {
+	devTypes := make([]DeviceType, 0, len(deviceRegistry))
+	for _, devType := range deviceRegistry {
+		devTypes = append(devTypes, *devType)
+	}
+	slices.SortFunc(devTypes, func(a, b DeviceType) int { return strings.Compare(a.Name, b.Name) })
+
+	return devTypes
+}
+
+// LookupDeviceType returns the device type whose command line name is name.
+func LookupDeviceType(name string) (DeviceType, bool) {
+	for _, devType := range deviceRegistry {
+		if devType.Name == name {
+			return *devType, true
+		}
+	}
+	return DeviceType{}, false
+}
+
+// DeviceTypeOf returns the device type of dev.
+func DeviceTypeOf(dev VirtioDevice) (DeviceType, bool) {
+	typ := reflect.TypeOf(dev)
+	for _, devType := range deviceRegistry {
+		if devType.goType() == typ {
+			return *devType, true
+		}
+	}
+	return DeviceType{}, false
+}
+
+// deviceTypeByKind returns the device type whose JSON 'kind' is kind.
+func deviceTypeByKind(kind vmComponentKind) (DeviceType, bool) {
+	for _, devType := range deviceRegistry {
+		if devType.Kind == string(kind) {
+			return *devType, true
+		}
+	}
+	return DeviceType{}, false
+}
+
+// newEmptyDevice returns a device of type devType with all its fields set to
+// their zero value, it's used to unmarshal JSON devices.
+func (devType *DeviceType) newEmptyDevice() VirtioDevice {
+	return reflect.New(devType.goType().Elem()).Interface().(VirtioDevice)
+}
+
+func init() {
+	for _, devType := range []DeviceType{
+		{
+			Name:        "virtio-blk",
+			Kind:        string(vfBlk),
+			Description: "virtio block device backed by a disk image or a block device",
+			New:         func() VirtioDevice { return virtioBlkNewEmpty() },
+		},
+		{
+			Name:        "nvme",
+			Kind:        string(nvme),
+			Description: "NVM Express disk backed by a disk image or a block device",
+			New:         func() VirtioDevice { return nvmExpressControllerNewEmpty() },
+		},
+		{
+			Name:        "usb-mass-storage",
+			Kind:        string(usbMassStorage),
+			Description: "USB disk backed by a disk image, usually an ISO image",
+			New:         func() VirtioDevice { return usbMassStorageNewEmpty() },
+		},
+		{
+			Name:        "nbd",
+			Kind:        string(vfNbd),
+			Description: "disk backed by a Network Block Device export",
+			New:         func() VirtioDevice { return networkBlockDeviceNewEmpty() },
+		},
+		{
+			Name:        "virtio-fs",
+			Kind:        string(vfFs),
+			Description: "shares a host directory with the guest",
+			New:         func() VirtioDevice { return &VirtioFs{} },
+		},
+		{
+			Name:        "rosetta",
+			Kind:        string(rosetta),
+			Description: "shares Rosetta with Linux guests to run x86_64 binaries",
+			New:         func() VirtioDevice { return &RosettaShare{} },
+		},
+		{
+			Name:        "virtio-net",
+			Kind:        string(vfNet),
+			Description: "network interface",
+			New:         func() VirtioDevice { return &VirtioNet{} },
+		},
+		{
+			Name:        "virtio-rng",
+			Kind:        string(vfRng),
+			Description: "random number generator",
+			New:         func() VirtioDevice { return &VirtioRng{} },
+		},
+		{
+			Name:        "virtio-serial",
+			Kind:        string(vfSerial),
+			Description: "serial port",
+			New:         func() VirtioDevice { return &VirtioSerial{} },
+		},
+		{
+			Name:        "virtio-vsock",
+			Kind:        string(vfVsock),
+			Description: "vsock connection between the host and the guest",
+			// default to listen for backwards compatibility
+			New: func() VirtioDevice { return &VirtioVsock{Listen: true} },
+		},
+		{
+			Name:        "virtio-input",
+			Kind:        string(vfInput),
+			Description: "keyboard or pointing device",
+			New:         func() VirtioDevice { return &VirtioInput{} },
+		},
+		{
+			Name:        "virtio-gpu",
+			Kind:        string(vfGpu),
+			Description: "graphics device",
+			New: func() VirtioDevice {
+				dev, _ := VirtioGPUNew()
+				return dev
+			},
+		},
+		{
+			Name:        "virtio-balloon",
+			Kind:        string(vfBalloon),
+			Description: "memory balloon to reclaim guest memory",
+			New:         func() VirtioDevice { return &VirtioBalloon{} },
+		},
+	} {
+		if err := RegisterDeviceType(devType); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testVsockNet is a device defined outside of this package, using only its
+// exported API.
+type testVsockNet struct {
+	DeviceInfo
+	Port      uint32 `json:"port" option:"port,required" help:"vsock port"`
+	SocketURL string `json:"socketURL" option:"socketURL,required" help:"path to the unix socket"`
+	Offload   bool   `json:"offload,omitempty" option:"offload,flag" help:"enable offloading"`
+}
+
+func (dev *testVsockNet) FromOptions(options []Option) error {
+	return DecodeDeviceOptions(dev, options)
+}
+
+func (dev *testVsockNet) ToCmdLine() ([]string, error) {
+	return EncodeDeviceOptions(dev)
+}
+
+func registerTestDeviceType(t *testing.T) {
+	registry := deviceRegistry
+	t.Cleanup(func() { deviceRegistry = registry })
+
+	err := RegisterDeviceType(DeviceType{
+		Name:        "test-vsock-net",
+		Kind:        "testvsocknet",
+		Description: "network over vsock",
+		New:         func() VirtioDevice { return &testVsockNet{Port: 1024} },
+	})
+	require.NoError(t, err)
+}
+
+func TestRegisterDeviceType(t *testing.T) {
+	registerTestDeviceType(t)
+
+	devType, ok := LookupDeviceType("test-vsock-net")
+	require.True(t, ok)
+	assert.Equal(t, "testvsocknet", devType.Kind)
+	names := []string{}
+	for _, devType := range DeviceTypes() {
+		names = append(names, devType.Name)
+	}
+	assert.Contains(t, names, "test-vsock-net")
+	assert.True(t, slices.IsSorted(names))
+
+	vm := NewVirtualMachine(1, 512, NewEFIBootloader("/efi-store", true))
+	err := vm.AddDevicesFromCmdLine([]string{"test-vsock-net,socketURL=/net.sock,offload,id=net0", "virtio-rng"})
+	require.NoError(t, err)
+	assert.Equal(t, &testVsockNet{DeviceInfo: DeviceInfo{ID: "net0"}, Port: 1024, SocketURL: "/net.sock", Offload: true}, vm.Devices[0])
+
+	args, err := vm.Devices[0].ToCmdLine()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--device", "test-vsock-net,port=1024,socketURL=/net.sock,id=net0,offload"}, args)
+
+	data, err := json.Marshal(vm)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"devices":[{"kind":"testvsocknet","id":"net0","port":1024,"socketURL":"/net.sock","offload":true},{"kind":"virtiorng"}]`)
+	var unmarshalledVM VirtualMachine
+	err = json.Unmarshal(data, &unmarshalledVM)
+	require.NoError(t, err)
+	assert.Equal(t, vm, &unmarshalledVM)
+
+	help, err := DeviceHelp("test-vsock-net")
+	require.NoError(t, err)
+	assert.Contains(t, help, "  port=<number>       vsock port (default: 1024)\n")
+
+	schema := loadTestSchema(t)
+	assert.Contains(t, schema.Defs, "testvsocknet")
+}
+
+func TestRegisterDeviceTypeErrors(t *testing.T) {
+	registerTestDeviceType(t)
+
+	newDevice := func() VirtioDevice { return &testVsockNet{} }
+	for _, devType := range []DeviceType{
+		{Name: "test-vsock-net", Kind: "other", New: func() VirtioDevice { return &VirtioBalloon{} }},
+		{Name: "other", Kind: "virtioblk", New: func() VirtioDevice { return &VirtioBalloon{} }},
+		{Name: "other", Kind: "other", New: newDevice},
+		{Name: "other,name", Kind: "other", New: newDevice},
+		{Name: "other", Kind: "other"},
+	} {
+		err := RegisterDeviceType(devType)
+		require.Error(t, err, devType.Name)
+	}
+	_, ok := LookupDeviceType("other")
+	assert.False(t, ok)
+}
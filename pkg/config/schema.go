@@ -0,0 +1,295 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/containers/common/pkg/strongunits"
+)
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+var (
+	bootloaderType = reflect.TypeOf((*Bootloader)(nil)).Elem()
+	deviceType     = reflect.TypeOf((*VirtioDevice)(nil)).Elem()
+	sizeType       = reflect.TypeOf(strongunits.B(0))
+)
+
+// schemaTypes lists the Go types which are used to generate the JSON schema
+// of types with a custom JSON marshaller.
+var schemaTypes = map[reflect.Type]reflect.Type{
+	reflect.TypeOf(VirtioNet{}): reflect.TypeOf(virtioNetForMarshalling{}),
+}
+
+// schemaEnums lists the allowed values for the string types used in the
+// configuration.
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(DiskBackendType("")):        {string(DiskBackendDefault), string(DiskBackendImage), string(DiskBackendBlockDevice)},
+	reflect.TypeOf(NBDSynchronizationMode("")): {string(SynchronizationFullMode), string(SynchronizationNoneMode)},
+}
+
+// JSONSchema returns a JSON Schema document describing the JSON format used
+// to serialize VirtualMachine. It's generated from the Go types of this
+// package, bootloaders and devices are described using their 'kind' field.
+func JSONSchema() ([]byte, error) {
+	gen := newSchemaGenerator()
+
+	schema, err := gen.objectSchema(reflect.TypeOf(VirtualMachine{}))
+	if err != nil {
+		return nil, err
+	}
+	properties := schema["properties"].(map[string]any)
+	properties["schemaVersion"] = map[string]any{
+		"type":    "integer",
+		"minimum": 0,
+		"maximum": SchemaVersion,
+	}
+	// fields only used in configuration files
+	properties[extendsField] = map[string]any{"type": "string"}
+	properties[removeDevicesField] = map[string]any{
+		"type":  "array",
+		"items": map[string]any{"type": "string"},
+	}
+	schema["$schema"] = jsonSchemaDialect
+	schema["title"] = "vfkit virtual machine configuration"
+	schema["$defs"] = gen.defs
+
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+type schemaGenerator struct {
+	// kinds associates Go types with their JSON 'kind'
+	kinds map[reflect.Type]vmComponentKind
+	defs  map[string]any
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	gen := &schemaGenerator{
+		kinds: map[reflect.Type]vmComponentKind{
+			reflect.TypeOf(Ignition{}): ignition,
+		},
+		defs: map[string]any{},
+	}
+	for kind, newBootloader := range bootloaderKinds {
+		gen.kinds[reflect.TypeOf(newBootloader()).Elem()] = kind
+	}
+	for _, devType := range deviceRegistry {
+		gen.kinds[devType.goType().Elem()] = vmComponentKind(devType.Kind)
+	}
+
+	return gen
+}
+
+// oneOfKinds returns a schema matching any of the types registered for kinds
+func (gen *schemaGenerator) oneOfKinds(kinds []vmComponentKind, newComponent func(vmComponentKind) any) (map[string]any, error) {
+	kinds = slices.Clone(kinds)
+	slices.Sort(kinds)
+	oneOf := []any{}
+	for _, kind := range kinds {
+		ref, err := gen.typeSchema(reflect.TypeOf(newComponent(kind)))
+		if err != nil {
+			return nil, err
+		}
+		oneOf = append(oneOf, ref)
+	}
+	return map[string]any{"oneOf": oneOf}, nil
+}
+
+func (gen *schemaGenerator) typeSchema(typ reflect.Type) (map[string]any, error) {
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	switch typ {
+	case bootloaderType:
+		kinds := make([]vmComponentKind, 0, len(bootloaderKinds))
+		for kind := range bootloaderKinds {
+			kinds = append(kinds, kind)
+		}
+		return gen.oneOfKinds(kinds, func(kind vmComponentKind) any { return bootloaderKinds[kind]() })
+	case deviceType:
+		kinds := make([]vmComponentKind, 0, len(deviceRegistry))
+		for _, devType := range deviceRegistry {
+			kinds = append(kinds, vmComponentKind(devType.Kind))
+		}
+		return gen.oneOfKinds(kinds, func(kind vmComponentKind) any {
+			devType, _ := deviceTypeByKind(kind)
+			return devType.newEmptyDevice()
+		})
+	}
+
+	if kind, ok := gen.kinds[typ]; ok {
+		name := string(kind)
+		if _, ok := gen.defs[name]; !ok {
+			// placeholder to avoid infinite recursion
+			gen.defs[name] = nil
+			schema, err := gen.objectSchema(typ)
+			if err != nil {
+				return nil, err
+			}
+			gen.defs[name] = schema
+		}
+		return map[string]any{"$ref": "#/$defs/" + name}, nil
+	}
+
+	if typ == sizeType {
+		return map[string]any{
+			"oneOf": []any{
+				map[string]any{"type": "integer", "minimum": 0},
+				map[string]any{"type": "string", "pattern": `^\s*[0-9]+\s*([bB]|[kKmMgGtT]([iI]?[bB])?)\s*$`},
+			},
+		}, nil
+	}
+	if enum, ok := schemaEnums[typ]; ok {
+		return map[string]any{"type": "string", "enum": enum}, nil
+	}
+
+	switch typ.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return map[string]any{"type": "integer", "minimum": 0}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := gen.typeSchema(typ.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "array", "items": items}, nil
+	case reflect.Struct:
+		return gen.objectSchema(typ)
+	default:
+		return nil, fmt.Errorf("unsupported type %s for JSON schema", typ)
+	}
+}
+
+// objectSchema returns the schema of the struct type typ. If typ has a JSON
+// 'kind', the schema requires it.
+func (gen *schemaGenerator) objectSchema(typ reflect.Type) (map[string]any, error) {
+	kind, hasKind := gen.kinds[typ]
+	if schemaType, ok := schemaTypes[typ]; ok {
+		typ = schemaType
+	}
+
+	properties := map[string]any{}
+	for _, field := range jsonFields(typ) {
+		schema, err := gen.typeSchema(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", typ.Name(), field.Name, err)
+		}
+		properties[field.jsonName] = schema
+	}
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if hasKind {
+		properties["kind"] = map[string]any{"const": string(kind)}
+		schema["required"] = []string{"kind"}
+	}
+
+	return schema, nil
+}
+
+type jsonField struct {
+	reflect.StructField
+	jsonName string
+	tagged   bool
+	depth    int
+}
+
+// jsonFields returns the fields of the struct type typ which are serialized
+// by encoding/json, following its rules for embedded structs and field name
+// conflicts.
+func jsonFields(typ reflect.Type) []jsonField {
+	fields := []jsonField{}
+	collectJSONFields(typ, 0, &fields)
+
+	byName := map[string][]jsonField{}
+	names := []string{}
+	for _, field := range fields {
+		if _, ok := byName[field.jsonName]; !ok {
+			names = append(names, field.jsonName)
+		}
+		byName[field.jsonName] = append(byName[field.jsonName], field)
+	}
+
+	dominantFields := []jsonField{}
+	for _, name := range names {
+		if field, ok := dominantField(byName[name]); ok {
+			dominantFields = append(dominantFields, field)
+		}
+	}
+
+	return dominantFields
+}
+
+func collectJSONFields(typ reflect.Type, depth int, fields *[]jsonField) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			collectJSONFields(fieldType, depth+1, fields)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		jsonName := name
+		if jsonName == "" {
+			jsonName = field.Name
+		}
+		*fields = append(*fields, jsonField{
+			StructField: field,
+			jsonName:    jsonName,
+			tagged:      name != "",
+			depth:       depth,
+		})
+	}
+}
+
+// dominantField returns the field which is serialized when several fields use
+// the same JSON name. If there is no such field, the name is not serialized.
+func dominantField(fields []jsonField) (jsonField, bool) {
+	minDepth := fields[0].depth
+	for _, field := range fields {
+		minDepth = min(minDepth, field.depth)
+	}
+	candidates := []jsonField{}
+	for _, field := range fields {
+		if field.depth == minDepth {
+			candidates = append(candidates, field)
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0], true
+	}
+	tagged := []jsonField{}
+	for _, field := range candidates {
+		if field.tagged {
+			tagged = append(tagged, field)
+		}
+	}
+	if len(tagged) == 1 {
+		return tagged[0], true
+	}
+
+	return jsonField{}, false
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"encoding/json"
+	"maps"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testSchema struct {
+	Properties map[string]any        `json:"properties"`
+	Defs       map[string]testSchema `json:"$defs"`
+}
+
+func loadTestSchema(t *testing.T) testSchema {
+	data, err := JSONSchema()
+	require.NoError(t, err)
+
+	var schema testSchema
+	err = json.Unmarshal(data, &schema)
+	require.NoError(t, err)
+
+	return schema
+}
+
+// checkSchemaProperties checks that the JSON serialization of obj, with all
+// its fields set, has exactly the properties listed in the schema.
+func checkSchemaProperties(t *testing.T, schema testSchema, obj any, skipFields []string) {
+	fillStruct(t, obj, skipFields)
+	data, err := json.Marshal(obj)
+	require.NoError(t, err)
+	var serialized map[string]any
+	err = json.Unmarshal(data, &serialized)
+	require.NoError(t, err)
+
+	expected := slices.Sorted(maps.Keys(schema.Properties))
+	require.Equal(t, expected, slices.Sorted(maps.Keys(serialized)))
+}
+
+func TestJSONSchemaComponents(t *testing.T) {
+	schema := loadTestSchema(t)
+	for kind, newBootloader := range bootloaderKinds {
+		t.Run(string(kind), func(t *testing.T) {
+			require.Contains(t, schema.Defs, string(kind))
+			checkSchemaProperties(t, schema.Defs[string(kind)], newBootloader(), nil)
+		})
+	}
+	for _, devType := range DeviceTypes() {
+		t.Run(devType.Kind, func(t *testing.T) {
+			require.Contains(t, schema.Defs, devType.Kind)
+			dev := devType.newEmptyDevice()
+			defSchema := schema.Defs[devType.Kind]
+			if _, ok := dev.(*VirtioNet); ok {
+				// the socket is serialized as "fd"
+				defSchema.Properties = maps.Clone(defSchema.Properties)
+				delete(defSchema.Properties, "fd")
+			}
+			checkSchemaProperties(t, defSchema, dev, roundTripSkipFields[reflect.TypeOf(dev).Elem().Name()])
+		})
+	}
+	t.Run(string(ignition), func(t *testing.T) {
+		require.Contains(t, schema.Defs, string(ignition))
+		checkSchemaProperties(t, schema.Defs[string(ignition)], &Ignition{}, nil)
+	})
+	require.Len(t, schema.Defs, len(bootloaderKinds)+len(DeviceTypes())+1)
+}
+
+func TestJSONSchemaVirtualMachine(t *testing.T) {
+	schema := loadTestSchema(t)
+
+	vm := newLinuxVM(t)
+	vm.Timesync = &TimeSync{VsockPort: 1234}
+	vm.Ignition = &Ignition{ConfigPath: "config"}
+	vm.Devices = []VirtioDevice{&VirtioRng{}}
+	// these fields are only used in configuration files
+	delete(schema.Properties, extendsField)
+	delete(schema.Properties, removeDevicesField)
+	checkSchemaProperties(t, schema, vm, []string{"Bootloader", "Devices", "Timesync", "Ignition"})
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ValidationError describes a problem found in a virtual machine
+// configuration by VirtualMachine.Validate.
+type ValidationError struct {
+	// Field is the path of the invalid field in the JSON representation of
+	// the virtual machine, for example "devices[2].mountTag".
+	Field string
+	Err   error
+}
+
+func (err *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %v", err.Field, err.Err)
+}
+
+func (err *ValidationError) Unwrap() error {
+	return err.Err
+}
+
+// ValidationErrors is the list of all the problems found in a virtual machine
+// configuration by VirtualMachine.Validate.
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (errs ValidationErrors) Unwrap() []error {
+	unwrapped := make([]error, 0, len(errs))
+	for _, err := range errs {
+		unwrapped = append(unwrapped, err)
+	}
+	return unwrapped
+}
+
+func (errs *ValidationErrors) add(field string, err error) {
+	*errs = append(*errs, &ValidationError{Field: field, Err: err})
+}
+
+// validator is implemented by the devices which need more checks than the
+// ones described by their `option` struct tags.
+type validator interface {
+	validate() error
+}
+
+// Validate checks the consistency of the whole virtual machine configuration,
+// including conflicts between devices which would only be detected by the
+// virtualization framework when starting the virtual machine. When problems
+// are found, all of them are returned in a ValidationErrors error.
+func (vm *VirtualMachine) Validate() error {
+	var errs ValidationErrors
+
+	if vm.Vcpus == 0 {
+		errs.add("vcpus", errors.New("the virtual machine needs at least 1 vCPU"))
+	}
+	if vm.Memory == 0 {
+		errs.add("memoryBytes", errors.New("the virtual machine needs some memory"))
+	}
+	if vm.Bootloader == nil {
+		errs.add("bootloader", errors.New("missing bootloader configuration"))
+	}
+
+	for i, dev := range vm.Devices {
+		if err := validateDevice(dev); err != nil {
+			errs.add(fmt.Sprintf("devices[%d]", i), err)
+		}
+	}
+
+	vm.validateDeviceIDs(&errs)
+	vm.validateMountTags(&errs)
+	vm.validateVsockPorts(&errs)
+	vm.validateSerialPorts(&errs)
+	vm.validateDiskImages(&errs)
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// validateMountTags checks that directory sharing devices use unique mount tags
+func (vm *VirtualMachine) validateMountTags(errs *ValidationErrors) {
+	mountTags := map[string]int{}
+	for i, dev := range vm.Devices {
+		var mountTag string
+		switch dev := dev.(type) {
+		case *VirtioFs:
+			mountTag = dev.MountTag
+			if mountTag == "" {
+				// same default as in pkg/vf
+				mountTag = filepath.Base(dev.SharedDir)
+			}
+		case *RosettaShare:
+			mountTag = dev.MountTag
+		default:
+			continue
+		}
+		if j, ok := mountTags[mountTag]; ok {
+			errs.add(fmt.Sprintf("devices[%d].mountTag", i), fmt.Errorf("mount tag '%s' is already used by devices[%d]", mountTag, j))
+			continue
+		}
+		mountTags[mountTag] = i
+	}
+}
+
+// validateDeviceIDs checks that device IDs are unique. Since the IDs are
+// also used on the command line, they must not contain a ','.
+func (vm *VirtualMachine) validateDeviceIDs(errs *ValidationErrors) {
+	ids := map[string]int{}
+	for i, dev := range vm.Devices {
+		id := deviceID(dev)
+		if id == "" {
+			continue
+		}
+		field := fmt.Sprintf("devices[%d].id", i)
+		if strings.Contains(id, ",") {
+			errs.add(field, fmt.Errorf("device ID '%s' must not contain ','", id))
+			continue
+		}
+		if j, ok := ids[id]; ok {
+			errs.add(field, fmt.Errorf("device ID '%s' is already used by devices[%d]", id, j))
+			continue
+		}
+		ids[id] = i
+	}
+}
+
+// validateVsockPorts checks that vsock ports are used by a single device.
+// The timesync and ignition features also use a vsock port.
+func (vm *VirtualMachine) validateVsockPorts(errs *ValidationErrors) {
+	vsockPorts := map[uint32]string{}
+	addPort := func(field string, port uint32) {
+		if otherField, ok := vsockPorts[port]; ok {
+			errs.add(field, fmt.Errorf("vsock port %d is already used by %s", port, otherField))
+			return
+		}
+		vsockPorts[port] = field
+	}
+
+	if vm.Ignition != nil {
+		addPort("ignition", vm.Ignition.VsockPort)
+	}
+	if vm.Timesync != nil {
+		addPort("timesync.vsockPort", vm.Timesync.VsockPort)
+	}
+	for i, dev := range vm.Devices {
+		if vsock, ok := dev.(*VirtioVsock); ok {
+			addPort(fmt.Sprintf("devices[%d].port", i), vsock.Port)
+		}
+	}
+}
+
+// validateSerialPorts checks that at most one serial port uses stdio
+func (vm *VirtualMachine) validateSerialPorts(errs *ValidationErrors) {
+	stdioDevice := -1
+	for i, dev := range vm.Devices {
+		serial, ok := dev.(*VirtioSerial)
+		if !ok || !serial.UsesStdio {
+			continue
+		}
+		if stdioDevice != -1 {
+			errs.add(fmt.Sprintf("devices[%d].usesStdio", i), fmt.Errorf("stdio is already used by devices[%d]", stdioDevice))
+			continue
+		}
+		stdioDevice = i
+	}
+}
+
+// validateDiskImages checks that the nvme and usb-mass-storage devices use raw
+// disk images. virtio-blk images are already checked by VirtioBlk.validate.
+func (vm *VirtualMachine) validateDiskImages(errs *ValidationErrors) {
+	for i, dev := range vm.Devices {
+		var disk *DiskStorageConfig
+		switch dev := dev.(type) {
+		case *NVMExpressController:
+			disk = &dev.DiskStorageConfig
+		case *USBMassStorage:
+			disk = &dev.DiskStorageConfig
+		default:
+			continue
+		}
+		if err := disk.validateImage(); err != nil {
+			errs.add(fmt.Sprintf("devices[%d].imagePath", i), err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/crc-org/vfkit/pkg/image"
+	"github.com/stretchr/testify/require"
+)
+
+type validateTest struct {
+	newVM          func(*testing.T) *VirtualMachine
+	expectedFields []string
+}
+
+// writeTestImage creates a disk image in a temporary directory, starting with
+// header.
+func writeTestImage(t *testing.T, name string, header string) string {
+	path := filepath.Join(t.TempDir(), name)
+	data := make([]byte, 4096)
+	copy(data, header)
+	err := os.WriteFile(path, data, 0600)
+	require.NoError(t, err)
+	return path
+}
+
+func newValidateTestVM(t *testing.T, devices ...VirtioDevice) *VirtualMachine {
+	vm := newLinuxVM(t)
+	err := vm.AddDevices(devices...)
+	require.NoError(t, err)
+	return vm
+}
+
+var validateTests = map[string]validateTest{
+	"Valid": {
+		newVM: func(t *testing.T) *VirtualMachine {
+			return newValidateTestVM(t,
+				&VirtioFs{SharedDir: "/home/user/share"},
+				&VirtioFs{SharedDir: "/home/user/share", DirectorySharingConfig: DirectorySharingConfig{MountTag: "other"}},
+				&VirtioVsock{Port: 1234, SocketURL: "/vsock"},
+				&VirtioSerial{UsesStdio: true},
+				&VirtioSerial{LogFile: "/serial.log"},
+			)
+		},
+	},
+	"MissingEverything": {
+		newVM: func(_ *testing.T) *VirtualMachine {
+			return &VirtualMachine{}
+		},
+		expectedFields: []string{"vcpus", "memoryBytes", "bootloader"},
+	},
+	"InvalidDevice": {
+		newVM: func(t *testing.T) *VirtualMachine {
+			return newValidateTestVM(t, &VirtioRng{}, &VirtioInput{InputType: "joystick"})
+		},
+		expectedFields: []string{"devices[1]"},
+	},
+	"DuplicateDeviceIDs": {
+		newVM: func(t *testing.T) *VirtualMachine {
+			vm := newValidateTestVM(t,
+				&VirtioRng{DeviceInfo: DeviceInfo{ID: "dev"}},
+				&VirtioBalloon{DeviceInfo: DeviceInfo{ID: "invalid,id"}},
+			)
+			// bypass the checks done by AddDevices
+			vm.Devices = append(vm.Devices, &VirtioSerial{DeviceInfo: DeviceInfo{ID: "dev"}, LogFile: "/serial.log"})
+			return vm
+		},
+		expectedFields: []string{"devices[1].id", "devices[2].id"},
+	},
+	"DuplicateMountTags": {
+		newVM: func(t *testing.T) *VirtualMachine {
+			return newValidateTestVM(t,
+				&VirtioFs{SharedDir: "/home/user/share"},
+				&VirtioFs{SharedDir: "/tmp/share"},
+				&RosettaShare{DirectorySharingConfig: DirectorySharingConfig{MountTag: "share"}},
+			)
+		},
+		expectedFields: []string{"devices[1].mountTag", "devices[2].mountTag"},
+	},
+	"DuplicateVsockPorts": {
+		newVM: func(t *testing.T) *VirtualMachine {
+			return newValidateTestVM(t,
+				&VirtioVsock{Port: 1234, SocketURL: "/vsock1"},
+				&VirtioVsock{Port: 1234, SocketURL: "/vsock2", Listen: true},
+			)
+		},
+		expectedFields: []string{"devices[1].port"},
+	},
+	"TimesyncIgnitionPortConflict": {
+		newVM: func(t *testing.T) *VirtualMachine {
+			vm := newValidateTestVM(t, &VirtioVsock{Port: 1024, SocketURL: "/vsock"})
+			vm.Timesync = &TimeSync{VsockPort: 1024}
+			ignition, err := IgnitionNew("/config.ign", "")
+			require.NoError(t, err)
+			vm.Ignition = ignition
+			return vm
+		},
+		expectedFields: []string{"timesync.vsockPort", "devices[0].port"},
+	},
+	"MultipleStdioSerialPorts": {
+		newVM: func(t *testing.T) *VirtualMachine {
+			return newValidateTestVM(t,
+				&VirtioSerial{UsesStdio: true},
+				&VirtioSerial{UsesPty: true},
+				&VirtioSerial{UsesStdio: true},
+			)
+		},
+		expectedFields: []string{"devices[2].usesStdio"},
+	},
+	"UnsupportedDiskImages": {
+		newVM: func(t *testing.T) *VirtualMachine {
+			nvme, err := NVMExpressControllerNew(writeTestImage(t, "disk.qcow2", "QFI\xfb"))
+			require.NoError(t, err)
+			usb, err := USBMassStorageNew(writeTestImage(t, "disk.img.xz", "\xfd7zXZ\x00"))
+			require.NoError(t, err)
+			raw, err := NVMExpressControllerNew(writeTestImage(t, "disk.img", ""))
+			require.NoError(t, err)
+			missing, err := USBMassStorageNew(filepath.Join(t.TempDir(), "missing.iso"))
+			require.NoError(t, err)
+			return newValidateTestVM(t, nvme, usb, raw, missing)
+		},
+		expectedFields: []string{"devices[0].imagePath", "devices[1].imagePath", "devices[3].imagePath"},
+	},
+}
+
+func TestValidate(t *testing.T) {
+	for name, test := range validateTests {
+		t.Run(name, func(t *testing.T) {
+			err := test.newVM(t).Validate()
+			if len(test.expectedFields) == 0 {
+				require.NoError(t, err)
+				return
+			}
+
+			var validationErrs ValidationErrors
+			require.True(t, errors.As(err, &validationErrs))
+			fields := []string{}
+			for _, validationErr := range validationErrs {
+				fields = append(fields, validationErr.Field)
+			}
+			require.ElementsMatch(t, test.expectedFields, fields)
+		})
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	vm := newValidateTestVM(t,
+		&VirtioVsock{Port: 1234, SocketURL: "/vsock1"},
+		&VirtioVsock{Port: 1234, SocketURL: "/vsock2"},
+	)
+	vm.Bootloader = nil
+
+	err := vm.Validate()
+	require.EqualError(t, err, "bootloader: missing bootloader configuration\ndevices[1].port: vsock port 1234 is already used by devices[0].port")
+
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	require.Equal(t, "bootloader", validationErr.Field)
+}
+
+func TestUnsupportedImageError(t *testing.T) {
+	qcow2Path := writeTestImage(t, "disk.qcow2", "QFI\xfb")
+	dev, err := VirtioBlkNew(qcow2Path)
+	require.NoError(t, err)
+	err = dev.validate()
+	require.EqualError(t, err, "vfkit does not support qcow2 image format, virtio-blk image "+qcow2Path+
+		" must be converted to raw format, for example with 'vfkit image convert "+qcow2Path+" <raw image>'")
+	var imageErr *UnsupportedImageError
+	require.True(t, errors.As(err, &imageErr))
+	require.Equal(t, image.FormatQcow2, imageErr.Info.Format)
+
+	err = &UnsupportedImageError{DevName: "nvme", Path: "/disk.dmg", Info: image.Info{Format: image.FormatDMG}}
+	require.EqualError(t, err, "vfkit does not support dmg image format, nvme image /disk.dmg must be converted to raw format, for example with 'hdiutil convert -format UDTO -o <raw image> /disk.dmg'")
+
+	err = &UnsupportedImageError{DevName: "nvme", Path: "/disk.vdi", Info: image.Info{Format: image.FormatVDI}}
+	require.EqualError(t, err, "vfkit does not support vdi image format, nvme image /disk.vdi must be converted to raw format, for example with 'qemu-img convert -f vdi -O raw /disk.vdi <raw image>'")
+
+	err = &UnsupportedImageError{DevName: "usb-mass-storage", Path: "/disk.img.zst", Info: image.Info{Format: image.FormatUnknown, Compression: image.CompressionZstd}}
+	require.EqualError(t, err, "usb-mass-storage image /disk.img.zst is compressed with zstd, it must be decompressed first, for example with 'zstd -dk /disk.img.zst'")
+
+	// block devices are not checked
+	dev.Type = DiskBackendBlockDevice
+	require.NoError(t, dev.validate())
+	dev.Ephemeral = true
+	require.EqualError(t, dev.validate(), "virtio-blk block device "+qcow2Path+" cannot be ephemeral, only disk images can be")
+}
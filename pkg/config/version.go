@@ -0,0 +1,149 @@
+package config
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// nextReleaseVersion is the first vfkit release providing the command line
+// features which were added after the latest release. It must be updated if
+// the next release uses a different version number.
+const nextReleaseVersion = "v0.7.0"
+
+// cmdLineFeature is a part of the command line syntax which is not understood
+// by all vfkit versions.
+type cmdLineFeature struct {
+	description string
+	minVersion  string
+}
+
+var (
+	unixgramNetFeature = cmdLineFeature{"the virtio-net 'type=unixgram' option", "v0.6.2"}
+	deviceIDFeature    = cmdLineFeature{"the device 'id' option", nextReleaseVersion}
+	quotedValueFeature = cmdLineFeature{"quoted option values", nextReleaseVersion}
+	ephemeralFeature   = cmdLineFeature{"the disk 'ephemeral' option", nextReleaseVersion}
+)
+
+// UnsupportedFeatureError is returned by VirtualMachine.ToCmdLineFor when the
+// virtual machine configuration cannot be expressed with the command line
+// syntax of the target vfkit version.
+type UnsupportedFeatureError struct {
+	// Feature describes the command line syntax which is needed
+	Feature string
+	// MinVersion is the first vfkit version supporting Feature
+	MinVersion string
+	// Version is the target vfkit version
+	Version string
+}
+
+func (err *UnsupportedFeatureError) Error() string {
+	return fmt.Sprintf("%s requires vfkit %s or newer, but the target version is %s", err.Feature, err.MinVersion, err.Version)
+}
+
+// supportedBy returns true if the vfkit version understands feature.
+func (feature cmdLineFeature) supportedBy(version string) bool {
+	return semver.Compare(version, feature.minVersion) >= 0
+}
+
+// check returns an error if the vfkit version does not understand feature.
+func (feature cmdLineFeature) check(version string) error {
+	if feature.supportedBy(version) {
+		return nil
+	}
+	return &UnsupportedFeatureError{Feature: feature.description, MinVersion: feature.minVersion, Version: version}
+}
+
+// versionedCmdLiner is implemented by the devices whose command line depends
+// on the target vfkit version.
+type versionedCmdLiner interface {
+	// toCmdLineFor returns the command line of the device for the vfkit
+	// version, which is a canonical semantic version.
+	toCmdLineFor(version string) ([]string, error)
+}
+
+// deviceCmdLine returns the command line arguments of dev for the vfkit
+// version. dev.ToCmdLine is used when version is empty.
+func deviceCmdLine(dev VirtioDevice, version string) ([]string, error) {
+	if version == "" {
+		return dev.ToCmdLine()
+	}
+
+	var args []string
+	var err error
+	if versionedDev, ok := dev.(versionedCmdLiner); ok {
+		args, err = versionedDev.toCmdLineFor(version)
+	} else {
+		args, err = dev.ToCmdLine()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deviceID(dev) != "" {
+		if err := deviceIDFeature.check(version); err != nil {
+			return nil, err
+		}
+	}
+	if disk, ok := dev.(interface{ ephemeral() bool }); ok && disk.ephemeral() {
+		if err := ephemeralFeature.check(version); err != nil {
+			return nil, err
+		}
+	}
+	// values are only quoted when they contain ',' or '"'
+	if slices.ContainsFunc(args, func(arg string) bool { return strings.Contains(arg, `"`) }) {
+		if err := quotedValueFeature.check(version); err != nil {
+			return nil, err
+		}
+	}
+
+	return args, nil
+}
+
+// checkQuotedValue fails if value must be quoted on the command line and the
+// vfkit version does not support quoted values. Any value is accepted when
+// version is empty.
+func checkQuotedValue(value string, version string) error {
+	if version == "" || quoteOptionValue(value) == value {
+		return nil
+	}
+	return quotedValueFeature.check(version)
+}
+
+// gitDescribeSuffix matches the part added by 'git describe' after the tag of
+// development builds, such as '-12-g0123abcd'.
+var gitDescribeSuffix = regexp.MustCompile(`-[0-9]+-g[0-9a-f]+$`)
+
+// canonicalVersion returns the semantic version corresponding to the vfkit
+// version, which can be given with or without the 'v' prefix. Development
+// builds are considered to be the same as the release they are based on.
+func canonicalVersion(version string) (string, error) {
+	canonical := strings.TrimSuffix(version, "-dirty")
+	canonical = gitDescribeSuffix.ReplaceAllString(canonical, "")
+	if !strings.HasPrefix(canonical, "v") {
+		canonical = "v" + canonical
+	}
+	if !semver.IsValid(canonical) {
+		return "", fmt.Errorf("invalid vfkit version: %s", version)
+	}
+	return semver.Canonical(canonical), nil
+}
+
+// VfkitVersion runs 'vfkitPath --version' and returns the version of the
+// vfkit binary, such as 'v0.6.1'.
+func VfkitVersion(vfkitPath string) (string, error) {
+	out, err := exec.Command(vfkitPath, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the version of %s: %w", vfkitPath, err)
+	}
+	// the output is 'vfkit version: v0.6.1'
+	_, version, found := strings.Cut(strings.TrimSpace(string(out)), "version:")
+	version = strings.TrimSpace(version)
+	if !found || version == "" {
+		return "", fmt.Errorf("unexpected output from '%s --version': %s", vfkitPath, strings.TrimSpace(string(out)))
+	}
+	return version, nil
+}
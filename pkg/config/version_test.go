@@ -0,0 +1,128 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalVersion(t *testing.T) {
+	for version, expected := range map[string]string{
+		"v0.6.1":                    "v0.6.1",
+		"0.6.1":                     "v0.6.1",
+		"v0.7":                      "v0.7.0",
+		"v0.6.1-12-g0123abcd":       "v0.6.1",
+		"v0.6.1-12-g0123abcd-dirty": "v0.6.1",
+		"v0.7.0-rc1":                "v0.7.0-rc1",
+	} {
+		canonical, err := canonicalVersion(version)
+		require.NoError(t, err, version)
+		assert.Equal(t, expected, canonical, version)
+	}
+
+	for _, version := range []string{"", "unknown", "v0.6.x"} {
+		_, err := canonicalVersion(version)
+		require.EqualError(t, err, "invalid vfkit version: "+version)
+	}
+}
+
+func TestToCmdLineFor(t *testing.T) {
+	vm := NewVirtualMachine(1, 512, NewEFIBootloader("/efi-store", false))
+	net := &VirtioNet{}
+	net.SetUnixSocketPath("/net.sock")
+	require.NoError(t, vm.AddDevice(net))
+
+	args, err := vm.ToCmdLine()
+	require.NoError(t, err)
+	assert.Contains(t, args, "virtio-net,unixSocketPath=/net.sock")
+
+	args, err = vm.ToCmdLineFor("v0.6.1")
+	require.NoError(t, err)
+	assert.Contains(t, args, "virtio-net,unixSocketPath=/net.sock")
+
+	args, err = vm.ToCmdLineFor("v0.6.2")
+	require.NoError(t, err)
+	assert.Contains(t, args, "virtio-net,type=unixgram,path=/net.sock")
+
+	net.VfkitMagic = false
+	args, err = vm.ToCmdLineFor("v0.6.2")
+	require.NoError(t, err)
+	assert.Contains(t, args, "virtio-net,type=unixgram,path=/net.sock,vfkitMagic=off")
+
+	_, err = vm.ToCmdLineFor("v0.6.1")
+	var featureErr *UnsupportedFeatureError
+	require.True(t, errors.As(err, &featureErr))
+	assert.Equal(t, &UnsupportedFeatureError{Feature: "disabling vfkitMagic", MinVersion: "v0.6.2", Version: "v0.6.1"}, featureErr)
+	require.EqualError(t, err, "disabling vfkitMagic requires vfkit v0.6.2 or newer, but the target version is v0.6.1")
+
+	_, err = vm.ToCmdLineFor("unknown")
+	require.EqualError(t, err, "invalid vfkit version: unknown")
+}
+
+func TestToCmdLineForNewOptions(t *testing.T) {
+	vm := NewVirtualMachine(1, 512, NewEFIBootloader("/efi-store", false))
+	rng := &VirtioRng{}
+	require.NoError(t, vm.AddDevice(rng))
+	fs := &VirtioFs{SharedDir: "/a,b"}
+	require.NoError(t, vm.AddDevice(fs))
+
+	_, err := vm.ToCmdLineFor("v0.6.2")
+	require.EqualError(t, err, "quoted option values requires vfkit "+nextReleaseVersion+" or newer, but the target version is v0.6.2")
+	args, err := vm.ToCmdLineFor(nextReleaseVersion)
+	require.NoError(t, err)
+	assert.Contains(t, args, `virtio-fs,sharedDir="/a,b"`)
+
+	fs.SharedDir = "/a"
+	vm.Ignition = &Ignition{ConfigPath: "/config,1.ign"}
+	_, err = vm.ToCmdLineFor("v0.6.2")
+	require.EqualError(t, err, "quoted option values requires vfkit "+nextReleaseVersion+" or newer, but the target version is v0.6.2")
+	vm.Ignition = nil
+	rng.SetDeviceID("rng0")
+	_, err = vm.ToCmdLineFor("v0.6.2")
+	require.EqualError(t, err, "the device 'id' option requires vfkit "+nextReleaseVersion+" or newer, but the target version is v0.6.2")
+	args, err = vm.ToCmdLineFor(nextReleaseVersion)
+	require.NoError(t, err)
+	assert.Contains(t, args, "virtio-rng,id=rng0")
+
+	disk, err := VirtioBlkNew("/disk.img")
+	require.NoError(t, err)
+	disk.Ephemeral = true
+	rng.SetDeviceID("")
+	require.NoError(t, vm.AddDevice(disk))
+	_, err = vm.ToCmdLineFor("v0.6.1")
+	require.EqualError(t, err, "the disk 'ephemeral' option requires vfkit "+nextReleaseVersion+" or newer, but the target version is v0.6.1")
+	args, err = vm.ToCmdLineFor(nextReleaseVersion)
+	require.NoError(t, err)
+	assert.Contains(t, args, "virtio-blk,path=/disk.img,ephemeral")
+}
+
+func TestCmdFor(t *testing.T) {
+	vfkitPath := filepath.Join(t.TempDir(), "vfkit")
+	err := os.WriteFile(vfkitPath, []byte("#!/bin/sh\necho 'vfkit version: v0.6.2'\n"), 0o755) // #nosec G306 -- test executable
+	require.NoError(t, err)
+
+	version, err := VfkitVersion(vfkitPath)
+	require.NoError(t, err)
+	assert.Equal(t, "v0.6.2", version)
+
+	vm := NewVirtualMachine(1, 512, NewEFIBootloader("/efi-store", false))
+	net := &VirtioNet{}
+	net.SetUnixSocketPath("/net.sock")
+	require.NoError(t, vm.AddDevice(net))
+
+	cmd, err := vm.CmdFor(vfkitPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, vfkitPath, cmd.Path)
+	assert.Contains(t, cmd.Args, "virtio-net,type=unixgram,path=/net.sock")
+
+	cmd, err = vm.CmdFor(vfkitPath, "v0.6.1")
+	require.NoError(t, err)
+	assert.Contains(t, cmd.Args, "virtio-net,unixSocketPath=/net.sock")
+
+	_, err = vm.CmdFor(filepath.Join(t.TempDir(), "missing"), "")
+	require.ErrorContains(t, err, "failed to get the version of")
+}
@@ -1,20 +1,57 @@
 package config
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"math"
 	"net"
 	"os"
+	"reflect"
 	"slices"
-	"strconv"
 	"strings"
 	"time"
+
+	"github.com/crc-org/vfkit/pkg/image"
 )
 
 // The VirtioDevice interface is an interface which is implemented by all virtio devices.
 type VirtioDevice VMComponent
 
+// identifiedDevice is implemented by the devices which can have an
+// identifier, such as all the devices embedding DeviceInfo.
+type identifiedDevice interface {
+	// DeviceID returns the identifier assigned to the device by the user, or
+	// an empty string if the device has no identifier.
+	DeviceID() string
+	SetDeviceID(id string)
+}
+
+// deviceID returns the identifier of dev, or an empty string if it has none or
+// if it does not support identifiers.
+func deviceID(dev VirtioDevice) string {
+	if dev, ok := dev.(identifiedDevice); ok {
+		return dev.DeviceID()
+	}
+	return ""
+}
+
+// DeviceInfo is embedded in all virtio devices, it holds the configuration
+// which is common to all devices.
+type DeviceInfo struct {
+	// ID is an optional identifier for the device. It must be unique among
+	// the devices of a virtual machine, and it can be used to find, replace or
+	// remove this device.
+	ID string `json:"id,omitempty" option:"id" help:"unique identifier of the device"`
+}
+
+func (info *DeviceInfo) DeviceID() string {
+	return info.ID
+}
+
+func (info *DeviceInfo) SetDeviceID(id string) {
+	info.ID = id
+}
+
 const (
 	// Possible values for VirtioInput.InputType
 	VirtioInputPointingDevice = "pointing"
@@ -27,22 +64,23 @@ const (
 	// Default VirtioGPU Resolution
 	defaultVirtioGPUResolutionWidth  = 800
 	defaultVirtioGPUResolutionHeight = 600
-	qcow2Header                      = "QFI\xfb"
 )
 
 // VirtioInput configures an input device, such as a keyboard or pointing device
 // (mouse) that the virtual machine can use
 type VirtioInput struct {
-	InputType string `json:"inputType"` // currently supports "pointing" and "keyboard" input types
+	DeviceInfo
+	InputType string `json:"inputType" option:"pointing|keyboard,required" help:"type of input device"` // currently supports "pointing" and "keyboard" input types
 }
 
 type VirtioGPUResolution struct {
-	Width  int `json:"width"`
-	Height int `json:"height"`
+	Width  int `json:"width" option:"width,min=1" help:"width of the display in pixels"`
+	Height int `json:"height" option:"height,min=1" help:"height of the display in pixels"`
 }
 
 // VirtioGPU configures a GPU device, such as the host computer's display
 type VirtioGPU struct {
+	DeviceInfo
 	UsesGUI bool `json:"usesGUI"`
 	VirtioGPUResolution
 }
@@ -50,46 +88,52 @@ type VirtioGPU struct {
 // VirtioVsock configures of a virtio-vsock device allowing 2-way communication
 // between the host and the virtual machine type
 type VirtioVsock struct {
+	DeviceInfo
 	// Port is the virtio-vsock port used for this device, see `man vsock` for more
 	// details.
-	Port uint32 `json:"port"`
+	Port uint32 `json:"port" option:"port,required" help:"vsock port"`
 	// SocketURL is the path to a unix socket on the host to use for the virtio-vsock communication with the guest.
-	SocketURL string `json:"socketURL"`
+	SocketURL string `json:"socketURL" option:"socketURL,required" help:"path to the unix socket on the host"`
 	// If true, vsock connections will have to be done from guest to host. If false, vsock connections will only be possible
 	// from host to guest
-	Listen bool `json:"listen,omitempty"`
+	Listen bool `json:"listen,omitempty" option:"listen|connect" help:"whether the host listens for connections from the guest, or connects to the guest"`
 }
 
 // VirtioBlk configures a disk device.
 type VirtioBlk struct {
+	DeviceInfo
 	DiskStorageConfig
-	DeviceIdentifier string `json:"deviceIdentifier,omitempty"`
+	DeviceIdentifier string `json:"deviceIdentifier,omitempty" option:"deviceId" help:"identifier of the disk in the guest"`
 }
 
 type DirectorySharingConfig struct {
-	MountTag string `json:"mountTag"`
+	MountTag string `json:"mountTag" option:"mountTag" help:"tag used to mount the share in the guest"`
 }
 
 // VirtioFs configures directory sharing between the guest and the host.
 type VirtioFs struct {
+	DeviceInfo
 	DirectorySharingConfig
-	SharedDir string `json:"sharedDir"`
+	SharedDir string `json:"sharedDir" option:"sharedDir,required" help:"path to the directory to share"`
 }
 
 // RosettaShare configures rosetta support in the guest to run Intel binaries on Apple CPUs
 type RosettaShare struct {
+	DeviceInfo
 	DirectorySharingConfig
-	InstallRosetta  bool `json:"installRosetta"`
-	IgnoreIfMissing bool `json:"ignoreIfMissing"`
+	InstallRosetta  bool `json:"installRosetta" option:"install,flag" help:"install rosetta if it's missing"`
+	IgnoreIfMissing bool `json:"ignoreIfMissing" option:"ignore-if-missing,flag" help:"do not fail if rosetta is not installed"`
 }
 
 // NVMExpressController configures a NVMe controller in the guest
 type NVMExpressController struct {
+	DeviceInfo
 	DiskStorageConfig
 }
 
 // VirtioRng configures a random number generator (RNG) device.
 type VirtioRng struct {
+	DeviceInfo
 }
 
 // TODO: Add BridgedNetwork support
@@ -97,21 +141,24 @@ type VirtioRng struct {
 
 // VirtioNet configures the virtual machine networking.
 type VirtioNet struct {
-	Nat        bool             `json:"nat"`
-	MacAddress net.HardwareAddr `json:"-"` // custom marshaller in json.go
+	DeviceInfo
+	Nat        bool             `json:"nat" option:"nat,flag,oneof=backend" help:"use NAT networking"`
+	MacAddress net.HardwareAddr `json:"-" option:"mac" help:"MAC address of the network interface"` // custom marshaller in json.go
 	// file parameter is holding a connected datagram socket.
 	// see https://github.com/Code-Hex/vz/blob/7f648b6fb9205d6f11792263d79876e3042c33ec/network.go#L113-L155
-	Socket *os.File `json:"socket,omitempty"`
+	Socket *os.File `json:"-" option:"fd,oneof=backend" help:"file descriptor of a connected datagram socket"` // custom marshaller in json.go
 
-	UnixSocketPath string `json:"unixSocketPath,omitempty"`
-	VfkitMagic     bool   `json:"vfkitMagic,omitempty"`
+	UnixSocketPath string `json:"unixSocketPath,omitempty" option:"unixSocketPath,oneof=backend" help:"path to a unixgram socket, same as 'type=unixgram,path=...'"`
+	// VfkitMagic is set with the 'vfkitMagic' option, see VirtioNet.FromOptions
+	VfkitMagic bool `json:"vfkitMagic,omitempty"`
 }
 
 // VirtioSerial configures the virtual machine serial ports.
 type VirtioSerial struct {
-	LogFile   string `json:"logFile,omitempty"`
-	UsesStdio bool   `json:"usesStdio,omitempty"`
-	UsesPty   bool   `json:"usesPty,omitempty"`
+	DeviceInfo
+	LogFile   string `json:"logFile,omitempty" option:"logFilePath,oneof=output" help:"path to the file where the serial port output is written"`
+	UsesStdio bool   `json:"usesStdio,omitempty" option:"stdio,flag,oneof=output" help:"connect the serial port to vfkit standard input/output"`
+	UsesPty   bool   `json:"usesPty,omitempty" option:"pty,flag,oneof=output" help:"connect the serial port to a pseudo-terminal"`
 	// PtyName must not be set when creating the VM, from a user perspective, it's read-only,
 	// vfkit will set it during VM startup.
 	PtyName string `json:"ptyName,omitempty"`
@@ -125,49 +172,166 @@ const (
 )
 
 type NetworkBlockDevice struct {
+	DeviceInfo
 	NetworkBlockStorageConfig
-	DeviceIdentifier    string
-	Timeout             time.Duration
-	SynchronizationMode NBDSynchronizationMode
+	DeviceIdentifier    string                 `option:"deviceId" help:"identifier of the disk in the guest"`
+	Timeout             time.Duration          `option:"timeout" help:"connection timeout"`
+	SynchronizationMode NBDSynchronizationMode `option:"sync" help:"synchronization mode for the disk"`
 }
 
-type VirtioBalloon struct{}
+type VirtioBalloon struct {
+	DeviceInfo
+}
 
 func VirtioBalloonNew() (VirtioDevice, error) {
 	return &VirtioBalloon{}, nil
 }
 
-func (v *VirtioBalloon) FromOptions(options []option) error {
-	if len(options) != 0 {
-		return fmt.Errorf("unknown options for virtio-balloon devices: %s", options)
+func (dev *VirtioBalloon) FromOptions(options []Option) error {
+	return DecodeDeviceOptions(dev, options)
+}
+
+func (dev *VirtioBalloon) ToCmdLine() ([]string, error) {
+	return EncodeDeviceOptions(dev)
+}
+
+// Option is a key=value option from a comma-separated command line argument
+// such as the one of --device. Value is empty for options without a value.
+type Option struct {
+	Key   string
+	Value string
+}
+
+// optionToken is an option from a comma-separated option string.
+type optionToken struct {
+	// str is the option with its quotes removed
+	str string
+	// start and end are the offsets of the option in the option string
+	start int
+	end   int
+}
+
+// splitOptions splits a comma-separated option string such as the argument of
+// --device. An option value, or a whole option, can be enclosed in double
+// quotes so that it can contain ',' characters: path="/disk,1.img". Inside
+// quotes, '\' escapes the next character, which makes it possible to use '"'
+// and '\' in quoted values. Outside quotes, '"' and '\' have no special meaning.
+func splitOptions(str string) ([]string, error) {
+	tokens, err := tokenizeOptions(str)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	opts := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		opts = append(opts, token.str)
+	}
+
+	return opts, nil
+}
+
+// tokenizeOptions splits str as described in splitOptions, and keeps track of
+// the position of each option in str.
+func tokenizeOptions(str string) ([]optionToken, error) {
+	tokens := []optionToken{}
+	var opt strings.Builder
+	start := 0
+	// quotes are only recognized at the start of an option or of its value
+	quoteAllowed := true
+	hasValue := false
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		switch {
+		case c == ',':
+			tokens = append(tokens, optionToken{str: opt.String(), start: start, end: i})
+			opt.Reset()
+			start = i + 1
+			quoteAllowed = true
+			hasValue = false
+		case c == '"' && quoteAllowed:
+			n, err := unquoteOption(str[i:], &opt)
+			if err != nil {
+				return nil, err
+			}
+			i += n
+			if i < len(str) && str[i] != ',' {
+				return nil, fmt.Errorf("unexpected character after closing quote in %s", str)
+			}
+			// the ',' is handled by the next iteration
+			i--
+			quoteAllowed = false
+		case c == '=' && !hasValue:
+			opt.WriteByte(c)
+			quoteAllowed = true
+			hasValue = true
+		default:
+			opt.WriteByte(c)
+			quoteAllowed = false
+		}
+	}
+	tokens = append(tokens, optionToken{str: opt.String(), start: start, end: len(str)})
+
+	return tokens, nil
 }
 
-func (v *VirtioBalloon) ToCmdLine() ([]string, error) {
-	return []string{"--device", "virtio-balloon"}, nil
+// unquoteOption writes the unescaped content of the double-quoted string at
+// the start of str to opt, and returns the length of the quoted string.
+func unquoteOption(str string, opt *strings.Builder) (int, error) {
+	for i := 1; i < len(str); i++ {
+		switch str[i] {
+		case '"':
+			return i + 1, nil
+		case '\\':
+			i++
+			if i == len(str) {
+				return 0, fmt.Errorf("missing closing quote in %s", str)
+			}
+		}
+		opt.WriteByte(str[i])
+	}
+	return 0, fmt.Errorf("missing closing quote in %s", str)
 }
 
-type option struct {
-	key   string
-	value string
+// unquoteSplitOption removes the quotes of opt, which is a single option from an
+// option string which was already split. opt is returned as is if it contains
+// unquoted ',' characters, as when the whole option was quoted and its quotes
+// were already removed.
+func unquoteSplitOption(opt string) (string, error) {
+	opts, err := splitOptions(opt)
+	if err != nil {
+		return "", err
+	}
+	if len(opts) != 1 {
+		return opt, nil
+	}
+	return opts[0], nil
 }
 
-func strToOption(str string) option {
+// quoteOptionValue returns value quoted and escaped as expected by
+// splitOptions if it cannot be used as is in a comma-separated option string.
+func quoteOptionValue(value string) string {
+	if !strings.ContainsAny(value, ",\"") {
+		return value
+	}
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
+
+func strToOption(str string) Option {
 	splitStr := strings.SplitN(str, "=", 2)
 
-	opt := option{
-		key: splitStr[0],
+	opt := Option{
+		Key: splitStr[0],
 	}
 	if len(splitStr) > 1 {
-		opt.value = splitStr[1]
+		opt.Value = splitStr[1]
 	}
 
 	return opt
 }
 
-func strvToOptions(opts []string) []option {
-	parsedOpts := []option{}
+func strvToOptions(opts []string) []Option {
+	parsedOpts := []Option{}
 	for _, opt := range opts {
 		if len(opt) == 0 {
 			continue
@@ -178,42 +342,24 @@ func strvToOptions(opts []string) []option {
 	return parsedOpts
 }
 
+// deviceTypeName returns the command line device type of dev
+func deviceTypeName(dev VirtioDevice) string {
+	if devType, ok := DeviceTypeOf(dev); ok {
+		return devType.Name
+	}
+	return reflect.TypeOf(dev).String()
+}
+
 func deviceFromCmdLine(deviceOpts string) (VirtioDevice, error) {
-	opts := strings.Split(deviceOpts, ",")
-	if len(opts) == 0 {
-		return nil, fmt.Errorf("empty option list in command line argument")
-	}
-	var dev VirtioDevice
-	switch opts[0] {
-	case "rosetta":
-		dev = &RosettaShare{}
-	case "nvme":
-		dev = nvmExpressControllerNewEmpty()
-	case "virtio-blk":
-		dev = virtioBlkNewEmpty()
-	case "virtio-fs":
-		dev = &VirtioFs{}
-	case "virtio-net":
-		dev = &VirtioNet{}
-	case "virtio-rng":
-		dev = &VirtioRng{}
-	case "virtio-serial":
-		dev = &VirtioSerial{}
-	case "virtio-vsock":
-		dev = &VirtioVsock{}
-	case "usb-mass-storage":
-		dev = usbMassStorageNewEmpty()
-	case "virtio-input":
-		dev = &VirtioInput{}
-	case "virtio-gpu":
-		dev = &VirtioGPU{}
-	case "virtio-balloon":
-		dev = &VirtioBalloon{}
-	case "nbd":
-		dev = networkBlockDeviceNewEmpty()
-	default:
-		return nil, fmt.Errorf("unknown device type: %s", opts[0])
+	opts, err := splitOptions(deviceOpts)
+	if err != nil {
+		return nil, err
 	}
+	devType, ok := LookupDeviceType(opts[0])
+	if !ok {
+		return nil, &UnknownDeviceError{DeviceType: opts[0]}
+	}
+	dev := devType.New()
 
 	parsedOpts := strvToOptions(opts[1:])
 	if err := dev.FromOptions(parsedOpts); err != nil {
@@ -244,57 +390,12 @@ func VirtioSerialNewPty() (VirtioDevice, error) {
 	}, nil
 }
 
-func (dev *VirtioSerial) validate() error {
-	if dev.LogFile != "" && dev.UsesStdio {
-		return fmt.Errorf("'logFilePath' and 'stdio' cannot be set at the same time")
-	}
-	if dev.LogFile != "" && dev.UsesPty {
-		return fmt.Errorf("'logFilePath' and 'pty' cannot be set at the same time")
-	}
-	if dev.UsesStdio && dev.UsesPty {
-		return fmt.Errorf("'stdio' and 'pty' cannot be set at the same time")
-	}
-	if dev.LogFile == "" && !dev.UsesStdio && !dev.UsesPty {
-		return fmt.Errorf("one of 'logFilePath', 'stdio' or 'pty' must be set")
-	}
-
-	return nil
-}
-
 func (dev *VirtioSerial) ToCmdLine() ([]string, error) {
-	if err := dev.validate(); err != nil {
-		return nil, err
-	}
-	switch {
-	case dev.UsesStdio:
-		return []string{"--device", "virtio-serial,stdio"}, nil
-	case dev.UsesPty:
-		return []string{"--device", "virtio-serial,pty"}, nil
-	case dev.LogFile != "":
-		fallthrough
-	default:
-		return []string{"--device", fmt.Sprintf("virtio-serial,logFilePath=%s", dev.LogFile)}, nil
-	}
+	return EncodeDeviceOptions(dev)
 }
 
-func (dev *VirtioSerial) FromOptions(options []option) error {
-	for _, option := range options {
-		switch option.key {
-		case "logFilePath":
-			dev.LogFile = option.value
-		case "stdio":
-			if option.value != "" {
-				return fmt.Errorf("unexpected value for virtio-serial 'stdio' option: %s", option.value)
-			}
-			dev.UsesStdio = true
-		case "pty":
-			dev.UsesPty = true
-		default:
-			return fmt.Errorf("unknown option for virtio-serial devices: %s", option.key)
-		}
-	}
-
-	return dev.validate()
+func (dev *VirtioSerial) FromOptions(options []Option) error {
+	return DecodeDeviceOptions(dev, options)
 }
 
 // VirtioInputNew creates a new input device for the virtual machine.
@@ -304,42 +405,19 @@ func VirtioInputNew(inputType string) (VirtioDevice, error) {
 	dev := &VirtioInput{
 		InputType: inputType,
 	}
-	if err := dev.validate(); err != nil {
+	if err := validateDevice(dev); err != nil {
 		return nil, err
 	}
 
 	return dev, nil
 }
 
-func (dev *VirtioInput) validate() error {
-	if dev.InputType != VirtioInputPointingDevice && dev.InputType != VirtioInputKeyboardDevice {
-		return fmt.Errorf("unknown option for virtio-input devices: %s", dev.InputType)
-	}
-
-	return nil
-}
-
 func (dev *VirtioInput) ToCmdLine() ([]string, error) {
-	if err := dev.validate(); err != nil {
-		return nil, err
-	}
-
-	return []string{"--device", fmt.Sprintf("virtio-input,%s", dev.InputType)}, nil
+	return EncodeDeviceOptions(dev)
 }
 
-func (dev *VirtioInput) FromOptions(options []option) error {
-	for _, option := range options {
-		switch option.key {
-		case VirtioInputPointingDevice, VirtioInputKeyboardDevice:
-			if option.value != "" {
-				return fmt.Errorf("unexpected value for virtio-input %s option: %s", option.key, option.value)
-			}
-			dev.InputType = option.key
-		default:
-			return fmt.Errorf("unknown option for virtio-input devices: %s", option.key)
-		}
-	}
-	return dev.validate()
+func (dev *VirtioInput) FromOptions(options []Option) error {
+	return DecodeDeviceOptions(dev, options)
 }
 
 // VirtioGPUNew creates a new gpu device for the virtual machine.
@@ -355,50 +433,12 @@ func VirtioGPUNew() (VirtioDevice, error) {
 	}, nil
 }
 
-func (dev *VirtioGPU) validate() error {
-	if dev.Height < 1 || dev.Width < 1 {
-		return fmt.Errorf("invalid dimensions for virtio-gpu device resolution: %dx%d", dev.Width, dev.Height)
-	}
-
-	return nil
-}
-
 func (dev *VirtioGPU) ToCmdLine() ([]string, error) {
-	if err := dev.validate(); err != nil {
-		return nil, err
-	}
-
-	return []string{"--device", fmt.Sprintf("virtio-gpu,width=%d,height=%d", dev.Width, dev.Height)}, nil
+	return EncodeDeviceOptions(dev)
 }
 
-func (dev *VirtioGPU) FromOptions(options []option) error {
-	for _, option := range options {
-		switch option.key {
-		case VirtioGPUResolutionHeight:
-			height, err := strconv.Atoi(option.value)
-			if err != nil || height < 1 {
-				return fmt.Errorf("invalid value for virtio-gpu %s: %s", option.key, option.value)
-			}
-
-			dev.Height = height
-		case VirtioGPUResolutionWidth:
-			width, err := strconv.Atoi(option.value)
-			if err != nil || width < 1 {
-				return fmt.Errorf("invalid value for virtio-gpu %s: %s", option.key, option.value)
-			}
-
-			dev.Width = width
-		default:
-			return fmt.Errorf("unknown option for virtio-gpu devices: %s", option.key)
-		}
-	}
-
-	if dev.Width == 0 && dev.Height == 0 {
-		dev.Width = defaultVirtioGPUResolutionWidth
-		dev.Height = defaultVirtioGPUResolutionHeight
-	}
-
-	return dev.validate()
+func (dev *VirtioGPU) FromOptions(options []Option) error {
+	return DecodeDeviceOptions(dev, options)
 }
 
 // VirtioNetNew creates a new network device for the virtual machine. It will
@@ -435,109 +475,91 @@ func (dev *VirtioNet) SetUnixSocketPath(path string) {
 	dev.VfkitMagic = true // Enable vfkit magic by default for unix sockets
 }
 
-func (dev *VirtioNet) validate() error {
-	if dev.Nat && dev.Socket != nil {
-		return fmt.Errorf("'nat' and 'fd' cannot be set at the same time")
-	}
-	if dev.Nat && dev.UnixSocketPath != "" {
-		return fmt.Errorf("'nat' and 'unixSocketPath' cannot be set at the same time")
+func (dev *VirtioNet) ToCmdLine() ([]string, error) {
+	if dev.UnixSocketPath == "" || dev.VfkitMagic {
+		// Use the old commandline syntax for backwards compatibility
+		// The pkg/config code is used by other projects as a go module to
+		// generate the command line to start vfkit. There is no guarantee
+		// that the `vfkit` binary these projects are using is the latest
+		// one with support for the new syntax.
+		// https://github.com/containers/podman/issues/27873
+		return EncodeDeviceOptions(dev)
 	}
-	if dev.Socket != nil && dev.UnixSocketPath != "" {
-		return fmt.Errorf("'fd' and 'unixSocketPath' cannot be set at the same time")
+
+	return dev.unixgramCmdLine()
+}
+
+func (dev *VirtioNet) toCmdLineFor(version string) ([]string, error) {
+	if dev.UnixSocketPath == "" {
+		return EncodeDeviceOptions(dev)
 	}
-	if !dev.Nat && dev.Socket == nil && dev.UnixSocketPath == "" {
-		return fmt.Errorf("one of 'nat' or 'fd' or 'unixSocketPath' must be set")
+	if !unixgramNetFeature.supportedBy(version) {
+		if !dev.VfkitMagic {
+			return nil, &UnsupportedFeatureError{Feature: "disabling vfkitMagic", MinVersion: unixgramNetFeature.minVersion, Version: version}
+		}
+		return EncodeDeviceOptions(dev)
 	}
 
-	return nil
+	return dev.unixgramCmdLine()
 }
 
-func (dev *VirtioNet) ToCmdLine() ([]string, error) {
-	if err := dev.validate(); err != nil {
+// unixgramCmdLine returns the command line of the device using the
+// 'type=unixgram,path=...' syntax.
+func (dev *VirtioNet) unixgramCmdLine() ([]string, error) {
+	if err := validateDeviceOptions(dev); err != nil {
 		return nil, err
 	}
-
-	builder := strings.Builder{}
-	builder.WriteString("virtio-net")
-	switch {
-	case dev.Nat:
-		builder.WriteString(",nat")
-	case dev.UnixSocketPath != "":
-		if dev.VfkitMagic {
-			// Use the old commandline syntax for backwards compatibility
-			// The pkg/config code is used by other projects as a go module to
-			// generate the command line to start vfkit. There is no guarantee
-			// that the `vfkit` binary these projects are using is the latest
-			// one with support for the new syntax.
-			// https://github.com/containers/podman/issues/27873
-			fmt.Fprintf(&builder, ",unixSocketPath=%s", dev.UnixSocketPath)
-		} else {
-			builder.WriteString(",type=unixgram")
-			fmt.Fprintf(&builder, ",path=%s", dev.UnixSocketPath)
-			builder.WriteString(",vfkitMagic=off")
-		}
-	default:
-		fmt.Fprintf(&builder, ",fd=%d", dev.Socket.Fd())
+	netDev := *dev
+	netDev.UnixSocketPath = ""
+	opts, err := optionsFromDevice(&netDev)
+	if err != nil {
+		return nil, err
 	}
-
-	if len(dev.MacAddress) != 0 {
-		fmt.Fprintf(&builder, ",mac=%s", dev.MacAddress)
+	unixgramOpts := []string{"virtio-net", "type=unixgram", "path=" + quoteOptionValue(dev.UnixSocketPath)}
+	if !dev.VfkitMagic {
+		unixgramOpts = append(unixgramOpts, "vfkitMagic=off")
 	}
+	opts = append(unixgramOpts, opts...)
 
-	return []string{"--device", builder.String()}, nil
+	return []string{"--device", strings.Join(opts, ",")}, nil
 }
 
-func (dev *VirtioNet) FromOptions(options []option) error {
+// FromOptions parses the virtio-net options. The 'type=unixgram,path=...'
+// syntax and the options which go with it are handled here, the other options
+// are described by the VirtioNet struct tags.
+func (dev *VirtioNet) FromOptions(options []Option) error {
 	var hasType bool
 	var typeOnlyOptions []string // Options that require type to be specified
 
-	if slices.ContainsFunc(options, func(opt option) bool {
-		return opt.key == "path" || opt.key == "unixSocketPath"
+	if slices.ContainsFunc(options, func(opt Option) bool {
+		return opt.Key == "path" || opt.Key == "unixSocketPath"
 	}) {
 		dev.VfkitMagic = true
 	}
 
+	taggedOpts := []Option{}
 	for _, option := range options {
-		switch option.key {
-		case "nat":
-			if option.value != "" {
-				return fmt.Errorf("unexpected value for virtio-net 'nat' option: %s", option.value)
-			}
-			dev.Nat = true
-		case "mac":
-			macAddress, err := net.ParseMAC(option.value)
-			if err != nil {
-				return err
-			}
-			dev.MacAddress = macAddress
-		case "fd":
-			fd, err := strconv.Atoi(option.value)
-			if err != nil {
-				return err
-			}
-			dev.Socket = os.NewFile(uintptr(fd), "vfkit virtio-net socket")
-		case "unixSocketPath":
-			dev.UnixSocketPath = option.value
+		switch option.Key {
 		case "type":
-			if option.value != "unixgram" {
-				return fmt.Errorf("unsupported virtio-net type: %s (only 'unixgram' is supported)", option.value)
+			if option.Value != "unixgram" {
+				return fmt.Errorf("unsupported virtio-net type: %s (only 'unixgram' is supported)", option.Value)
 			}
 			hasType = true
 		case "path":
-			dev.UnixSocketPath = option.value
-			typeOnlyOptions = append(typeOnlyOptions, option.key)
+			dev.UnixSocketPath = option.Value
+			typeOnlyOptions = append(typeOnlyOptions, option.Key)
 		case "vfkitMagic":
-			if option.value != "on" && option.value != "off" {
-				return fmt.Errorf("invalid value for vfkitMagic: %s (expected on/off)", option.value)
+			if option.Value != "on" && option.Value != "off" {
+				return fmt.Errorf("invalid value for vfkitMagic: %s (expected on/off)", option.Value)
 			}
-			dev.VfkitMagic = option.value == "on"
+			dev.VfkitMagic = option.Value == "on"
 		case "offloading":
-			if option.value != "off" {
-				return fmt.Errorf("invalid value for offloading: %s (only 'off' is supported)", option.value)
+			if option.Value != "off" {
+				return fmt.Errorf("invalid value for offloading: %s (only 'off' is supported)", option.Value)
 			}
-			typeOnlyOptions = append(typeOnlyOptions, option.key)
+			typeOnlyOptions = append(typeOnlyOptions, option.Key)
 		default:
-			return fmt.Errorf("unknown option for virtio-net devices: %s", option.key)
+			taggedOpts = append(taggedOpts, option)
 		}
 	}
 
@@ -550,7 +572,16 @@ func (dev *VirtioNet) FromOptions(options []option) error {
 		return fmt.Errorf("'%s' option requires 'type' to be specified", typeOnlyOptions[0])
 	}
 
-	return dev.validate()
+	return DecodeDeviceOptions(dev, taggedOpts)
+}
+
+func (dev *VirtioNet) extraOptions() []DeviceOption {
+	return []DeviceOption{
+		{Names: []string{"type"}, Placeholder: "unixgram", Values: []string{"unixgram"}, Help: "use a unixgram socket, 'path' must also be set"},
+		{Names: []string{"path"}, Placeholder: "<string>", ExclusiveWith: []string{"nat", "fd", "unixSocketPath"}, Help: "path to the unixgram socket"},
+		{Names: []string{"vfkitMagic"}, Placeholder: "on|off", Values: []string{"on", "off"}, Default: "on", Help: "send the vfkit magic value after connecting to the unixgram socket"},
+		{Names: []string{"offloading"}, Placeholder: "off", Values: []string{"off"}, Help: "disable offloading on the unixgram socket"},
+	}
 }
 
 // VirtioRngNew creates a new random number generator device to feed entropy
@@ -560,14 +591,11 @@ func VirtioRngNew() (VirtioDevice, error) {
 }
 
 func (dev *VirtioRng) ToCmdLine() ([]string, error) {
-	return []string{"--device", "virtio-rng"}, nil
+	return EncodeDeviceOptions(dev)
 }
 
-func (dev *VirtioRng) FromOptions(options []option) error {
-	if len(options) != 0 {
-		return fmt.Errorf("unknown options for virtio-rng devices: %s", options)
-	}
-	return nil
+func (dev *VirtioRng) FromOptions(options []Option) error {
+	return DecodeDeviceOptions(dev, options)
 }
 
 func nvmExpressControllerNewEmpty() *NVMExpressController {
@@ -589,6 +617,14 @@ func NVMExpressControllerNew(imagePath string) (*NVMExpressController, error) {
 	return r, nil
 }
 
+func (dev *NVMExpressController) FromOptions(options []Option) error {
+	return DecodeDeviceOptions(dev, options)
+}
+
+func (dev *NVMExpressController) ToCmdLine() ([]string, error) {
+	return EncodeDeviceOptions(dev)
+}
+
 func virtioBlkNewEmpty() *VirtioBlk {
 	return &VirtioBlk{
 		DiskStorageConfig: DiskStorageConfig{
@@ -613,53 +649,16 @@ func (dev *VirtioBlk) SetDeviceIdentifier(devID string) {
 	dev.DeviceIdentifier = devID
 }
 
-func (dev *VirtioBlk) FromOptions(options []option) error {
-	unhandledOpts := []option{}
-	for _, option := range options {
-		switch option.key {
-		case "deviceId":
-			dev.DeviceIdentifier = option.value
-		default:
-			unhandledOpts = append(unhandledOpts, option)
-		}
-	}
-
-	if err := dev.DiskStorageConfig.FromOptions(unhandledOpts); err != nil {
-		return err
-	}
-	return dev.validate()
+func (dev *VirtioBlk) FromOptions(options []Option) error {
+	return DecodeDeviceOptions(dev, options)
 }
 
 func (dev *VirtioBlk) ToCmdLine() ([]string, error) {
-	cmdLine, err := dev.DiskStorageConfig.ToCmdLine()
-	if err != nil {
-		return []string{}, err
-	}
-	if len(cmdLine) != 2 {
-		return []string{}, fmt.Errorf("unexpected storage config commandline")
-	}
-	if dev.DeviceIdentifier != "" {
-		cmdLine[1] = fmt.Sprintf("%s,deviceId=%s", cmdLine[1], dev.DeviceIdentifier)
-	}
-	return cmdLine, nil
+	return EncodeDeviceOptions(dev)
 }
 
 func (dev *VirtioBlk) validate() error {
-	imgPath := dev.ImagePath
-	file, err := os.Open(imgPath)
-	if err != nil {
-		return fmt.Errorf("failed to open file %s: %v", imgPath, err)
-	}
-	defer file.Close()
-	header := make([]byte, 4)
-	_, err = file.Read(header)
-	if err != nil {
-		return fmt.Errorf("failed to read the header of file %s: %v", imgPath, err)
-	}
-	if bytes.Equal(header, []byte(qcow2Header)) {
-		return fmt.Errorf("vfkit does not support qcow2 image format")
-	}
-	return nil
+	return dev.validateImage()
 }
 
 // VirtioVsockNew creates a new virtio-vsock device for 2-way communication
@@ -679,40 +678,11 @@ func VirtioVsockNew(port uint, socketURL string, listen bool) (VirtioDevice, err
 }
 
 func (dev *VirtioVsock) ToCmdLine() ([]string, error) {
-	if dev.Port == 0 || dev.SocketURL == "" {
-		return nil, fmt.Errorf("virtio-vsock needs both a port and a socket URL")
-	}
-	var listenStr string
-	if dev.Listen {
-		listenStr = "listen"
-	} else {
-		listenStr = "connect"
-	}
-	return []string{"--device", fmt.Sprintf("virtio-vsock,port=%d,socketURL=%s,%s", dev.Port, dev.SocketURL, listenStr)}, nil
+	return EncodeDeviceOptions(dev)
 }
 
-func (dev *VirtioVsock) FromOptions(options []option) error {
-	// default to listen for backwards compatibliity
-	dev.Listen = true
-	for _, option := range options {
-		switch option.key {
-		case "socketURL":
-			dev.SocketURL = option.value
-		case "port":
-			port, err := strconv.ParseUint(option.value, 10, 32)
-			if err != nil {
-				return err
-			}
-			dev.Port = uint32(port)
-		case "listen":
-			dev.Listen = true
-		case "connect":
-			dev.Listen = false
-		default:
-			return fmt.Errorf("unknown option for virtio-vsock devices: %s", option.key)
-		}
-	}
-	return nil
+func (dev *VirtioVsock) FromOptions(options []Option) error {
+	return DecodeDeviceOptions(dev, options)
 }
 
 // VirtioFsNew creates a new virtio-fs device for file sharing. It will share
@@ -728,28 +698,11 @@ func VirtioFsNew(sharedDir string, mountTag string) (VirtioDevice, error) {
 }
 
 func (dev *VirtioFs) ToCmdLine() ([]string, error) {
-	if dev.SharedDir == "" {
-		return nil, fmt.Errorf("virtio-fs needs the path to the directory to share")
-	}
-	if dev.MountTag != "" {
-		return []string{"--device", fmt.Sprintf("virtio-fs,sharedDir=%s,mountTag=%s", dev.SharedDir, dev.MountTag)}, nil
-	}
-
-	return []string{"--device", fmt.Sprintf("virtio-fs,sharedDir=%s", dev.SharedDir)}, nil
+	return EncodeDeviceOptions(dev)
 }
 
-func (dev *VirtioFs) FromOptions(options []option) error {
-	for _, option := range options {
-		switch option.key {
-		case "sharedDir":
-			dev.SharedDir = option.value
-		case "mountTag":
-			dev.MountTag = option.value
-		default:
-			return fmt.Errorf("unknown option for virtio-fs devices: %s", option.key)
-		}
-	}
-	return nil
+func (dev *VirtioFs) FromOptions(options []Option) error {
+	return DecodeDeviceOptions(dev, options)
 }
 
 // RosettaShareNew RosettaShare creates a new rosetta share for running x86_64 binaries on M1 machines.
@@ -764,37 +717,22 @@ func RosettaShareNew(mountTag string) (VirtioDevice, error) {
 	}, nil
 }
 
-func (dev *RosettaShare) ToCmdLine() ([]string, error) {
+func (dev *RosettaShare) validate() error {
 	if dev.MountTag == "" {
-		return nil, fmt.Errorf("rosetta shares require a mount tag to be specified")
+		return fmt.Errorf("rosetta shares require a mount tag to be specified")
 	}
-	builder := strings.Builder{}
-	builder.WriteString("rosetta")
-	fmt.Fprintf(&builder, ",mountTag=%s", dev.MountTag)
-	if dev.InstallRosetta {
-		builder.WriteString(",install")
-	}
-	if dev.IgnoreIfMissing {
-		builder.WriteString(",ignore-if-missing")
-	}
-
-	return []string{"--device", builder.String()}, nil
+	return nil
 }
 
-func (dev *RosettaShare) FromOptions(options []option) error {
-	for _, option := range options {
-		switch option.key {
-		case "mountTag":
-			dev.MountTag = option.value
-		case "install":
-			dev.InstallRosetta = true
-		case "ignore-if-missing":
-			dev.IgnoreIfMissing = true
-		default:
-			return fmt.Errorf("unknown option for rosetta share: %s", option.key)
-		}
+func (dev *RosettaShare) ToCmdLine() ([]string, error) {
+	if err := dev.validate(); err != nil {
+		return nil, err
 	}
-	return nil
+	return EncodeDeviceOptions(dev)
+}
+
+func (dev *RosettaShare) FromOptions(options []Option) error {
+	return DecodeDeviceOptions(dev, options)
 }
 
 func networkBlockDeviceNewEmpty() *NetworkBlockDevice {
@@ -824,58 +762,16 @@ func NetworkBlockDeviceNew(uri string, timeout uint32, synchronization NBDSynchr
 	return nbd, nil
 }
 
-func (nbd *NetworkBlockDevice) ToCmdLine() ([]string, error) {
-	cmdLine, err := nbd.NetworkBlockStorageConfig.ToCmdLine()
-	if err != nil {
-		return []string{}, err
-	}
-	if len(cmdLine) != 2 {
-		return []string{}, fmt.Errorf("unexpected storage config commandline")
-	}
-
-	if nbd.DeviceIdentifier != "" {
-		cmdLine[1] = fmt.Sprintf("%s,deviceId=%s", cmdLine[1], nbd.DeviceIdentifier)
-	}
-	if nbd.Timeout.Milliseconds() > 0 {
-		cmdLine[1] = fmt.Sprintf("%s,timeout=%d", cmdLine[1], nbd.Timeout.Milliseconds())
-	}
-	if nbd.SynchronizationMode == "none" || nbd.SynchronizationMode == "full" {
-		cmdLine[1] = fmt.Sprintf("%s,sync=%s", cmdLine[1], nbd.SynchronizationMode)
-	}
-
-	return cmdLine, nil
+func (dev *NetworkBlockDevice) ToCmdLine() ([]string, error) {
+	return EncodeDeviceOptions(dev)
 }
 
-func (nbd *NetworkBlockDevice) FromOptions(options []option) error {
-	unhandledOpts := []option{}
-	for _, option := range options {
-		switch option.key {
-		case "deviceId":
-			nbd.DeviceIdentifier = option.value
-		case "timeout":
-			timeoutMS, err := strconv.ParseInt(option.value, 10, 32)
-			if err != nil {
-				return err
-			}
-			nbd.Timeout = time.Duration(timeoutMS) * time.Millisecond
-		case "sync":
-			switch option.value {
-			case string(SynchronizationFullMode):
-				nbd.SynchronizationMode = SynchronizationFullMode
-			case string(SynchronizationNoneMode):
-				nbd.SynchronizationMode = SynchronizationNoneMode
-			default:
-				return fmt.Errorf("invalid sync mode: %s, must be 'full' or 'none'", option.value)
-			}
-		default:
-			unhandledOpts = append(unhandledOpts, option)
-		}
-	}
-
-	return nbd.NetworkBlockStorageConfig.FromOptions(unhandledOpts)
+func (dev *NetworkBlockDevice) FromOptions(options []Option) error {
+	return DecodeDeviceOptions(dev, options)
 }
 
 type USBMassStorage struct {
+	DeviceInfo
 	DiskStorageConfig
 }
 
@@ -902,10 +798,18 @@ func (dev *USBMassStorage) SetReadOnly(readOnly bool) {
 	dev.ReadOnly = readOnly
 }
 
+func (dev *USBMassStorage) FromOptions(options []Option) error {
+	return DecodeDeviceOptions(dev, options)
+}
+
+func (dev *USBMassStorage) ToCmdLine() ([]string, error) {
+	return EncodeDeviceOptions(dev)
+}
+
 // StorageConfig configures a disk device.
 type StorageConfig struct {
 	DevName  string `json:"devName"`
-	ReadOnly bool   `json:"readOnly,omitempty"`
+	ReadOnly bool   `json:"readOnly,omitempty" option:"readonly,flag" help:"make the disk read-only"`
 }
 
 type DiskBackendType string
@@ -932,81 +836,41 @@ func (typ DiskBackendType) IsValid() bool {
 
 type DiskStorageConfig struct {
 	StorageConfig
-	ImagePath string          `json:"imagePath,omitempty"`
-	Type      DiskBackendType `json:"type,omitempty"`
+	ImagePath string          `json:"imagePath,omitempty" option:"path,required" help:"path to the disk image or block device"`
+	Type      DiskBackendType `json:"type,omitempty" option:"type" help:"type of the disk backend"`
+	// Ephemeral makes the device use a temporary copy-on-write clone of
+	// ImagePath, all the guest writes are discarded when vfkit exits
+	Ephemeral bool `json:"ephemeral,omitempty" option:"ephemeral,flag" help:"discard the writes to the disk when vfkit exits"`
 }
 
-type NetworkBlockStorageConfig struct {
-	StorageConfig
-	URI string `json:"uri,omitempty"`
+func (config *DiskStorageConfig) ephemeral() bool {
+	return config.Ephemeral
 }
 
-func (config *DiskStorageConfig) ToCmdLine() ([]string, error) {
-	if config.ImagePath == "" {
-		return nil, fmt.Errorf("%s devices need the path to a disk image", config.DevName)
+// validateImage checks that the disk image can be used by the virtualization
+// framework, which only supports raw images. Block devices are not checked,
+// but they cannot be ephemeral.
+func (config *DiskStorageConfig) validateImage() error {
+	if config.Type == DiskBackendBlockDevice {
+		if config.Ephemeral {
+			return fmt.Errorf("%s block device %s cannot be ephemeral, only disk images can be", config.DevName, config.ImagePath)
+		}
+		return nil
 	}
-
-	value := fmt.Sprintf("%s,path=%s", config.DevName, config.ImagePath)
-
-	if config.Type != DiskBackendDefault {
-		value += fmt.Sprintf(",type=%s", string(config.Type))
+	info, err := image.Detect(config.ImagePath)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("failed to open file %s: %v", config.ImagePath, err)
 	}
-
-	if config.ReadOnly {
-		value += ",readonly"
+	if err != nil {
+		return err
 	}
-	return []string{"--device", value}, nil
-}
-
-func (config *DiskStorageConfig) FromOptions(options []option) error {
-	for _, option := range options {
-		switch option.key {
-		case "path":
-			config.ImagePath = option.value
-		case "type":
-			typ := DiskBackendType(option.value)
-			if !typ.IsValid() {
-				return fmt.Errorf("unexpected value for disk 'type' option: %s", option.value)
-			}
-			config.Type = typ
-		case "readonly":
-			if option.value != "" {
-				return fmt.Errorf("unexpected value for virtio-blk 'readonly' option: %s", option.value)
-			}
-			config.ReadOnly = true
-		default:
-			return fmt.Errorf("unknown option for %s devices: %s", config.DevName, option.key)
-		}
+	if !info.IsRaw() {
+		return &UnsupportedImageError{DevName: config.DevName, Path: config.ImagePath, Info: *info}
 	}
 	return nil
 }
 
-func (config *NetworkBlockStorageConfig) ToCmdLine() ([]string, error) {
-	if config.URI == "" {
-		return nil, fmt.Errorf("%s devices need the uri to a remote block device", config.DevName)
-	}
-
-	value := fmt.Sprintf("%s,uri=%s", config.DevName, config.URI)
-
-	if config.ReadOnly {
-		value += ",readonly"
-	}
-	return []string{"--device", value}, nil
-}
-
-func (config *NetworkBlockStorageConfig) FromOptions(options []option) error {
-	for _, option := range options {
-		switch option.key {
-		case "uri":
-			config.URI = option.value
-		case "readonly":
-			if option.value != "" {
-				return fmt.Errorf("unexpected value for virtio-blk 'readonly' option: %s", option.value)
-			}
-			config.ReadOnly = true
-		default:
-			return fmt.Errorf("unknown option for %s devices: %s", config.DevName, option.key)
-		}
-	}
-	return nil
+type NetworkBlockStorageConfig struct {
+	StorageConfig
+	URI string `json:"uri,omitempty" option:"uri,required" help:"URI of the NBD export"`
 }
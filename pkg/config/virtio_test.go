@@ -334,8 +334,8 @@ func TestVirtioDevices(t *testing.T) {
 		"NewVirtioGPUDevice": {
 			newDev: VirtioGPUNew,
 			expectedDev: &VirtioGPU{
-				false,
-				VirtioGPUResolution{Width: 800, Height: 600},
+				UsesGUI:             false,
+				VirtioGPUResolution: VirtioGPUResolution{Width: 800, Height: 600},
 			},
 			expectedCmdLine: []string{"--device", "virtio-gpu,width=800,height=600"},
 		},
@@ -349,8 +349,8 @@ func TestVirtioDevices(t *testing.T) {
 				return dev, nil
 			},
 			expectedDev: &VirtioGPU{
-				false,
-				VirtioGPUResolution{Width: 1920, Height: 1080},
+				UsesGUI:             false,
+				VirtioGPUResolution: VirtioGPUResolution{Width: 1920, Height: 1080},
 			},
 			expectedCmdLine: []string{"--device", "virtio-gpu,width=1920,height=1080"},
 		},
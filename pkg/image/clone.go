@@ -0,0 +1,107 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// errReflinkUnsupported is returned by reflink when the filesystem cannot
+// clone the file.
+var errReflinkUnsupported = errors.New("copy-on-write clones are not supported")
+
+// Clone creates a copy-on-write clone of the raw image at srcPath at dstPath,
+// which must not exist. The clone shares its data with srcPath until either
+// of them is modified, it can be used as the image of any disk device. When
+// the filesystem does not support copy-on-write clones, such as APFS
+// clonefile(2) or Linux reflinks, the image is copied without its holes.
+func Clone(srcPath, dstPath string) error {
+	info, err := Detect(srcPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsRaw() {
+		return fmt.Errorf("cloning %s is not supported, only raw images can be cloned", describeImage(info))
+	}
+	if err := cloneFile(srcPath, dstPath); err != nil {
+		return fmt.Errorf("failed to clone %s: %w", srcPath, err)
+	}
+	return nil
+}
+
+// cloneFile clones the file srcPath points to, golden images are often
+// symlinks and cloning the link would make writes to dstPath modify them.
+func cloneFile(srcPath, dstPath string) error {
+	srcPath, err := filepath.EvalSymlinks(srcPath)
+	if err != nil {
+		return err
+	}
+	err = reflink(srcPath, dstPath)
+	if !errors.Is(err, errReflinkUnsupported) {
+		return err
+	}
+	log.Debugf("cannot clone %s, copying it instead: %v", srcPath, err)
+	return copyFile(srcPath, dstPath)
+}
+
+// copyFile copies srcPath to dstPath, which must not exist, keeping the holes
+// of srcPath.
+func copyFile(srcPath, dstPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	err = copySparse(dst, src, stat.Size(), nil)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if removeErr := os.Remove(dstPath); removeErr != nil {
+			log.Warnf("failed to remove %s: %v", dstPath, removeErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// TemporaryClone creates a copy-on-write clone of the raw image at imagePath
+// in a new temporary directory. The directory is created next to the image,
+// or next to the file it links to, when possible, so that the clone shares
+// its data with the image. remove deletes the clone and its directory.
+func TemporaryClone(imagePath string) (clonePath string, remove func() error, err error) {
+	imagePath, err = filepath.EvalSymlinks(imagePath)
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(imagePath), ".vfkit-clone-")
+	if err != nil {
+		log.Debugf("cannot create the clone of %s next to it, using %s instead: %v", imagePath, os.TempDir(), err)
+		dir, err = os.MkdirTemp("", "vfkit-clone-")
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	remove = func() error {
+		return os.RemoveAll(dir)
+	}
+	clonePath = filepath.Join(dir, filepath.Base(imagePath))
+	if err := Clone(imagePath, clonePath); err != nil {
+		if removeErr := remove(); removeErr != nil {
+			log.Warnf("failed to remove %s: %v", dir, removeErr)
+		}
+		return "", nil, err
+	}
+	return clonePath, remove, nil
+}
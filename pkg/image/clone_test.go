@@ -0,0 +1,119 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClone(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "golden.raw")
+	data := expectedDisk(map[int]byte{0: 'a', 100: 'b'})
+	writeTestFile(t, srcPath, data)
+
+	dstPath := filepath.Join(dir, "clone.raw")
+	require.NoError(t, Clone(srcPath, dstPath))
+	cloned, err := os.ReadFile(dstPath)
+	require.NoError(t, err)
+	assert.Equal(t, data, cloned)
+
+	// the clone and the source image are independent
+	writeTestFile(t, dstPath, filled('c', testClusterSize))
+	data, err = os.ReadFile(srcPath)
+	require.NoError(t, err)
+	assert.Equal(t, expectedDisk(map[int]byte{0: 'a', 100: 'b'}), data)
+
+	require.ErrorIs(t, Clone(srcPath, dstPath), os.ErrExist)
+	qcow2Path := filepath.Join(dir, "disk.qcow2")
+	writeTestFile(t, qcow2Path, newQcow2Image(t, ""))
+	require.ErrorContains(t, Clone(qcow2Path, filepath.Join(dir, "qcow2.raw")), "cloning qcow2 images is not supported")
+}
+
+func TestCloneSymlink(t *testing.T) {
+	dir := t.TempDir()
+	goldenPath := filepath.Join(dir, "golden.raw")
+	writeTestFile(t, goldenPath, filled('a', testClusterSize))
+	linkPath := filepath.Join(dir, "link.raw")
+	require.NoError(t, os.Symlink(goldenPath, linkPath))
+
+	dstPath := filepath.Join(dir, "clone.raw")
+	require.NoError(t, Clone(linkPath, dstPath))
+	stat, err := os.Lstat(dstPath)
+	require.NoError(t, err)
+	assert.True(t, stat.Mode().IsRegular())
+	writeTestFile(t, dstPath, filled('b', testClusterSize))
+	data, err := os.ReadFile(goldenPath)
+	require.NoError(t, err)
+	assert.Equal(t, filled('a', testClusterSize), data)
+}
+
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "golden.raw")
+	writeTestFile(t, srcPath, expectedDisk(map[int]byte{0: 'a'}))
+
+	dstPath := filepath.Join(dir, "copy.raw")
+	require.NoError(t, copyFile(srcPath, dstPath))
+	data, err := os.ReadFile(dstPath)
+	require.NoError(t, err)
+	assert.Equal(t, expectedDisk(map[int]byte{0: 'a'}), data)
+	// the zeros of the source image are holes
+	assert.Less(t, allocatedSize(t, dstPath), int64(testDiskSize/2))
+}
+
+func TestTemporaryClone(t *testing.T) {
+	dir := t.TempDir()
+	imagePath := filepath.Join(dir, "golden.raw")
+	writeTestFile(t, imagePath, filled('a', testClusterSize))
+
+	clonePath, remove, err := TemporaryClone(imagePath)
+	require.NoError(t, err)
+	// the temporary directory may be a symlink, such as /var on macOS
+	realDir, err := filepath.EvalSymlinks(dir)
+	require.NoError(t, err)
+	assert.Equal(t, realDir, filepath.Dir(filepath.Dir(clonePath)))
+	writeTestFile(t, clonePath, filled('b', testClusterSize))
+	data, err := os.ReadFile(imagePath)
+	require.NoError(t, err)
+	assert.Equal(t, filled('a', testClusterSize), data)
+
+	require.NoError(t, remove())
+	assert.NoDirExists(t, filepath.Dir(clonePath))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	_, _, err = TemporaryClone(filepath.Join(dir, "missing.raw"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestTemporaryCloneSymlink(t *testing.T) {
+	goldenDir := t.TempDir()
+	goldenPath := filepath.Join(goldenDir, "golden.raw")
+	writeTestFile(t, goldenPath, filled('a', testClusterSize))
+	linkPath := filepath.Join(t.TempDir(), "disk.raw")
+	require.NoError(t, os.Symlink(goldenPath, linkPath))
+
+	clonePath, remove, err := TemporaryClone(linkPath)
+	require.NoError(t, err)
+	defer remove()
+	realGoldenDir, err := filepath.EvalSymlinks(goldenDir)
+	require.NoError(t, err)
+	assert.Equal(t, realGoldenDir, filepath.Dir(filepath.Dir(clonePath)))
+	stat, err := os.Lstat(clonePath)
+	require.NoError(t, err)
+	assert.True(t, stat.Mode().IsRegular())
+
+	// the guest writes to the ephemeral clone don't reach the golden image
+	writeTestFile(t, clonePath, filled('b', testClusterSize))
+	data, err := os.ReadFile(goldenPath)
+	require.NoError(t, err)
+	assert.Equal(t, filled('a', testClusterSize), data)
+}
@@ -0,0 +1,247 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containers/common/pkg/strongunits"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// copyBufferSize is the amount of data read from the source image at once
+	copyBufferSize = 1024 * 1024
+	// holeSize is the granularity of the holes created in the raw image
+	holeSize = 64 * 1024
+	// maxBackingChainLength protects against loops in qcow2 backing files
+	maxBackingChainLength = 16
+)
+
+// disk reads the data of a disk image as seen by the guest.
+type disk interface {
+	io.ReaderAt
+	io.Closer
+	// Size is the virtual size of the disk
+	Size() int64
+}
+
+// ConvertOptions configures Convert.
+type ConvertOptions struct {
+	// Progress is called regularly during the conversion with the amount
+	// of guest data which was converted, and the virtual size of the disk.
+	Progress func(converted, total strongunits.B)
+}
+
+// Convert converts the qcow2, VMDK or VHDX disk image at srcPath to a raw
+// image at dstPath, which must not exist. qcow2 backing files and compressed
+// clusters are supported. The unallocated and zeroed parts of the source image
+// are holes in the sparse raw image.
+func Convert(srcPath, dstPath string, opts ConvertOptions) error {
+	src, err := openDisk(srcPath, 0)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	err = copySparse(dst, src, src.Size(), opts.Progress)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if removeErr := os.Remove(dstPath); removeErr != nil {
+			log.Warnf("failed to remove %s: %v", dstPath, removeErr)
+		}
+		return fmt.Errorf("failed to convert %s: %w", srcPath, err)
+	}
+	return nil
+}
+
+// openDisk opens the disk image at path. depth is the number of images
+// referencing this image as their backing file.
+func openDisk(path string, depth int) (disk, error) {
+	if depth > maxBackingChainLength {
+		return nil, fmt.Errorf("too many backing files for %s", path)
+	}
+	info, err := Detect(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Compression != CompressionNone {
+		return nil, fmt.Errorf("%s is compressed with %s, it must be decompressed first", path, info.Compression)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var d disk
+	switch info.Format {
+	case FormatRaw, FormatISO:
+		d = &rawDisk{file: file, size: int64(info.VirtualSize)}
+	case FormatQcow2:
+		d, err = openQcow2(file, depth)
+	case FormatVMDK:
+		d, err = openVMDK(file, filepath.Dir(path))
+	case FormatVHDX:
+		d, err = openVHDX(file)
+	default:
+		err = fmt.Errorf("converting %s images is not supported", info.Format)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open %s image %s: %w", info.Format, path, err)
+	}
+	return d, nil
+}
+
+// rawDisk is a disk image which contains the guest data, starting at offset.
+type rawDisk struct {
+	file   *os.File
+	offset int64
+	size   int64
+}
+
+func (disk *rawDisk) Size() int64 {
+	return disk.size
+}
+
+func (disk *rawDisk) ReadAt(p []byte, off int64) (int, error) {
+	if off >= disk.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > disk.size-off {
+		n, err := disk.file.ReadAt(p[:disk.size-off], disk.offset+off)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	return disk.file.ReadAt(p, disk.offset+off)
+}
+
+func (disk *rawDisk) Close() error {
+	return disk.file.Close()
+}
+
+// zeroDisk is a disk which only contains zeros.
+type zeroDisk struct {
+	size int64
+}
+
+func (disk *zeroDisk) Size() int64 {
+	return disk.size
+}
+
+func (disk *zeroDisk) ReadAt(p []byte, off int64) (int, error) {
+	return readBlocks(p, off, disk.size, disk.size, func(p []byte, _ int64) error {
+		clear(p)
+		return nil
+	})
+}
+
+func (disk *zeroDisk) Close() error {
+	return nil
+}
+
+// concatDisk is a disk made of the data of several disks, such as the
+// extents of a VMDK image.
+type concatDisk struct {
+	parts []disk
+}
+
+func (disk *concatDisk) Size() int64 {
+	var size int64
+	for _, part := range disk.parts {
+		size += part.Size()
+	}
+	return size
+}
+
+func (disk *concatDisk) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+	start := int64(0)
+	for _, part := range disk.parts {
+		if len(p) == 0 {
+			return read, nil
+		}
+		end := start + part.Size()
+		if off < end {
+			n := min(int64(len(p)), end-off)
+			if _, err := part.ReadAt(p[:n], off-start); err != nil && !errors.Is(err, io.EOF) {
+				return read, err
+			}
+			read += int(n)
+			p = p[n:]
+			off += n
+		}
+		start = end
+	}
+	if len(p) > 0 {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+func (disk *concatDisk) Close() error {
+	var errs []error
+	for _, part := range disk.parts {
+		errs = append(errs, part.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// readBlocks implements io.ReaderAt for disks of size bytes split in blocks
+// of blockSize bytes. readBlock is called to fill p with the data at off,
+// p never crosses a block boundary.
+func readBlocks(p []byte, off int64, size int64, blockSize int64, readBlock func(p []byte, off int64) error) (int, error) {
+	read := 0
+	for len(p) > 0 {
+		if off >= size {
+			return read, io.EOF
+		}
+		n := min(int64(len(p)), blockSize-off%blockSize, size-off)
+		if err := readBlock(p[:n], off); err != nil {
+			return read, err
+		}
+		read += int(n)
+		p = p[n:]
+		off += n
+	}
+	return read, nil
+}
+
+// copySparse copies size bytes from src to dst, without writing the blocks
+// which only contain zeros, so that they are holes in dst.
+func copySparse(dst *os.File, src io.ReaderAt, size int64, progress func(converted, total strongunits.B)) error {
+	buf := make([]byte, copyBufferSize)
+	zeros := make([]byte, holeSize)
+	for off := int64(0); off < size; {
+		n := min(int64(len(buf)), size-off)
+		if _, err := src.ReadAt(buf[:n], off); err != nil && !(errors.Is(err, io.EOF) && off+n == size) {
+			return err
+		}
+		for i := int64(0); i < n; i += holeSize {
+			block := buf[i:min(i+holeSize, n)]
+			if bytes.Equal(block, zeros[:len(block)]) {
+				continue
+			}
+			if _, err := dst.WriteAt(block, off+i); err != nil {
+				return err
+			}
+		}
+		off += n
+		if progress != nil {
+			progress(strongunits.B(off), strongunits.B(size))
+		}
+	}
+	// the trailing holes are not created by WriteAt
+	return dst.Truncate(size)
+}
@@ -0,0 +1,352 @@
+package image
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/containers/common/pkg/strongunits"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testDiskSize    = 16 * 1024 * 1024
+	testClusterSize = 64 * 1024
+)
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	require.NoError(t, os.WriteFile(path, data, 0600))
+}
+
+func filled(c byte, size int) []byte {
+	return bytes.Repeat([]byte{c}, size)
+}
+
+// expectedDisk returns the content of a testDiskSize disk, with the
+// testClusterSize clusters given in clusters, and zeros everywhere else.
+func expectedDisk(clusters map[int]byte) []byte {
+	data := make([]byte, testDiskSize)
+	for cluster, c := range clusters {
+		copy(data[cluster*testClusterSize:], filled(c, testClusterSize))
+	}
+	return data
+}
+
+func deflate(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, flate.BestCompression)
+	require.NoError(t, err)
+	_, err = writer.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func zlibCompress(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	writer := zlib.NewWriter(&buf)
+	_, err := writer.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+// newQcow2Image creates a qcow2 v3 image with 64KiB clusters using
+// backingFile:
+// - guest cluster 0 is allocated and filled with 'a'
+// - guest cluster 1 is compressed and filled with 'b'
+// - guest cluster 2 is a zero cluster
+// - the other clusters are unallocated, their data is in the backing file
+func newQcow2Image(t *testing.T, backingFile string) testImage {
+	const (
+		l1Offset         = 1 * testClusterSize
+		l2Offset         = 2 * testClusterSize
+		dataOffset       = 3 * testClusterSize
+		compressedOffset = 4*testClusterSize + 512
+	)
+	compressed := deflate(t, filled('b', testClusterSize))
+	img := newTestImage(5*testClusterSize).
+		put(0, []byte(qcow2Magic)).
+		putUint32(qcow2VersionOffset, binary.BigEndian, 3).
+		putUint32(qcow2ClusterBitsOffset, binary.BigEndian, 16).
+		putUint64(qcow2SizeOffset, binary.BigEndian, testDiskSize).
+		putUint32(qcow2L1SizeOffset, binary.BigEndian, 1).
+		putUint64(qcow2L1TableOffset, binary.BigEndian, l1Offset).
+		putUint32(100, binary.BigEndian, 104).
+		putUint64(l1Offset, binary.BigEndian, l2Offset|1<<63).
+		putUint64(l2Offset, binary.BigEndian, dataOffset|1<<63).
+		putUint64(l2Offset+8, binary.BigEndian, qcow2CompressedFlag|uint64(len(compressed)/512)<<54|compressedOffset).
+		putUint64(l2Offset+16, binary.BigEndian, qcow2ZeroClusterFlag).
+		put(dataOffset, filled('a', testClusterSize)).
+		put(compressedOffset, compressed)
+	if backingFile != "" {
+		img = img.
+			putUint64(qcow2BackingFileOffset, binary.BigEndian, 512).
+			putUint32(qcow2BackingFileSizeOffset, binary.BigEndian, uint32(len(backingFile))).
+			put(512, []byte(backingFile))
+	}
+	return img
+}
+
+// newVMDKSparseImage creates a sparse VMDK image with 64KiB grains, grain 0
+// is filled with 'v', grain 2 with 'w' and grain 5 is a zeroed grain.
+// streamOptimized images are compressed, and their grain directory location
+// is in a footer.
+func newVMDKSparseImage(t *testing.T, streamOptimized bool) testImage {
+	const (
+		gdSector     = 1
+		gtSector     = 2
+		grainSectors = testClusterSize / sectorSize
+	)
+	header := newTestImage(vmdkHeaderSize).
+		put(0, []byte(vmdkMagic)).
+		putUint32(4, binary.LittleEndian, 1).
+		putUint64(vmdkCapacityOffset, binary.LittleEndian, testDiskSize/sectorSize).
+		putUint64(vmdkGrainSizeOffset, binary.LittleEndian, grainSectors).
+		putUint32(vmdkNumGTEsPerGTOffset, binary.LittleEndian, 512).
+		putUint64(vmdkGDOffsetOffset, binary.LittleEndian, gdSector)
+
+	grains := map[int][]byte{0: filled('v', testClusterSize), 2: filled('w', testClusterSize)}
+	img := newTestImage(16*testClusterSize).
+		putUint32(gdSector*sectorSize, binary.LittleEndian, gtSector).
+		putUint32(gtSector*sectorSize+5*4, binary.LittleEndian, 1)
+	sector := 8
+	for _, grain := range []int{0, 2} {
+		img.putUint32(gtSector*sectorSize+grain*4, binary.LittleEndian, uint32(sector))
+		if !streamOptimized {
+			img.put(sector*sectorSize, grains[grain])
+			sector += grainSectors
+			continue
+		}
+		compressed := zlibCompress(t, grains[grain])
+		img.putUint64(sector*sectorSize, binary.LittleEndian, uint64(grain*grainSectors)).
+			putUint32(sector*sectorSize+8, binary.LittleEndian, uint32(len(compressed))).
+			put(sector*sectorSize+vmdkMarkerSize, compressed)
+		sector += (vmdkMarkerSize+len(compressed))/sectorSize + 1
+	}
+
+	if streamOptimized {
+		header.putUint32(vmdkFlagsOffset, binary.LittleEndian, vmdkCompressedFlag|1<<17).
+			putUint16(vmdkCompressAlgorithmOffset, binary.LittleEndian, vmdkCompressionDeflate)
+		footer := append(testImage{}, header...)
+		header.putUint64(vmdkGDOffsetOffset, binary.LittleEndian, vmdkGDAtEnd)
+		// footer marker, footer and end-of-stream marker
+		img = append(img, make(testImage, sectorSize)...)
+		img = append(img, footer...)
+		img = append(img, make(testImage, sectorSize)...)
+	}
+	return img.put(0, header)
+}
+
+// newVHDXTestImage creates a dynamic VHDX image with 1MiB blocks, block 0 is
+// filled with 'x', block 1 with 'y', and block 3 is a zero block.
+func newVHDXTestImage() testImage {
+	const (
+		batOffset      = 1024 * 1024
+		metadataOffset = 2 * 1024 * 1024
+		blockSize      = 1024 * 1024
+	)
+	img := newTestImage(6*1024*1024).
+		put(0, []byte(vhdxMagic)).
+		put(vhdxHeader1Offset, []byte(vhdxHeaderMagic)).
+		putUint64(vhdxHeader1Offset+8, binary.LittleEndian, 1).
+		put(vhdxRegionTableOffset, []byte(vhdxRegionTableMagic)).
+		putUint32(vhdxRegionTableOffset+8, binary.LittleEndian, 2).
+		put(vhdxRegionTableOffset+16, vhdxBATRegionGUID).
+		putUint64(vhdxRegionTableOffset+32, binary.LittleEndian, batOffset).
+		putUint32(vhdxRegionTableOffset+40, binary.LittleEndian, 1024*1024).
+		put(vhdxRegionTableOffset+48, vhdxMetadataRegionGUID).
+		putUint64(vhdxRegionTableOffset+64, binary.LittleEndian, metadataOffset).
+		putUint32(vhdxRegionTableOffset+72, binary.LittleEndian, 1024*1024).
+		put(metadataOffset, []byte(vhdxMetadataTableMagic)).
+		putUint16(metadataOffset+10, binary.LittleEndian, 3)
+	for i, item := range []struct {
+		guid  []byte
+		value []byte
+	}{
+		{vhdxFileParametersGUID, binary.LittleEndian.AppendUint64(nil, blockSize)},
+		{vhdxVirtualSizeGUID, binary.LittleEndian.AppendUint64(nil, testDiskSize)},
+		{vhdxSectorSizeGUID, binary.LittleEndian.AppendUint32(nil, 512)},
+	} {
+		entryOffset := metadataOffset + 32 + i*32
+		itemOffset := 0x10000 + i*8
+		img.put(entryOffset, item.guid).
+			putUint32(entryOffset+16, binary.LittleEndian, uint32(itemOffset)).
+			put(metadataOffset+itemOffset, item.value)
+	}
+	return img.
+		putUint64(batOffset, binary.LittleEndian, 4<<20|vhdxBlockFullyPresent).
+		putUint64(batOffset+8, binary.LittleEndian, 5<<20|vhdxBlockFullyPresent).
+		putUint64(batOffset+3*8, binary.LittleEndian, vhdxBlockZero).
+		put(4*1024*1024, filled('x', blockSize)).
+		put(5*1024*1024, filled('y', blockSize))
+}
+
+const vmdkTestDescriptor = `# Disk DescriptorFile
+version=1
+CID=fffffffe
+parentCID=ffffffff
+createType="twoGbMaxExtentSparse"
+
+# Extent description
+RW 256 FLAT "disk-f001.vmdk" 128
+RW 128 ZERO
+RW 32768 SPARSE "disk-s002.vmdk"
+`
+
+// allocatedSize returns the disk space used by the file at path.
+func allocatedSize(t *testing.T, path string) int64 {
+	var stat syscall.Stat_t
+	require.NoError(t, syscall.Stat(path, &stat))
+	return int64(stat.Blocks) * 512
+}
+
+func TestConvert(t *testing.T) {
+	vhdxExpected := expectedDisk(nil)
+	copy(vhdxExpected, filled('x', 1024*1024))
+	copy(vhdxExpected[1024*1024:], filled('y', 1024*1024))
+
+	var tests = map[string]struct {
+		createImage func(t *testing.T, dir string) string
+		expected    []byte
+	}{
+		"qcow2": {
+			createImage: func(t *testing.T, dir string) string {
+				writeTestFile(t, filepath.Join(dir, "backing.raw"), filled('z', 4*testClusterSize))
+				path := filepath.Join(dir, "disk.qcow2")
+				writeTestFile(t, path, newQcow2Image(t, "backing.raw"))
+				return path
+			},
+			// the zero cluster hides the backing file data
+			expected: expectedDisk(map[int]byte{0: 'a', 1: 'b', 3: 'z'}),
+		},
+		"qcow2 backing chain": {
+			createImage: func(t *testing.T, dir string) string {
+				writeTestFile(t, filepath.Join(dir, "backing.raw"), filled('z', 8*testClusterSize))
+				writeTestFile(t, filepath.Join(dir, "backing.qcow2"), newQcow2Image(t, filepath.Join(dir, "backing.raw")))
+				path := filepath.Join(dir, "disk.qcow2")
+				writeTestFile(t, path, newQcow2Image(t, "backing.qcow2"))
+				return path
+			},
+			expected: expectedDisk(map[int]byte{0: 'a', 1: 'b', 3: 'z', 4: 'z', 5: 'z', 6: 'z', 7: 'z'}),
+		},
+		"qcow2 without backing file": {
+			createImage: func(t *testing.T, dir string) string {
+				path := filepath.Join(dir, "disk.qcow2")
+				writeTestFile(t, path, newQcow2Image(t, ""))
+				return path
+			},
+			expected: expectedDisk(map[int]byte{0: 'a', 1: 'b'}),
+		},
+		"vmdk monolithicSparse": {
+			createImage: func(t *testing.T, dir string) string {
+				path := filepath.Join(dir, "disk.vmdk")
+				writeTestFile(t, path, newVMDKSparseImage(t, false))
+				return path
+			},
+			expected: expectedDisk(map[int]byte{0: 'v', 2: 'w'}),
+		},
+		"vmdk streamOptimized": {
+			createImage: func(t *testing.T, dir string) string {
+				path := filepath.Join(dir, "disk.vmdk")
+				writeTestFile(t, path, newVMDKSparseImage(t, true))
+				return path
+			},
+			expected: expectedDisk(map[int]byte{0: 'v', 2: 'w'}),
+		},
+		"vmdk descriptor": {
+			createImage: func(t *testing.T, dir string) string {
+				flat := append(filled('e', testClusterSize), filled('f', 2*testClusterSize)...)
+				writeTestFile(t, filepath.Join(dir, "disk-f001.vmdk"), flat)
+				writeTestFile(t, filepath.Join(dir, "disk-s002.vmdk"), newVMDKSparseImage(t, false))
+				path := filepath.Join(dir, "disk.vmdk")
+				writeTestFile(t, path, []byte(vmdkTestDescriptor))
+				return path
+			},
+			expected: append(append(filled('f', 2*testClusterSize), make([]byte, testClusterSize)...),
+				expectedDisk(map[int]byte{0: 'v', 2: 'w'})...),
+		},
+		"vhdx": {
+			createImage: func(t *testing.T, dir string) string {
+				path := filepath.Join(dir, "disk.vhdx")
+				writeTestFile(t, path, newVHDXTestImage())
+				return path
+			},
+			expected: vhdxExpected,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			srcPath := test.createImage(t, dir)
+			dstPath := filepath.Join(dir, "disk.raw")
+
+			var lastConverted, lastTotal strongunits.B
+			err := Convert(srcPath, dstPath, ConvertOptions{
+				Progress: func(converted, total strongunits.B) {
+					assert.Greater(t, converted, lastConverted)
+					lastConverted, lastTotal = converted, total
+				},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, strongunits.B(len(test.expected)), lastConverted)
+			assert.Equal(t, strongunits.B(len(test.expected)), lastTotal)
+
+			data, err := os.ReadFile(dstPath)
+			require.NoError(t, err)
+			require.Len(t, data, len(test.expected))
+			require.True(t, bytes.Equal(test.expected, data))
+			// at most 2MiB of guest data are not zeros
+			assert.LessOrEqual(t, allocatedSize(t, dstPath), int64(4*1024*1024))
+
+			info, err := Detect(dstPath)
+			require.NoError(t, err)
+			assert.True(t, info.IsRaw())
+		})
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	dir := t.TempDir()
+	convert := func(name string, data []byte) error {
+		srcPath := filepath.Join(dir, name)
+		writeTestFile(t, srcPath, data)
+		dstPath := filepath.Join(dir, name+".raw")
+		err := Convert(srcPath, dstPath, ConvertOptions{})
+		if err != nil {
+			// no partial output is left behind
+			assert.NoFileExists(t, dstPath)
+		}
+		return err
+	}
+
+	require.ErrorContains(t, convert("missing-backing.qcow2", newQcow2Image(t, "missing.raw")), "failed to open backing file")
+	require.ErrorContains(t, convert("encrypted.qcow2", newQcow2Image(t, "").putUint32(qcow2CryptMethodOffset, binary.BigEndian, 1)),
+		"encrypted qcow2 images are not supported")
+	require.ErrorContains(t, convert("loop.qcow2", newQcow2Image(t, "loop.qcow2")), "too many backing files")
+	require.ErrorContains(t, convert("log.vhdx", newVHDXTestImage().put(vhdxHeader1Offset+48, filled(1, 16))), "pending log")
+	require.ErrorContains(t, convert("delta.vmdk", []byte(strings.Replace(vmdkTestDescriptor, "parentCID=ffffffff", "parentCID=12345678", 1))),
+		"VMDK delta links are not supported")
+	writeTestFile(t, filepath.Join(dir, "disk-f001.vmdk"), make([]byte, 3*testClusterSize))
+	writeTestFile(t, filepath.Join(dir, "disk-s002.vmdk"), newVMDKSparseImage(t, false))
+	require.ErrorContains(t, convert("size.vmdk", []byte(strings.Replace(vmdkTestDescriptor, "32768", "32000", 1))), "does not match the descriptor")
+	require.ErrorContains(t, convert("disk.vdi", newTestImage(1024).put(vdiMagicOffset, []byte(vdiMagic))), "converting vdi images is not supported")
+	require.ErrorContains(t, convert("disk.img.xz", []byte(xzMagic)), "it must be decompressed first")
+
+	// the output is not overwritten
+	writeTestFile(t, filepath.Join(dir, "disk.qcow2"), newQcow2Image(t, ""))
+	writeTestFile(t, filepath.Join(dir, "existing.raw"), []byte("data"))
+	err := Convert(filepath.Join(dir, "disk.qcow2"), filepath.Join(dir, "existing.raw"), ConvertOptions{})
+	require.ErrorIs(t, err, os.ErrExist)
+	data, err := os.ReadFile(filepath.Join(dir, "existing.raw"))
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+}
@@ -0,0 +1,108 @@
+package image
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/containers/common/pkg/strongunits"
+	log "github.com/sirupsen/logrus"
+)
+
+// ResizeOptions configures Resize.
+type ResizeOptions struct {
+	// Shrink allows making the image smaller, which discards the data at
+	// the end of the disk.
+	Shrink bool
+}
+
+func checkImageSize(size strongunits.B) error {
+	if size == 0 || size%sectorSize != 0 {
+		return fmt.Errorf("invalid disk image size %d, it must be a non-zero multiple of %d bytes", size, sectorSize)
+	}
+	return nil
+}
+
+// Create creates an empty raw disk image of size bytes at path, which must
+// not exist. The image is a sparse file, it only uses disk space once the
+// guest writes data to it.
+func Create(path string, size strongunits.B) error {
+	if err := checkImageSize(size); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	err = file.Truncate(int64(size))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if removeErr := os.Remove(path); removeErr != nil {
+			log.Warnf("failed to remove %s: %v", path, removeErr)
+		}
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	return nil
+}
+
+// Resize changes the size of the raw disk image at path to size bytes. The
+// added space is a hole in the sparse file. When the image has a GUID
+// partition table, its backup header and partition entries are moved to the
+// new end of the disk, so that the guest sees a valid partition table.
+// Shrinking the image must be allowed with opts.Shrink, and fails if it would
+// truncate a partition.
+func Resize(path string, size strongunits.B, opts ResizeOptions) error {
+	if err := checkImageSize(size); err != nil {
+		return err
+	}
+	info, err := Detect(path)
+	if err != nil {
+		return err
+	}
+	if info.Format != FormatRaw || info.Compression != CompressionNone {
+		return fmt.Errorf("resizing %s is not supported, only raw images can be resized", describeImage(info))
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	oldSize := int64(info.VirtualSize)
+	newSize := int64(size)
+	if newSize == oldSize {
+		return nil
+	}
+	if newSize < oldSize && !opts.Shrink {
+		return fmt.Errorf("%s is larger than the requested size, shrinking it must be explicitly allowed", path)
+	}
+
+	table, err := readGPT(file)
+	if err != nil {
+		return fmt.Errorf("failed to read the partition table of %s: %w", path, err)
+	}
+	if table != nil && newSize < oldSize {
+		if err := table.checkPartitionsFit(newSize); err != nil {
+			return fmt.Errorf("cannot shrink %s: %w", path, err)
+		}
+	}
+	if err := file.Truncate(newSize); err != nil {
+		return fmt.Errorf("failed to resize %s: %w", path, err)
+	}
+	if table != nil {
+		if err := table.relocate(file, oldSize, newSize); err != nil {
+			return fmt.Errorf("failed to update the partition table of %s: %w", path, err)
+		}
+	}
+	return file.Close()
+}
+
+// describeImage returns a short description of the image format, such as
+// 'qcow2 images' or 'gzip compressed images'.
+func describeImage(info *Info) string {
+	if info.Compression != CompressionNone {
+		return fmt.Sprintf("%s compressed images", info.Compression)
+	}
+	return fmt.Sprintf("%s images", info.Format)
+}
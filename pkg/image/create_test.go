@@ -0,0 +1,160 @@
+package image
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/common/pkg/strongunits"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testGPTEntries        = 128
+	testGPTEntriesSectors = testGPTEntries * gptMinEntrySize / sectorSize
+)
+
+// putGPTHeader writes a GPT header at lba, with its partition entries at
+// entriesLBA.
+func (img testImage) putGPTHeader(lba, alternateLBA, entriesLBA uint64, entries []byte) testImage {
+	disk := uint64(len(img) / sectorSize)
+	off := int(lba * sectorSize)
+	img.put(off, []byte(gptMagic)).
+		putUint32(off+8, binary.LittleEndian, 0x10000).
+		putUint32(off+gptHeaderSizeOffset, binary.LittleEndian, gptMinHeaderSize).
+		putUint64(off+gptMyLBAOffset, binary.LittleEndian, lba).
+		putUint64(off+gptAlternateLBAOffset, binary.LittleEndian, alternateLBA).
+		putUint64(off+gptFirstUsableLBAOffset, binary.LittleEndian, 2+testGPTEntriesSectors).
+		putUint64(off+gptLastUsableLBAOffset, binary.LittleEndian, disk-testGPTEntriesSectors-2).
+		putUint64(off+gptEntriesLBAOffset, binary.LittleEndian, entriesLBA).
+		putUint32(off+gptEntriesCountOffset, binary.LittleEndian, testGPTEntries).
+		putUint32(off+gptEntrySizeOffset, binary.LittleEndian, gptMinEntrySize).
+		putUint32(off+gptEntriesCRCOffset, binary.LittleEndian, crc32.ChecksumIEEE(entries)).
+		put(int(entriesLBA*sectorSize), entries)
+	header := img[off : off+gptMinHeaderSize]
+	return img.putUint32(off+gptHeaderCRCOffset, binary.LittleEndian, gptChecksum(header, gptHeaderCRCOffset))
+}
+
+// newGPTImage creates a raw image of size bytes, with a protective MBR and a
+// GPT describing a single partition from sector 34 to partitionEnd.
+func newGPTImage(size int, partitionEnd uint64) testImage {
+	entries := make([]byte, testGPTEntries*gptMinEntrySize)
+	copy(entries, filled(0xaa, 16))
+	binary.LittleEndian.PutUint64(entries[32:], 2+testGPTEntriesSectors)
+	binary.LittleEndian.PutUint64(entries[gptEntryEndingLBAOffset:], partitionEnd)
+
+	lastLBA := uint64(size/sectorSize - 1)
+	return newTestImage(size).
+		put(mbrPartitionTableOffset+mbrPartitionTypeOffset, []byte{mbrProtectiveType}).
+		putUint32(mbrPartitionTableOffset+8, binary.LittleEndian, 1).
+		putUint32(mbrPartitionTableOffset+mbrPartitionSizeOffset, binary.LittleEndian, uint32(lastLBA)).
+		putGPTHeader(1, lastLBA, 2, entries).
+		putGPTHeader(lastLBA, 1, lastLBA-testGPTEntriesSectors, entries)
+}
+
+// checkGPT verifies that the GPT of the raw image at path describes a disk of
+// size bytes, and that its backup header is at the end of the disk.
+func checkGPT(t *testing.T, path string, size int64) {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	table, err := readGPT(file)
+	require.NoError(t, err)
+	require.NotNil(t, table)
+
+	lastLBA := uint64(size/sectorSize - 1)
+	assert.Equal(t, lastLBA, table.uint64(gptAlternateLBAOffset))
+	assert.Equal(t, lastLBA-testGPTEntriesSectors-1, table.uint64(gptLastUsableLBAOffset))
+
+	backup, err := readAt(file, int64(lastLBA)*sectorSize, gptMinHeaderSize)
+	require.NoError(t, err)
+	require.Equal(t, gptMagic, string(backup[:8]))
+	assert.Equal(t, binary.LittleEndian.Uint32(backup[gptHeaderCRCOffset:]), gptChecksum(backup, gptHeaderCRCOffset))
+	assert.Equal(t, lastLBA, binary.LittleEndian.Uint64(backup[gptMyLBAOffset:]))
+	assert.Equal(t, uint64(1), binary.LittleEndian.Uint64(backup[gptAlternateLBAOffset:]))
+	backupEntriesLBA := binary.LittleEndian.Uint64(backup[gptEntriesLBAOffset:])
+	assert.Equal(t, lastLBA-testGPTEntriesSectors, backupEntriesLBA)
+	backupEntries, err := readAt(file, int64(backupEntriesLBA)*sectorSize, len(table.entries))
+	require.NoError(t, err)
+	assert.Equal(t, table.entries, backupEntries)
+
+	mbrSize, err := readUint32(file, mbrPartitionTableOffset+mbrPartitionSizeOffset, binary.LittleEndian)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(lastLBA), mbrSize)
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "disk.raw")
+	require.NoError(t, Create(path, strongunits.GiB(20).ToBytes()))
+
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20*1024*1024*1024), stat.Size())
+	assert.Less(t, allocatedSize(t, path), int64(1024*1024))
+
+	require.ErrorIs(t, Create(path, strongunits.GiB(1).ToBytes()), os.ErrExist)
+	require.ErrorContains(t, Create(filepath.Join(dir, "odd.raw"), strongunits.B(1000)), "must be a non-zero multiple of 512 bytes")
+	assert.NoFileExists(t, filepath.Join(dir, "odd.raw"))
+}
+
+func TestResize(t *testing.T) {
+	const (
+		oldSize = 1024 * 1024
+		newSize = 4 * 1024 * 1024
+	)
+	dir := t.TempDir()
+
+	t.Run("grow GPT", func(t *testing.T) {
+		path := filepath.Join(dir, "gpt.raw")
+		writeTestFile(t, path, newGPTImage(oldSize, oldSize/sectorSize-testGPTEntriesSectors-2))
+		require.NoError(t, Resize(path, strongunits.B(newSize), ResizeOptions{}))
+		checkGPT(t, path, newSize)
+
+		// the old backup header is erased
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Len(t, data, newSize)
+		assert.True(t, isZero(data[oldSize-(testGPTEntriesSectors+1)*sectorSize:oldSize]))
+	})
+
+	t.Run("shrink GPT", func(t *testing.T) {
+		path := filepath.Join(dir, "shrink.raw")
+		writeTestFile(t, path, newGPTImage(newSize, 1000))
+		require.ErrorContains(t, Resize(path, strongunits.B(oldSize), ResizeOptions{}), "shrinking it must be explicitly allowed")
+		require.NoError(t, Resize(path, strongunits.B(oldSize), ResizeOptions{Shrink: true}))
+		checkGPT(t, path, oldSize)
+
+		require.ErrorContains(t, Resize(path, strongunits.B(256*1024), ResizeOptions{Shrink: true}), "partition 1 ends at 512512 bytes")
+		stat, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, int64(oldSize), stat.Size())
+	})
+
+	t.Run("without partition table", func(t *testing.T) {
+		path := filepath.Join(dir, "empty.raw")
+		require.NoError(t, Create(path, strongunits.B(oldSize)))
+		require.NoError(t, Resize(path, strongunits.B(newSize), ResizeOptions{}))
+		stat, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, int64(newSize), stat.Size())
+		assert.Less(t, allocatedSize(t, path), int64(oldSize))
+	})
+
+	t.Run("corrupt GPT", func(t *testing.T) {
+		path := filepath.Join(dir, "corrupt.raw")
+		img := newGPTImage(oldSize, 1000)
+		img[2*sectorSize] ^= 0xff
+		writeTestFile(t, path, img)
+		require.ErrorContains(t, Resize(path, strongunits.B(newSize), ResizeOptions{}), "invalid GPT partition entries checksum")
+	})
+
+	t.Run("qcow2", func(t *testing.T) {
+		path := filepath.Join(dir, "disk.qcow2")
+		writeTestFile(t, path, newQcow2Image(t, ""))
+		require.ErrorContains(t, Resize(path, strongunits.B(newSize), ResizeOptions{}), "resizing qcow2 images is not supported")
+	})
+}
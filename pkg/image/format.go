@@ -0,0 +1,228 @@
+// Package image inspects the disk images used by the vfkit storage devices.
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containers/common/pkg/strongunits"
+)
+
+// Format is the format of a disk image.
+type Format string
+
+const (
+	// FormatUnknown is used for compressed images, whose format can only be
+	// found after decompressing them
+	FormatUnknown Format = "unknown"
+	FormatRaw     Format = "raw"
+	FormatISO     Format = "iso"
+	FormatQcow2   Format = "qcow2"
+	FormatVMDK    Format = "vmdk"
+	FormatVHDX    Format = "vhdx"
+	FormatVDI     Format = "vdi"
+	FormatDMG     Format = "dmg"
+)
+
+// Compression is the compression algorithm of a compressed disk image.
+type Compression string
+
+const (
+	CompressionNone  Compression = ""
+	CompressionGzip  Compression = "gzip"
+	CompressionXz    Compression = "xz"
+	CompressionZstd  Compression = "zstd"
+	CompressionBzip2 Compression = "bzip2"
+)
+
+// Info describes a disk image.
+type Info struct {
+	Format Format `json:"format"`
+	// VirtualSize is the size of the disk seen by the guest. It's 0 when
+	// it cannot be found from the image headers, for example for
+	// compressed images.
+	VirtualSize strongunits.B `json:"virtualSize"`
+	// Compression is empty for images which are not compressed.
+	Compression Compression `json:"compression,omitempty"`
+}
+
+// IsRaw returns true if the disk image contains the raw disk data, which is
+// the only kind of disk image the virtualization framework can use.
+func (info *Info) IsRaw() bool {
+	return info.Compression == CompressionNone && (info.Format == FormatRaw || info.Format == FormatISO)
+}
+
+const (
+	sectorSize = 512
+
+	// qcow2 header, big endian
+	qcow2Magic      = "QFI\xfb"
+	qcow2SizeOffset = 24
+	// VMDK sparse extent header, and text descriptor used by flat and
+	// split images
+	vmdkMagic           = "KDMV"
+	vmdkDescriptorMagic = "# Disk DescriptorFile"
+	// VDI pre-header and header, little endian
+	vdiMagicOffset   = 0x40
+	vdiMagic         = "\x7f\x10\xda\xbe"
+	vdiVersionOffset = 0x44
+	vdiSizeOffset    = 0x170
+	// VHDX file type identifier
+	vhdxMagic = "vhdxfile"
+	// ISO 9660 primary volume descriptor
+	isoDescriptorOffset = 16 * 2048
+	isoMagic            = "\x01CD001"
+	// UDIF trailer at the end of DMG images, big endian
+	dmgTrailerSize       = 512
+	dmgMagic             = "koly"
+	dmgSectorCountOffset = 0x1ec
+	gzipMagic            = "\x1f\x8b"
+	xzMagic              = "\xfd7zXZ\x00"
+	zstdMagic            = "\x28\xb5\x2f\xfd"
+	bzip2Magic           = "BZh"
+)
+
+// Detect identifies the format of the disk image or block device at path.
+func Detect(path string) (*Info, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	// Seek works for both regular files and block devices, whose size
+	// reported by Stat is 0
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	info, err := DetectReader(file, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the headers of %s: %w", path, err)
+	}
+	return info, nil
+}
+
+// DetectReader identifies the format of the disk image of size bytes which
+// can be read from r.
+func DetectReader(r io.ReaderAt, size int64) (*Info, error) {
+	header, err := readAt(r, 0, int(min(size, 8)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, compression := range []struct {
+		magic string
+		kind  Compression
+	}{
+		{gzipMagic, CompressionGzip},
+		{xzMagic, CompressionXz},
+		{zstdMagic, CompressionZstd},
+		{bzip2Magic, CompressionBzip2},
+	} {
+		if bytes.HasPrefix(header, []byte(compression.magic)) {
+			return &Info{Format: FormatUnknown, Compression: compression.kind}, nil
+		}
+	}
+
+	switch {
+	case bytes.HasPrefix(header, []byte(qcow2Magic)):
+		virtualSize, err := readUint64(r, qcow2SizeOffset, binary.BigEndian)
+		if err != nil {
+			return nil, err
+		}
+		return &Info{Format: FormatQcow2, VirtualSize: strongunits.B(virtualSize)}, nil
+	case bytes.HasPrefix(header, []byte(vmdkMagic)):
+		capacity, err := readUint64(r, vmdkCapacityOffset, binary.LittleEndian)
+		if err != nil {
+			return nil, err
+		}
+		return &Info{Format: FormatVMDK, VirtualSize: strongunits.B(capacity * sectorSize)}, nil
+	case bytes.HasPrefix(header, []byte(vhdxMagic)):
+		virtualSize, err := vhdxVirtualSize(r)
+		if err != nil {
+			return nil, err
+		}
+		return &Info{Format: FormatVHDX, VirtualSize: strongunits.B(virtualSize)}, nil
+	}
+
+	if hasMagic(r, 0, vmdkDescriptorMagic) {
+		descriptor, err := readVMDKDescriptor(r, 0, vmdkMaxDescriptorSize)
+		if err != nil {
+			return nil, err
+		}
+		return &Info{Format: FormatVMDK, VirtualSize: strongunits.B(descriptor.capacity() * sectorSize)}, nil
+	}
+	if hasMagic(r, vdiMagicOffset, vdiMagic) {
+		info := &Info{Format: FormatVDI}
+		// the disk size is only known for the 1.x header layout
+		major, err := readAt(r, vdiVersionOffset+2, 2)
+		if err == nil && major != nil && binary.LittleEndian.Uint16(major) == 1 {
+			virtualSize, err := readUint64(r, vdiSizeOffset, binary.LittleEndian)
+			if err != nil {
+				return nil, err
+			}
+			info.VirtualSize = strongunits.B(virtualSize)
+		}
+		return info, nil
+	}
+	if size >= dmgTrailerSize && hasMagic(r, size-dmgTrailerSize, dmgMagic) {
+		sectorCount, err := readUint64(r, size-dmgTrailerSize+dmgSectorCountOffset, binary.BigEndian)
+		if err != nil {
+			return nil, err
+		}
+		return &Info{Format: FormatDMG, VirtualSize: strongunits.B(sectorCount * sectorSize)}, nil
+	}
+	if hasMagic(r, isoDescriptorOffset, isoMagic) {
+		return &Info{Format: FormatISO, VirtualSize: strongunits.B(size)}, nil
+	}
+
+	return &Info{Format: FormatRaw, VirtualSize: strongunits.B(size)}, nil
+}
+
+// readAt reads n bytes at offset off of r. It returns a nil slice without
+// error when r is too small.
+func readAt(r io.ReaderAt, off int64, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	read, err := r.ReadAt(buf, off)
+	if errors.Is(err, io.EOF) {
+		if read < n {
+			return nil, nil
+		}
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func hasMagic(r io.ReaderAt, off int64, magic string) bool {
+	buf, err := readAt(r, off, len(magic))
+	return err == nil && string(buf) == magic
+}
+
+func readUint64(r io.ReaderAt, off int64, order binary.ByteOrder) (uint64, error) {
+	buf, err := readAt(r, off, 8)
+	if err != nil {
+		return 0, err
+	}
+	if buf == nil {
+		return 0, fmt.Errorf("truncated header")
+	}
+	return order.Uint64(buf), nil
+}
+
+func readUint32(r io.ReaderAt, off int64, order binary.ByteOrder) (uint32, error) {
+	buf, err := readAt(r, off, 4)
+	if err != nil {
+		return 0, err
+	}
+	if buf == nil {
+		return 0, fmt.Errorf("truncated header")
+	}
+	return order.Uint32(buf), nil
+}
@@ -0,0 +1,155 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/common/pkg/strongunits"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testImage is a sparse in-memory disk image
+type testImage []byte
+
+func newTestImage(size int) testImage {
+	return make(testImage, size)
+}
+
+func (img testImage) put(off int, data []byte) testImage {
+	copy(img[off:], data)
+	return img
+}
+
+func (img testImage) putUint16(off int, order binary.ByteOrder, value uint16) testImage {
+	order.PutUint16(img[off:], value)
+	return img
+}
+
+func (img testImage) putUint32(off int, order binary.ByteOrder, value uint32) testImage {
+	order.PutUint32(img[off:], value)
+	return img
+}
+
+func (img testImage) putUint64(off int, order binary.ByteOrder, value uint64) testImage {
+	order.PutUint64(img[off:], value)
+	return img
+}
+
+func newVHDXImage() testImage {
+	const metadataOffset = 1024 * 1024
+	return newTestImage(metadataOffset+128*1024).
+		put(0, []byte(vhdxMagic)).
+		put(vhdxRegionTableOffset, []byte(vhdxRegionTableMagic)).
+		putUint32(vhdxRegionTableOffset+8, binary.LittleEndian, 2).
+		// BAT region, followed by the metadata region
+		put(vhdxRegionTableOffset+16, []byte{0x66, 0x77, 0xc2, 0x2d, 0x23, 0xf6, 0x00, 0x42, 0x9d, 0x64, 0x11, 0x5e, 0x9b, 0xfd, 0x4a, 0x08}).
+		putUint64(vhdxRegionTableOffset+32, binary.LittleEndian, 2*1024*1024).
+		put(vhdxRegionTableOffset+48, vhdxMetadataRegionGUID).
+		putUint64(vhdxRegionTableOffset+64, binary.LittleEndian, metadataOffset).
+		put(metadataOffset, []byte(vhdxMetadataTableMagic)).
+		putUint16(metadataOffset+10, binary.LittleEndian, 1).
+		put(metadataOffset+32, vhdxVirtualSizeGUID).
+		putUint32(metadataOffset+48, binary.LittleEndian, 0x10000).
+		putUint64(metadataOffset+0x10000, binary.LittleEndian, 10*1024*1024*1024)
+}
+
+func TestDetectReader(t *testing.T) {
+	var tests = map[string]struct {
+		image    testImage
+		expected Info
+	}{
+		"raw": {
+			image:    newTestImage(4096),
+			expected: Info{Format: FormatRaw, VirtualSize: 4096},
+		},
+		"empty": {
+			image:    newTestImage(0),
+			expected: Info{Format: FormatRaw, VirtualSize: 0},
+		},
+		"iso": {
+			image:    newTestImage(64*1024).put(isoDescriptorOffset, []byte(isoMagic)),
+			expected: Info{Format: FormatISO, VirtualSize: 64 * 1024},
+		},
+		"qcow2": {
+			image:    newTestImage(1024).put(0, []byte(qcow2Magic)).putUint64(qcow2SizeOffset, binary.BigEndian, 1024*1024*1024),
+			expected: Info{Format: FormatQcow2, VirtualSize: strongunits.GiB(1).ToBytes()},
+		},
+		"vmdk": {
+			image:    newTestImage(1024).put(0, []byte(vmdkMagic)).putUint64(vmdkCapacityOffset, binary.LittleEndian, 4096),
+			expected: Info{Format: FormatVMDK, VirtualSize: 4096 * 512},
+		},
+		"vmdk descriptor": {
+			image: testImage("# Disk DescriptorFile\nversion=1\ncreateType=\"twoGbMaxExtentFlat\"\n\n" +
+				"# Extent description\nRW 4192256 FLAT \"disk-f001.vmdk\" 0\nRW 2048 FLAT \"disk-f002.vmdk\" 0\n"),
+			expected: Info{Format: FormatVMDK, VirtualSize: strongunits.GiB(2).ToBytes()},
+		},
+		"vdi": {
+			image: newTestImage(1024).put(0, []byte("<<< Oracle VM VirtualBox Disk Image >>>\n")).
+				put(vdiMagicOffset, []byte(vdiMagic)).
+				putUint32(vdiVersionOffset, binary.LittleEndian, 0x00010001).
+				putUint64(vdiSizeOffset, binary.LittleEndian, 8*1024*1024),
+			expected: Info{Format: FormatVDI, VirtualSize: strongunits.MiB(8).ToBytes()},
+		},
+		"vhdx": {
+			image:    newVHDXImage(),
+			expected: Info{Format: FormatVHDX, VirtualSize: strongunits.GiB(10).ToBytes()},
+		},
+		"dmg": {
+			image: newTestImage(8192).put(8192-dmgTrailerSize, []byte(dmgMagic)).
+				putUint64(8192-dmgTrailerSize+dmgSectorCountOffset, binary.BigEndian, 2048),
+			expected: Info{Format: FormatDMG, VirtualSize: strongunits.MiB(1).ToBytes()},
+		},
+		"gzip": {
+			image:    newTestImage(1024).put(0, []byte(gzipMagic+"\x08")),
+			expected: Info{Format: FormatUnknown, Compression: CompressionGzip},
+		},
+		"xz": {
+			image:    newTestImage(1024).put(0, []byte(xzMagic)),
+			expected: Info{Format: FormatUnknown, Compression: CompressionXz},
+		},
+		"zstd": {
+			image:    newTestImage(1024).put(0, []byte(zstdMagic)),
+			expected: Info{Format: FormatUnknown, Compression: CompressionZstd},
+		},
+		"bzip2": {
+			image:    newTestImage(1024).put(0, []byte(bzip2Magic+"9")),
+			expected: Info{Format: FormatUnknown, Compression: CompressionBzip2},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			info, err := DetectReader(bytes.NewReader(test.image), int64(len(test.image)))
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, *info)
+			assert.Equal(t, test.expected.Format == FormatRaw || test.expected.Format == FormatISO, info.IsRaw())
+		})
+	}
+}
+
+func TestDetectReaderErrors(t *testing.T) {
+	truncatedQcow2 := testImage(qcow2Magic + "\x00\x00\x00\x03")
+	_, err := DetectReader(bytes.NewReader(truncatedQcow2), int64(len(truncatedQcow2)))
+	require.EqualError(t, err, "truncated header")
+
+	vhdx := newVHDXImage().put(vhdxRegionTableOffset+48, make([]byte, 16))
+	_, err = DetectReader(bytes.NewReader(vhdx), int64(len(vhdx)))
+	require.EqualError(t, err, "missing VHDX metadata region")
+}
+
+func TestDetect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disk.qcow2")
+	img := newTestImage(1024).put(0, []byte(qcow2Magic)).putUint64(qcow2SizeOffset, binary.BigEndian, 1024)
+	require.NoError(t, os.WriteFile(path, img, 0600))
+
+	info, err := Detect(path)
+	require.NoError(t, err)
+	assert.Equal(t, &Info{Format: FormatQcow2, VirtualSize: 1024}, info)
+
+	_, err = Detect(filepath.Join(t.TempDir(), "missing.img"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,204 @@
+package image
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+const (
+	gptMagic = "EFI PART"
+
+	// GPT header fields, little endian
+	gptHeaderSizeOffset      = 12
+	gptHeaderCRCOffset       = 16
+	gptMyLBAOffset           = 24
+	gptAlternateLBAOffset    = 32
+	gptFirstUsableLBAOffset  = 40
+	gptLastUsableLBAOffset   = 48
+	gptEntriesLBAOffset      = 72
+	gptEntriesCountOffset    = 80
+	gptEntrySizeOffset       = 84
+	gptEntriesCRCOffset      = 88
+	gptMinHeaderSize         = 92
+	gptMinEntrySize          = 128
+	gptMaxEntriesSize        = 1024 * 1024
+	gptEntryEndingLBAOffset  = 40
+	mbrPartitionTableOffset  = 446
+	mbrProtectiveType        = 0xee
+	mbrPartitionSizeOffset   = 12
+	mbrPartitionTypeOffset   = 4
+	mbrPartitionsCount       = 4
+	mbrPartitionEntrySize    = 16
+	mbrMaxPartitionSectorLBA = 0xffffffff
+)
+
+// gpt is the GUID partition table of a raw disk image, as read from its
+// primary header.
+type gpt struct {
+	sectorSize int64
+	header     []byte
+	entries    []byte
+}
+
+// readGPT reads the primary GPT of the raw image r. It returns nil if the
+// image has no GPT, with 512 or 4096 bytes sectors.
+func readGPT(r io.ReaderAt) (*gpt, error) {
+	for _, size := range []int64{512, 4096} {
+		if !hasMagic(r, size, gptMagic) {
+			continue
+		}
+		table := &gpt{sectorSize: size}
+		headerSize, err := readUint32(r, size+gptHeaderSizeOffset, binary.LittleEndian)
+		if err != nil {
+			return nil, err
+		}
+		if headerSize < gptMinHeaderSize || int64(headerSize) > size {
+			return nil, fmt.Errorf("invalid GPT header size %d", headerSize)
+		}
+		table.header, err = readAt(r, size, int(headerSize))
+		if err != nil {
+			return nil, err
+		}
+		if table.header == nil || gptChecksum(table.header, gptHeaderCRCOffset) != table.uint32(gptHeaderCRCOffset) {
+			return nil, fmt.Errorf("invalid GPT header checksum")
+		}
+
+		entrySize := table.uint32(gptEntrySizeOffset)
+		entriesSize := int64(table.uint32(gptEntriesCountOffset)) * int64(entrySize)
+		if entrySize < gptMinEntrySize || entriesSize > gptMaxEntriesSize {
+			return nil, fmt.Errorf("invalid GPT partition entries size")
+		}
+		table.entries, err = readAt(r, int64(table.uint64(gptEntriesLBAOffset))*size, int(entriesSize))
+		if err != nil {
+			return nil, err
+		}
+		if table.entries == nil || crc32.ChecksumIEEE(table.entries) != table.uint32(gptEntriesCRCOffset) {
+			return nil, fmt.Errorf("invalid GPT partition entries checksum")
+		}
+		return table, nil
+	}
+	return nil, nil
+}
+
+// gptChecksum computes the CRC32 of a GPT header, whose own checksum is at
+// crcOffset.
+func gptChecksum(header []byte, crcOffset int) uint32 {
+	data := append([]byte{}, header...)
+	clear(data[crcOffset : crcOffset+4])
+	return crc32.ChecksumIEEE(data)
+}
+
+func (table *gpt) uint32(offset int) uint32 {
+	return binary.LittleEndian.Uint32(table.header[offset:])
+}
+
+func (table *gpt) uint64(offset int) uint64 {
+	return binary.LittleEndian.Uint64(table.header[offset:])
+}
+
+// entriesSectors is the number of sectors used by the partition entries.
+func (table *gpt) entriesSectors() uint64 {
+	return (uint64(len(table.entries)) + uint64(table.sectorSize) - 1) / uint64(table.sectorSize)
+}
+
+// lastUsableLBA is the last sector which can be used by partitions on a disk
+// of size bytes, the following sectors hold the backup partition entries and
+// the backup header.
+func (table *gpt) lastUsableLBA(size int64) uint64 {
+	return uint64(size/table.sectorSize) - table.entriesSectors() - 2
+}
+
+// checkPartitionsFit fails if some partitions would not fit on a disk of size
+// bytes.
+func (table *gpt) checkPartitionsFit(size int64) error {
+	lastUsable := table.lastUsableLBA(size)
+	if size/table.sectorSize < int64(table.entriesSectors())+2 || lastUsable < table.uint64(gptFirstUsableLBAOffset) {
+		return fmt.Errorf("the disk is too small for its GUID partition table")
+	}
+	entrySize := int(table.uint32(gptEntrySizeOffset))
+	for i := 0; i+entrySize <= len(table.entries); i += entrySize {
+		entry := table.entries[i : i+entrySize]
+		endingLBA := binary.LittleEndian.Uint64(entry[gptEntryEndingLBAOffset:])
+		// the entries of unused partitions have a zero type GUID
+		if isZero(entry[:16]) || endingLBA <= lastUsable {
+			continue
+		}
+		return fmt.Errorf("partition %d ends at %d bytes, after the end of the resized disk", i/entrySize+1, (int64(endingLBA)+1)*table.sectorSize)
+	}
+	return nil
+}
+
+// relocate updates the GPT of file, whose size changed from oldSize to
+// size bytes: the backup partition entries and header are written at the end
+// of the disk, and the primary header and protective MBR describe the new
+// disk size.
+func (table *gpt) relocate(file *os.File, oldSize, size int64) error {
+	lastLBA := uint64(size/table.sectorSize) - 1
+	lastUsable := table.lastUsableLBA(size)
+
+	// stale backup structures in the middle of the disk could be picked up
+	// by partitioning tools
+	oldLastLBA := uint64(oldSize/table.sectorSize) - 1
+	if oldSize < size && table.uint64(gptAlternateLBAOffset) == oldLastLBA {
+		backupStart := int64(oldLastLBA-table.entriesSectors()) * table.sectorSize
+		if _, err := file.WriteAt(make([]byte, oldSize-backupStart), backupStart); err != nil {
+			return err
+		}
+	}
+
+	primary := table.header
+	binary.LittleEndian.PutUint64(primary[gptAlternateLBAOffset:], lastLBA)
+	binary.LittleEndian.PutUint64(primary[gptLastUsableLBAOffset:], lastUsable)
+	binary.LittleEndian.PutUint32(primary[gptHeaderCRCOffset:], gptChecksum(primary, gptHeaderCRCOffset))
+
+	backup := append([]byte{}, primary...)
+	backupEntriesLBA := lastLBA - table.entriesSectors()
+	binary.LittleEndian.PutUint64(backup[gptMyLBAOffset:], lastLBA)
+	binary.LittleEndian.PutUint64(backup[gptAlternateLBAOffset:], 1)
+	binary.LittleEndian.PutUint64(backup[gptEntriesLBAOffset:], backupEntriesLBA)
+	binary.LittleEndian.PutUint32(backup[gptHeaderCRCOffset:], gptChecksum(backup, gptHeaderCRCOffset))
+
+	if _, err := file.WriteAt(table.entries, int64(backupEntriesLBA)*table.sectorSize); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(backup, int64(lastLBA)*table.sectorSize); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(primary, table.sectorSize); err != nil {
+		return err
+	}
+	return table.updateProtectiveMBR(file, lastLBA)
+}
+
+// updateProtectiveMBR makes the 0xee MBR partition, which protects the GPT
+// from legacy tools, cover the whole disk, which ends at lastLBA.
+func (table *gpt) updateProtectiveMBR(file *os.File, lastLBA uint64) error {
+	mbr, err := readAt(file, 0, mbrPartitionTableOffset+mbrPartitionsCount*mbrPartitionEntrySize)
+	if err != nil || mbr == nil {
+		return err
+	}
+	for i := range mbrPartitionsCount {
+		entry := mbr[mbrPartitionTableOffset+i*mbrPartitionEntrySize:]
+		if entry[mbrPartitionTypeOffset] != mbrProtectiveType {
+			continue
+		}
+		size := uint32(min(lastLBA, mbrMaxPartitionSectorLBA))
+		var buf [4]byte
+		binary.LittleEndian.PutUint32(buf[:], size)
+		_, err := file.WriteAt(buf[:], int64(mbrPartitionTableOffset+i*mbrPartitionEntrySize+mbrPartitionSizeOffset))
+		return err
+	}
+	return nil
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
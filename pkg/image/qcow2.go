@@ -0,0 +1,218 @@
+package image
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// qcow2 header fields, big endian
+	qcow2VersionOffset          = 4
+	qcow2BackingFileOffset      = 8
+	qcow2BackingFileSizeOffset  = 16
+	qcow2ClusterBitsOffset      = 20
+	qcow2CryptMethodOffset      = 32
+	qcow2L1SizeOffset           = 36
+	qcow2L1TableOffset          = 40
+	qcow2IncompatibleFeatOffset = 72
+
+	// incompatible features which prevent reading the image
+	qcow2CorruptFeature         = 1 << 1
+	qcow2ExternalDataFeature    = 1 << 2
+	qcow2CompressionTypeFeature = 1 << 3
+	qcow2ExtendedL2Feature      = 1 << 4
+
+	qcow2OffsetMask      = 0x00fffffffffffe00
+	qcow2CompressedFlag  = 1 << 62
+	qcow2ZeroClusterFlag = 1
+)
+
+// qcow2Disk reads the guest data of qcow2 images.
+type qcow2Disk struct {
+	file        *os.File
+	size        int64
+	clusterBits uint
+	l1          []uint64
+	backing     disk
+
+	// last L2 table and compressed cluster which were read
+	l2Offset         uint64
+	l2               []uint64
+	compressedOffset uint64
+	compressed       []byte
+}
+
+func openQcow2(file *os.File, depth int) (*qcow2Disk, error) {
+	header := make([]byte, 104)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("failed to read the qcow2 header: %w", err)
+	}
+	version := binary.BigEndian.Uint32(header[qcow2VersionOffset:])
+	if version != 2 && version != 3 {
+		return nil, fmt.Errorf("unsupported qcow2 version %d", version)
+	}
+	if binary.BigEndian.Uint32(header[qcow2CryptMethodOffset:]) != 0 {
+		return nil, fmt.Errorf("encrypted qcow2 images are not supported")
+	}
+	if version == 3 {
+		features := binary.BigEndian.Uint64(header[qcow2IncompatibleFeatOffset:])
+		switch {
+		case features&qcow2CorruptFeature != 0:
+			return nil, fmt.Errorf("the qcow2 image is marked as corrupt")
+		case features&qcow2ExternalDataFeature != 0:
+			return nil, fmt.Errorf("qcow2 images with an external data file are not supported")
+		case features&qcow2CompressionTypeFeature != 0:
+			return nil, fmt.Errorf("qcow2 images using zstd compression are not supported")
+		case features&qcow2ExtendedL2Feature != 0:
+			return nil, fmt.Errorf("qcow2 images with extended L2 entries are not supported")
+		}
+	}
+
+	disk := &qcow2Disk{
+		file:        file,
+		size:        int64(binary.BigEndian.Uint64(header[qcow2SizeOffset:])),
+		clusterBits: uint(binary.BigEndian.Uint32(header[qcow2ClusterBitsOffset:])),
+	}
+	if disk.clusterBits < 9 || disk.clusterBits > 21 {
+		return nil, fmt.Errorf("invalid qcow2 cluster size: 2^%d", disk.clusterBits)
+	}
+	l1Size := int64(binary.BigEndian.Uint32(header[qcow2L1SizeOffset:]))
+	l1Coverage := disk.clusterSize() * disk.clusterSize() / 8
+	if l1Size < (disk.size+l1Coverage-1)/l1Coverage {
+		return nil, fmt.Errorf("the qcow2 L1 table is too small")
+	}
+	disk.l1 = make([]uint64, l1Size)
+	l1Offset := int64(binary.BigEndian.Uint64(header[qcow2L1TableOffset:]))
+	if err := binary.Read(io.NewSectionReader(file, l1Offset, l1Size*8), binary.BigEndian, disk.l1); err != nil {
+		return nil, fmt.Errorf("failed to read the qcow2 L1 table: %w", err)
+	}
+
+	backingFileOffset := int64(binary.BigEndian.Uint64(header[qcow2BackingFileOffset:]))
+	if backingFileOffset != 0 {
+		name := make([]byte, binary.BigEndian.Uint32(header[qcow2BackingFileSizeOffset:]))
+		if _, err := file.ReadAt(name, backingFileOffset); err != nil {
+			return nil, fmt.Errorf("failed to read the qcow2 backing file name: %w", err)
+		}
+		backingPath := string(name)
+		if !filepath.IsAbs(backingPath) {
+			backingPath = filepath.Join(filepath.Dir(file.Name()), backingPath)
+		}
+		backing, err := openDisk(backingPath, depth+1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open backing file: %w", err)
+		}
+		disk.backing = backing
+	}
+
+	return disk, nil
+}
+
+func (disk *qcow2Disk) clusterSize() int64 {
+	return 1 << disk.clusterBits
+}
+
+func (disk *qcow2Disk) Size() int64 {
+	return disk.size
+}
+
+func (disk *qcow2Disk) ReadAt(p []byte, off int64) (int, error) {
+	return readBlocks(p, off, disk.size, disk.clusterSize(), disk.readCluster)
+}
+
+// readCluster fills p with the data at off, p is part of a single cluster.
+func (disk *qcow2Disk) readCluster(p []byte, off int64) error {
+	entry, err := disk.l2Entry(off)
+	if err != nil {
+		return err
+	}
+	inCluster := off & (disk.clusterSize() - 1)
+	switch {
+	case entry&qcow2CompressedFlag != 0:
+		cluster, err := disk.readCompressedCluster(entry)
+		if err != nil {
+			return err
+		}
+		copy(p, cluster[inCluster:])
+		return nil
+	case entry&qcow2ZeroClusterFlag != 0:
+		clear(p)
+		return nil
+	case entry&qcow2OffsetMask == 0:
+		return disk.readBacking(p, off)
+	default:
+		_, err := disk.file.ReadAt(p, int64(entry&qcow2OffsetMask)+inCluster)
+		return err
+	}
+}
+
+// l2Entry returns the L2 table entry describing the cluster at off. It's 0
+// for unallocated clusters.
+func (disk *qcow2Disk) l2Entry(off int64) (uint64, error) {
+	l2Bits := disk.clusterBits - 3
+	l1Index := off >> (disk.clusterBits + l2Bits)
+	l2Offset := disk.l1[l1Index] & qcow2OffsetMask
+	if l2Offset == 0 {
+		return 0, nil
+	}
+	if l2Offset != disk.l2Offset {
+		l2 := make([]uint64, 1<<l2Bits)
+		if err := binary.Read(io.NewSectionReader(disk.file, int64(l2Offset), disk.clusterSize()), binary.BigEndian, l2); err != nil {
+			return 0, fmt.Errorf("failed to read qcow2 L2 table: %w", err)
+		}
+		disk.l2Offset = l2Offset
+		disk.l2 = l2
+	}
+	return disk.l2[(off>>disk.clusterBits)&(1<<l2Bits-1)], nil
+}
+
+// readCompressedCluster returns the uncompressed data of the cluster
+// described by the L2 entry.
+func (disk *qcow2Disk) readCompressedCluster(entry uint64) ([]byte, error) {
+	offsetBits := 62 - (disk.clusterBits - 8)
+	hostOffset := entry & (1<<offsetBits - 1)
+	if disk.compressed != nil && hostOffset == disk.compressedOffset {
+		return disk.compressed, nil
+	}
+	sectors := (entry>>offsetBits)&(1<<(disk.clusterBits-8)-1) + 1
+	compressed := make([]byte, sectors*512-hostOffset&511)
+	// the compressed data can end before the last sector
+	n, err := disk.file.ReadAt(compressed, int64(hostOffset))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	cluster := make([]byte, disk.clusterSize())
+	if _, err := io.ReadFull(flate.NewReader(bytes.NewReader(compressed[:n])), cluster); err != nil {
+		return nil, fmt.Errorf("failed to decompress qcow2 cluster: %w", err)
+	}
+	disk.compressedOffset = hostOffset
+	disk.compressed = cluster
+	return cluster, nil
+}
+
+// readBacking fills p with the backing file data at off, the parts beyond the
+// end of the backing file are zeros.
+func (disk *qcow2Disk) readBacking(p []byte, off int64) error {
+	clear(p)
+	if disk.backing == nil || off >= disk.backing.Size() {
+		return nil
+	}
+	n := min(int64(len(p)), disk.backing.Size()-off)
+	_, err := disk.backing.ReadAt(p[:n], off)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+func (disk *qcow2Disk) Close() error {
+	if disk.backing != nil {
+		disk.backing.Close()
+	}
+	return disk.file.Close()
+}
@@ -0,0 +1,19 @@
+//go:build darwin
+
+package image
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink creates dstPath as an APFS clone of srcPath.
+func reflink(srcPath, dstPath string) error {
+	err := unix.Clonefile(srcPath, dstPath, unix.CLONE_NOFOLLOW)
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EXDEV) {
+		return fmt.Errorf("%w: %v", errReflinkUnsupported, err)
+	}
+	return err
+}
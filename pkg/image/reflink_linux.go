@@ -0,0 +1,46 @@
+//go:build linux
+
+package image
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// reflink creates dstPath as a reflink of srcPath, on filesystems such as
+// btrfs or XFS.
+func reflink(srcPath, dstPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	err = unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		return nil
+	}
+	if removeErr := os.Remove(dstPath); removeErr != nil {
+		log.Warnf("failed to remove %s: %v", dstPath, removeErr)
+	}
+	for _, unsupported := range []error{unix.EOPNOTSUPP, unix.EXDEV, unix.EINVAL, unix.ENOTTY} {
+		if errors.Is(err, unsupported) {
+			return fmt.Errorf("%w: %v", errReflinkUnsupported, err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,8 @@
+//go:build !darwin && !linux
+
+package image
+
+// reflink is not implemented on this platform, files are always copied.
+func reflink(_, _ string) error {
+	return errReflinkUnsupported
+}
@@ -0,0 +1,206 @@
+package image
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
+)
+
+const snapshotsMetadataFile = "snapshots.json"
+
+var snapshotNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Snapshot is a saved state of a raw disk image.
+type Snapshot struct {
+	Name string `json:"name"`
+	// Parent is the snapshot which was current when this snapshot was taken
+	Parent  string    `json:"parent,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// SnapshotTree lists the snapshots of a raw disk image. It is stored with the
+// snapshot images in the <image>.snapshots directory next to the image.
+type SnapshotTree struct {
+	// Current is the last snapshot which was taken or reverted to, it is
+	// the parent of the next snapshot
+	Current   string     `json:"current,omitempty"`
+	Snapshots []Snapshot `json:"snapshots,omitempty"`
+}
+
+func snapshotsDir(imagePath string) string {
+	return imagePath + ".snapshots"
+}
+
+func snapshotPath(imagePath, name string) string {
+	return filepath.Join(snapshotsDir(imagePath), name+".raw")
+}
+
+// ReadSnapshots returns the snapshot tree of the image at imagePath. It is
+// empty if no snapshots were taken.
+func ReadSnapshots(imagePath string) (*SnapshotTree, error) {
+	data, err := os.ReadFile(filepath.Join(snapshotsDir(imagePath), snapshotsMetadataFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return &SnapshotTree{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tree SnapshotTree
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("invalid snapshot metadata for %s: %w", imagePath, err)
+	}
+	return &tree, nil
+}
+
+func writeSnapshots(imagePath string, tree *SnapshotTree) error {
+	if len(tree.Snapshots) == 0 {
+		return os.RemoveAll(snapshotsDir(imagePath))
+	}
+	data, err := json.MarshalIndent(tree, "", "  ")
+	if err != nil {
+		return err
+	}
+	// the metadata is replaced atomically so that it's never truncated
+	path := filepath.Join(snapshotsDir(imagePath), snapshotsMetadataFile)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Get returns the snapshot called name, or nil if there is none.
+func (tree *SnapshotTree) Get(name string) *Snapshot {
+	for i := range tree.Snapshots {
+		if tree.Snapshots[i].Name == name {
+			return &tree.Snapshots[i]
+		}
+	}
+	return nil
+}
+
+// Children returns the snapshots whose parent is name. The snapshots without
+// parent are returned when name is empty.
+func (tree *SnapshotTree) Children(name string) []Snapshot {
+	var children []Snapshot
+	for _, snapshot := range tree.Snapshots {
+		if snapshot.Parent == name {
+			children = append(children, snapshot)
+		}
+	}
+	return children
+}
+
+// CreateSnapshot saves the current content of the raw image at imagePath as a
+// snapshot called name. The snapshot is a copy-on-write clone of the image
+// when the filesystem supports it. The virtual machine using the image must
+// not be running.
+func CreateSnapshot(imagePath, name string) error {
+	if !snapshotNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid snapshot name '%s', it must only contain letters, digits, '.', '_' and '-'", name)
+	}
+	tree, err := ReadSnapshots(imagePath)
+	if err != nil {
+		return err
+	}
+	if tree.Get(name) != nil {
+		return fmt.Errorf("%s already has a snapshot called '%s'", imagePath, name)
+	}
+	_, err = os.Stat(snapshotsDir(imagePath))
+	createdDir := errors.Is(err, os.ErrNotExist)
+	if err := os.MkdirAll(snapshotsDir(imagePath), 0700); err != nil {
+		return err
+	}
+	err = Clone(imagePath, snapshotPath(imagePath, name))
+	if err == nil {
+		tree.Snapshots = append(tree.Snapshots, Snapshot{
+			Name:    name,
+			Parent:  tree.Current,
+			Created: time.Now(),
+		})
+		tree.Current = name
+		err = writeSnapshots(imagePath, tree)
+	}
+	if err != nil {
+		// don't leave a snapshot which isn't in the metadata behind
+		os.Remove(snapshotPath(imagePath, name))
+		if createdDir {
+			os.Remove(snapshotsDir(imagePath))
+		}
+		return err
+	}
+	return nil
+}
+
+// RevertSnapshot replaces the content of the image at imagePath with the
+// snapshot called name. The changes made to the image since the last snapshot
+// are lost. The virtual machine using the image must not be running.
+func RevertSnapshot(imagePath, name string) error {
+	tree, err := ReadSnapshots(imagePath)
+	if err != nil {
+		return err
+	}
+	if tree.Get(name) == nil {
+		return fmt.Errorf("%s has no snapshot called '%s'", imagePath, name)
+	}
+	// the image is replaced atomically, it's never partially reverted
+	tmpPath := imagePath + ".revert"
+	if err := os.Remove(tmpPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := cloneFile(snapshotPath(imagePath, name), tmpPath); err != nil {
+		return fmt.Errorf("failed to revert %s to snapshot '%s': %w", imagePath, name, err)
+	}
+	if err := os.Rename(tmpPath, imagePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	tree.Current = name
+	return writeSnapshots(imagePath, tree)
+}
+
+// DeleteSnapshot deletes the snapshot called name of the image at imagePath.
+// Its children become children of its parent.
+func DeleteSnapshot(imagePath, name string) error {
+	tree, err := ReadSnapshots(imagePath)
+	if err != nil {
+		return err
+	}
+	snapshot := tree.Get(name)
+	if snapshot == nil {
+		return fmt.Errorf("%s has no snapshot called '%s'", imagePath, name)
+	}
+	parent := snapshot.Parent
+	if err := os.Remove(snapshotPath(imagePath, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	tree.Snapshots = slices.DeleteFunc(tree.Snapshots, func(snapshot Snapshot) bool {
+		return snapshot.Name == name
+	})
+	for i := range tree.Snapshots {
+		if tree.Snapshots[i].Parent == name {
+			tree.Snapshots[i].Parent = parent
+		}
+	}
+	if tree.Current == name {
+		tree.Current = parent
+	}
+	return writeSnapshots(imagePath, tree)
+}
+
+// CloneSnapshot creates a copy-on-write clone of the snapshot called name of
+// the image at imagePath, at dstPath, which must not exist.
+func CloneSnapshot(imagePath, name, dstPath string) error {
+	tree, err := ReadSnapshots(imagePath)
+	if err != nil {
+		return err
+	}
+	if tree.Get(name) == nil {
+		return fmt.Errorf("%s has no snapshot called '%s'", imagePath, name)
+	}
+	return Clone(snapshotPath(imagePath, name), dstPath)
+}
@@ -0,0 +1,90 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	imagePath := filepath.Join(dir, "disk.raw")
+	readImage := func() []byte {
+		data, err := os.ReadFile(imagePath)
+		require.NoError(t, err)
+		return data
+	}
+	snapshotNames := func(snapshots []Snapshot) []string {
+		var names []string
+		for _, snapshot := range snapshots {
+			names = append(names, snapshot.Name)
+		}
+		return names
+	}
+
+	writeTestFile(t, imagePath, filled('a', testClusterSize))
+	require.NoError(t, CreateSnapshot(imagePath, "base"))
+	writeTestFile(t, imagePath, filled('b', testClusterSize))
+	require.NoError(t, CreateSnapshot(imagePath, "updated"))
+	writeTestFile(t, imagePath, filled('c', testClusterSize))
+
+	require.NoError(t, RevertSnapshot(imagePath, "base"))
+	assert.Equal(t, filled('a', testClusterSize), readImage())
+	require.NoError(t, CreateSnapshot(imagePath, "other"))
+	require.NoError(t, RevertSnapshot(imagePath, "updated"))
+	assert.Equal(t, filled('b', testClusterSize), readImage())
+
+	tree, err := ReadSnapshots(imagePath)
+	require.NoError(t, err)
+	assert.Equal(t, "updated", tree.Current)
+	assert.Equal(t, []string{"base"}, snapshotNames(tree.Children("")))
+	assert.Equal(t, []string{"updated", "other"}, snapshotNames(tree.Children("base")))
+
+	clonePath := filepath.Join(dir, "vm1.raw")
+	require.NoError(t, CloneSnapshot(imagePath, "other", clonePath))
+	data, err := os.ReadFile(clonePath)
+	require.NoError(t, err)
+	assert.Equal(t, filled('a', testClusterSize), data)
+
+	require.ErrorContains(t, CreateSnapshot(imagePath, "base"), "already has a snapshot called 'base'")
+	require.ErrorContains(t, CreateSnapshot(imagePath, "../base"), "invalid snapshot name")
+	require.ErrorContains(t, RevertSnapshot(imagePath, "missing"), "has no snapshot called 'missing'")
+
+	// the children of a deleted snapshot are reparented
+	require.NoError(t, DeleteSnapshot(imagePath, "base"))
+	tree, err = ReadSnapshots(imagePath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"updated", "other"}, snapshotNames(tree.Children("")))
+	assert.NoFileExists(t, snapshotPath(imagePath, "base"))
+
+	require.NoError(t, DeleteSnapshot(imagePath, "updated"))
+	require.NoError(t, DeleteSnapshot(imagePath, "other"))
+	assert.NoDirExists(t, snapshotsDir(imagePath))
+	tree, err = ReadSnapshots(imagePath)
+	require.NoError(t, err)
+	assert.Empty(t, tree.Snapshots)
+	assert.Equal(t, filled('b', testClusterSize), readImage())
+}
+
+func TestCreateSnapshotFailure(t *testing.T) {
+	imagePath := filepath.Join(t.TempDir(), "disk.raw")
+
+	// the snapshots directory is removed when the first snapshot fails
+	require.Error(t, CreateSnapshot(imagePath, "base"))
+	assert.NoDirExists(t, snapshotsDir(imagePath))
+
+	// the existing snapshots are kept when a later one fails
+	writeTestFile(t, imagePath, filled('a', testClusterSize))
+	require.NoError(t, CreateSnapshot(imagePath, "base"))
+	require.NoError(t, os.Remove(imagePath))
+	require.Error(t, CreateSnapshot(imagePath, "updated"))
+	assert.NoFileExists(t, snapshotPath(imagePath, "updated"))
+	assert.FileExists(t, snapshotPath(imagePath, "base"))
+	tree, err := ReadSnapshots(imagePath)
+	require.NoError(t, err)
+	assert.Equal(t, "base", tree.Current)
+	assert.Len(t, tree.Snapshots, 1)
+}
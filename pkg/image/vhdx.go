@@ -0,0 +1,254 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	vhdxHeader1Offset      = 64 * 1024
+	vhdxHeader2Offset      = 128 * 1024
+	vhdxHeaderMagic        = "head"
+	vhdxRegionTableOffset  = 192 * 1024
+	vhdxRegionTableMagic   = "regi"
+	vhdxMetadataTableMagic = "metadata"
+
+	// BAT entry states
+	vhdxBlockNotPresent   = 0
+	vhdxBlockUndefined    = 1
+	vhdxBlockZero         = 2
+	vhdxBlockUnmapped     = 3
+	vhdxBlockFullyPresent = 6
+
+	vhdxHasParentFlag = 1 << 1
+)
+
+// GUIDs of the VHDX regions and metadata items, in their on-disk
+// representation
+var (
+	vhdxBATRegionGUID      = []byte{0x66, 0x77, 0xc2, 0x2d, 0x23, 0xf6, 0x00, 0x42, 0x9d, 0x64, 0x11, 0x5e, 0x9b, 0xfd, 0x4a, 0x08}
+	vhdxMetadataRegionGUID = []byte{0x06, 0xa2, 0x7c, 0x8b, 0x90, 0x47, 0x9a, 0x4b, 0xb8, 0xfe, 0x57, 0x5f, 0x05, 0x0f, 0x88, 0x6e}
+	vhdxFileParametersGUID = []byte{0x37, 0x67, 0xa1, 0xca, 0x36, 0xfa, 0x43, 0x4d, 0xb3, 0xb6, 0x33, 0xf0, 0xaa, 0x44, 0xe7, 0x6b}
+	vhdxVirtualSizeGUID    = []byte{0x24, 0x42, 0xa5, 0x2f, 0x1b, 0xcd, 0x76, 0x48, 0xb2, 0x11, 0x5d, 0xbe, 0xd8, 0x3b, 0xf4, 0xb8}
+	vhdxSectorSizeGUID     = []byte{0x1d, 0xbf, 0x41, 0x81, 0x6f, 0xa9, 0x09, 0x47, 0xba, 0x47, 0xf2, 0x33, 0xa8, 0xfa, 0xab, 0x5f}
+)
+
+// vhdxRegion returns the offset and length of the region identified by guid
+// in the VHDX region table.
+func vhdxRegion(r io.ReaderAt, guid []byte, name string) (int64, int64, error) {
+	const (
+		entriesOffset = 16
+		entrySize     = 32
+	)
+	if !hasMagic(r, vhdxRegionTableOffset, vhdxRegionTableMagic) {
+		return 0, 0, fmt.Errorf("invalid VHDX region table")
+	}
+	count, err := readUint32(r, vhdxRegionTableOffset+8, binary.LittleEndian)
+	if err != nil {
+		return 0, 0, err
+	}
+	for i := range int64(count) {
+		entryOffset := vhdxRegionTableOffset + entriesOffset + i*entrySize
+		entryGUID, err := readAt(r, entryOffset, 16)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !bytes.Equal(entryGUID, guid) {
+			continue
+		}
+		offset, err := readUint64(r, entryOffset+16, binary.LittleEndian)
+		if err != nil {
+			return 0, 0, err
+		}
+		length, err := readUint32(r, entryOffset+24, binary.LittleEndian)
+		if err != nil {
+			return 0, 0, err
+		}
+		return int64(offset), int64(length), nil
+	}
+	return 0, 0, fmt.Errorf("missing VHDX %s region", name)
+}
+
+// vhdxMetadataItem returns the offset in the file of the metadata item
+// identified by guid.
+func vhdxMetadataItem(r io.ReaderAt, metadataOffset int64, guid []byte, name string) (int64, error) {
+	const (
+		entriesOffset = 32
+		entrySize     = 32
+	)
+	if !hasMagic(r, metadataOffset, vhdxMetadataTableMagic) {
+		return 0, fmt.Errorf("invalid VHDX metadata table")
+	}
+	count, err := readAt(r, metadataOffset+10, 2)
+	if err != nil || count == nil {
+		return 0, fmt.Errorf("invalid VHDX metadata table")
+	}
+	for i := range int64(binary.LittleEndian.Uint16(count)) {
+		entryOffset := metadataOffset + entriesOffset + i*entrySize
+		entryGUID, err := readAt(r, entryOffset, 16)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.Equal(entryGUID, guid) {
+			continue
+		}
+		itemOffset, err := readUint32(r, entryOffset+16, binary.LittleEndian)
+		if err != nil {
+			return 0, err
+		}
+		return metadataOffset + int64(itemOffset), nil
+	}
+	return 0, fmt.Errorf("missing VHDX %s", name)
+}
+
+func vhdxMetadataUint32(r io.ReaderAt, metadataOffset int64, guid []byte, name string) (uint32, error) {
+	offset, err := vhdxMetadataItem(r, metadataOffset, guid, name)
+	if err != nil {
+		return 0, err
+	}
+	return readUint32(r, offset, binary.LittleEndian)
+}
+
+// vhdxVirtualSize finds the virtual disk size item of the metadata region
+// listed in the VHDX region table.
+func vhdxVirtualSize(r io.ReaderAt) (uint64, error) {
+	metadataOffset, _, err := vhdxRegion(r, vhdxMetadataRegionGUID, "metadata")
+	if err != nil {
+		return 0, err
+	}
+	offset, err := vhdxMetadataItem(r, metadataOffset, vhdxVirtualSizeGUID, "virtual disk size")
+	if err != nil {
+		return 0, err
+	}
+	return readUint64(r, offset, binary.LittleEndian)
+}
+
+// vhdxDisk reads the guest data of dynamic and fixed VHDX images.
+// Differencing images are not supported.
+type vhdxDisk struct {
+	file       *os.File
+	size       int64
+	blockSize  int64
+	chunkRatio int64
+	bat        []uint64
+}
+
+func openVHDX(file *os.File) (*vhdxDisk, error) {
+	if err := vhdxCheckLog(file); err != nil {
+		return nil, err
+	}
+	metadataOffset, _, err := vhdxRegion(file, vhdxMetadataRegionGUID, "metadata")
+	if err != nil {
+		return nil, err
+	}
+	parametersOffset, err := vhdxMetadataItem(file, metadataOffset, vhdxFileParametersGUID, "file parameters")
+	if err != nil {
+		return nil, err
+	}
+	blockSize, err := readUint32(file, parametersOffset, binary.LittleEndian)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := readUint32(file, parametersOffset+4, binary.LittleEndian)
+	if err != nil {
+		return nil, err
+	}
+	if flags&vhdxHasParentFlag != 0 {
+		return nil, fmt.Errorf("differencing VHDX images are not supported")
+	}
+	sectorSize, err := vhdxMetadataUint32(file, metadataOffset, vhdxSectorSizeGUID, "logical sector size")
+	if err != nil {
+		return nil, err
+	}
+	virtualSize, err := vhdxVirtualSize(file)
+	if err != nil {
+		return nil, err
+	}
+	if blockSize == 0 || blockSize&(blockSize-1) != 0 || (sectorSize != 512 && sectorSize != 4096) {
+		return nil, fmt.Errorf("invalid VHDX block size %d or logical sector size %d", blockSize, sectorSize)
+	}
+
+	disk := &vhdxDisk{
+		file:      file,
+		size:      int64(virtualSize),
+		blockSize: int64(blockSize),
+		// number of data blocks described by a sector bitmap block
+		chunkRatio: (1 << 23) * int64(sectorSize) / int64(blockSize),
+	}
+	batOffset, batLength, err := vhdxRegion(file, vhdxBATRegionGUID, "BAT")
+	if err != nil {
+		return nil, err
+	}
+	dataBlocks := (disk.size + disk.blockSize - 1) / disk.blockSize
+	batEntries := dataBlocks + (dataBlocks-1)/disk.chunkRatio
+	if batEntries*8 > batLength {
+		return nil, fmt.Errorf("VHDX BAT region is too small")
+	}
+	disk.bat = make([]uint64, batEntries)
+	if err := binary.Read(io.NewSectionReader(file, batOffset, batEntries*8), binary.LittleEndian, disk.bat); err != nil {
+		return nil, fmt.Errorf("failed to read the VHDX BAT: %w", err)
+	}
+
+	return disk, nil
+}
+
+// vhdxCheckLog fails if the current VHDX header references a log, since its
+// entries would need to be replayed before reading the image.
+func vhdxCheckLog(r io.ReaderAt) error {
+	var current []byte
+	var currentSequence uint64
+	for _, offset := range []int64{vhdxHeader1Offset, vhdxHeader2Offset} {
+		if !hasMagic(r, offset, vhdxHeaderMagic) {
+			continue
+		}
+		header, err := readAt(r, offset, 64)
+		if err != nil {
+			return err
+		}
+		if header == nil {
+			continue
+		}
+		sequence := binary.LittleEndian.Uint64(header[8:])
+		if current == nil || sequence > currentSequence {
+			current = header
+			currentSequence = sequence
+		}
+	}
+	if current == nil {
+		return fmt.Errorf("missing VHDX header")
+	}
+	logGUID := current[48:64]
+	if !bytes.Equal(logGUID, make([]byte, 16)) {
+		return fmt.Errorf("the VHDX image has a pending log, it must be opened with Hyper-V first")
+	}
+	return nil
+}
+
+func (disk *vhdxDisk) Size() int64 {
+	return disk.size
+}
+
+func (disk *vhdxDisk) ReadAt(p []byte, off int64) (int, error) {
+	return readBlocks(p, off, disk.size, disk.blockSize, func(p []byte, off int64) error {
+		block := off / disk.blockSize
+		entry := disk.bat[block+block/disk.chunkRatio]
+		switch entry & 7 {
+		case vhdxBlockNotPresent, vhdxBlockUndefined, vhdxBlockZero, vhdxBlockUnmapped:
+			clear(p)
+			return nil
+		case vhdxBlockFullyPresent:
+			fileOffset := int64(entry>>20) * 1024 * 1024
+			_, err := disk.file.ReadAt(p, fileOffset+off%disk.blockSize)
+			return err
+		default:
+			return fmt.Errorf("unsupported VHDX block state %d", entry&7)
+		}
+	})
+}
+
+func (disk *vhdxDisk) Close() error {
+	return disk.file.Close()
+}
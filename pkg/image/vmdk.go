@@ -0,0 +1,336 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+const (
+	// sparse extent header fields, little endian. The sizes and offsets
+	// are in sectors.
+	vmdkFlagsOffset             = 8
+	vmdkCapacityOffset          = 12
+	vmdkGrainSizeOffset         = 20
+	vmdkDescriptorOffsetOffset  = 28
+	vmdkDescriptorSizeOffset    = 36
+	vmdkNumGTEsPerGTOffset      = 44
+	vmdkGDOffsetOffset          = 56
+	vmdkCompressAlgorithmOffset = 77
+	vmdkHeaderSize              = 512
+
+	vmdkCompressedFlag     = 1 << 16
+	vmdkCompressionDeflate = 1
+	// the grain directory of streamOptimized images is at the location
+	// given by the footer at the end of the file
+	vmdkGDAtEnd     = 0xffffffffffffffff
+	vmdkFooterSize  = 1024
+	vmdkMarkerSize  = 12
+	vmdkNoParentCID = "ffffffff"
+
+	vmdkMaxDescriptorSize = 64 * 1024
+)
+
+var (
+	vmdkExtentRegexp    = regexp.MustCompile(`^(?:RW|RDONLY|NOACCESS)\s+(\d+)\s+(\w+)(?:\s+"([^"]*)"(?:\s+(\d+))?)?`)
+	vmdkParentCIDRegexp = regexp.MustCompile(`^parentCID\s*=\s*(\w+)`)
+)
+
+// vmdkExtent is an extent listed in a VMDK descriptor.
+type vmdkExtent struct {
+	sectors uint64
+	// kind is FLAT, SPARSE, ZERO, VMFS, ...
+	kind string
+	file string
+	// offset is the start of the extent in file for FLAT extents
+	offset uint64
+}
+
+type vmdkDescriptor struct {
+	parentCID string
+	extents   []vmdkExtent
+}
+
+// readVMDKDescriptor parses the VMDK text descriptor of at most size bytes at
+// off.
+func readVMDKDescriptor(r io.ReaderAt, off int64, size int64) (*vmdkDescriptor, error) {
+	descriptor := &vmdkDescriptor{parentCID: vmdkNoParentCID}
+	scanner := bufio.NewScanner(io.NewSectionReader(r, off, size))
+	for scanner.Scan() {
+		// embedded descriptors are padded with NUL bytes
+		line := string(bytes.TrimRight(scanner.Bytes(), "\x00"))
+		if matches := vmdkParentCIDRegexp.FindStringSubmatch(line); matches != nil {
+			descriptor.parentCID = matches[1]
+			continue
+		}
+		matches := vmdkExtentRegexp.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+		extent := vmdkExtent{kind: matches[2], file: matches[3]}
+		var err error
+		extent.sectors, err = strconv.ParseUint(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VMDK extent size: %w", err)
+		}
+		if matches[4] != "" {
+			extent.offset, err = strconv.ParseUint(matches[4], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid VMDK extent offset: %w", err)
+			}
+		}
+		descriptor.extents = append(descriptor.extents, extent)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return descriptor, nil
+}
+
+// capacity is the number of sectors of the disk described by descriptor.
+func (descriptor *vmdkDescriptor) capacity() uint64 {
+	var capacity uint64
+	for _, extent := range descriptor.extents {
+		capacity += extent.sectors
+	}
+	return capacity
+}
+
+func (descriptor *vmdkDescriptor) checkParent() error {
+	if descriptor.parentCID != vmdkNoParentCID {
+		return fmt.Errorf("VMDK delta links are not supported")
+	}
+	return nil
+}
+
+// openVMDK opens a monolithic sparse VMDK image, or a VMDK descriptor
+// referencing extent files in dir.
+func openVMDK(file *os.File, dir string) (disk, error) {
+	if hasMagic(file, 0, vmdkMagic) {
+		return openVMDKSparse(file)
+	}
+
+	descriptor, err := readVMDKDescriptor(file, 0, vmdkMaxDescriptorSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := descriptor.checkParent(); err != nil {
+		return nil, err
+	}
+	parts := []disk{}
+	closeParts := func() {
+		for _, part := range parts {
+			part.Close()
+		}
+	}
+	for _, extent := range descriptor.extents {
+		part, err := openVMDKExtent(extent, dir)
+		if err != nil {
+			closeParts()
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	// the extents have their own files
+	file.Close()
+
+	return &concatDisk{parts: parts}, nil
+}
+
+func openVMDKExtent(extent vmdkExtent, dir string) (disk, error) {
+	size := int64(extent.sectors * sectorSize)
+	if extent.kind == "ZERO" {
+		return &zeroDisk{size: size}, nil
+	}
+	path := extent.file
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	switch extent.kind {
+	case "FLAT", "VMFS":
+		return &rawDisk{file: file, offset: int64(extent.offset * sectorSize), size: size}, nil
+	case "SPARSE":
+		sparse, err := openVMDKSparse(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to open extent %s: %w", path, err)
+		}
+		if sparse.size != size {
+			sparse.Close()
+			return nil, fmt.Errorf("the size of extent %s does not match the descriptor", path)
+		}
+		return sparse, nil
+	default:
+		file.Close()
+		return nil, fmt.Errorf("unsupported VMDK extent type: %s", extent.kind)
+	}
+}
+
+// vmdkSparseDisk reads the guest data of hosted sparse extents, used by
+// monolithicSparse, twoGbMaxExtentSparse and streamOptimized images.
+type vmdkSparseDisk struct {
+	file         *os.File
+	size         int64
+	grainSize    int64
+	numGTEsPerGT int64
+	gd           []uint32
+	compressed   bool
+
+	// last grain table and compressed grain which were read
+	gtSector    uint32
+	gt          []uint32
+	grainSector uint32
+	grain       []byte
+}
+
+func openVMDKSparse(file *os.File) (*vmdkSparseDisk, error) {
+	header := make([]byte, vmdkHeaderSize)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("failed to read the VMDK header: %w", err)
+	}
+	gdOffset := binary.LittleEndian.Uint64(header[vmdkGDOffsetOffset:])
+	if gdOffset == vmdkGDAtEnd {
+		stat, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := file.ReadAt(header, stat.Size()-vmdkFooterSize); err != nil {
+			return nil, fmt.Errorf("failed to read the VMDK footer: %w", err)
+		}
+		if string(header[:len(vmdkMagic)]) != vmdkMagic {
+			return nil, fmt.Errorf("invalid VMDK footer")
+		}
+		gdOffset = binary.LittleEndian.Uint64(header[vmdkGDOffsetOffset:])
+	}
+
+	flags := binary.LittleEndian.Uint32(header[vmdkFlagsOffset:])
+	capacity := binary.LittleEndian.Uint64(header[vmdkCapacityOffset:])
+	grainSectors := binary.LittleEndian.Uint64(header[vmdkGrainSizeOffset:])
+	disk := &vmdkSparseDisk{
+		file:         file,
+		size:         int64(capacity * sectorSize),
+		grainSize:    int64(grainSectors * sectorSize),
+		numGTEsPerGT: int64(binary.LittleEndian.Uint32(header[vmdkNumGTEsPerGTOffset:])),
+	}
+	if flags&vmdkCompressedFlag != 0 {
+		algorithm := binary.LittleEndian.Uint16(header[vmdkCompressAlgorithmOffset:])
+		if algorithm != vmdkCompressionDeflate {
+			return nil, fmt.Errorf("unsupported VMDK compression algorithm %d", algorithm)
+		}
+		disk.compressed = true
+	}
+	if grainSectors == 0 || grainSectors&(grainSectors-1) != 0 || disk.numGTEsPerGT == 0 {
+		return nil, fmt.Errorf("invalid VMDK grain size %d or grain table size %d", grainSectors, disk.numGTEsPerGT)
+	}
+
+	descriptorOffset := binary.LittleEndian.Uint64(header[vmdkDescriptorOffsetOffset:])
+	descriptorSize := binary.LittleEndian.Uint64(header[vmdkDescriptorSizeOffset:])
+	if descriptorOffset != 0 {
+		descriptor, err := readVMDKDescriptor(file, int64(descriptorOffset*sectorSize), int64(descriptorSize*sectorSize))
+		if err != nil {
+			return nil, err
+		}
+		if err := descriptor.checkParent(); err != nil {
+			return nil, err
+		}
+	}
+
+	gtCoverage := disk.grainSize * disk.numGTEsPerGT
+	disk.gd = make([]uint32, (disk.size+gtCoverage-1)/gtCoverage)
+	if err := binary.Read(io.NewSectionReader(file, int64(gdOffset*sectorSize), int64(len(disk.gd))*4), binary.LittleEndian, disk.gd); err != nil {
+		return nil, fmt.Errorf("failed to read the VMDK grain directory: %w", err)
+	}
+
+	return disk, nil
+}
+
+func (disk *vmdkSparseDisk) Size() int64 {
+	return disk.size
+}
+
+func (disk *vmdkSparseDisk) ReadAt(p []byte, off int64) (int, error) {
+	return readBlocks(p, off, disk.size, disk.grainSize, disk.readGrain)
+}
+
+// readGrain fills p with the data at off, p is part of a single grain.
+func (disk *vmdkSparseDisk) readGrain(p []byte, off int64) error {
+	grainSector, err := disk.findGrain(off)
+	if err != nil {
+		return err
+	}
+	// 0 is used for unallocated grains, and 1 for zeroed grains
+	if grainSector <= 1 {
+		clear(p)
+		return nil
+	}
+	inGrain := off % disk.grainSize
+	if !disk.compressed {
+		_, err := disk.file.ReadAt(p, int64(grainSector)*sectorSize+inGrain)
+		return err
+	}
+	grain, err := disk.readCompressedGrain(grainSector)
+	if err != nil {
+		return err
+	}
+	copy(p, grain[inGrain:])
+	return nil
+}
+
+// findGrain returns the sector of the grain containing off.
+func (disk *vmdkSparseDisk) findGrain(off int64) (uint32, error) {
+	grain := off / disk.grainSize
+	gtSector := disk.gd[grain/disk.numGTEsPerGT]
+	if gtSector == 0 {
+		return 0, nil
+	}
+	if gtSector != disk.gtSector {
+		gt := make([]uint32, disk.numGTEsPerGT)
+		if err := binary.Read(io.NewSectionReader(disk.file, int64(gtSector)*sectorSize, disk.numGTEsPerGT*4), binary.LittleEndian, gt); err != nil {
+			return 0, fmt.Errorf("failed to read VMDK grain table: %w", err)
+		}
+		disk.gtSector = gtSector
+		disk.gt = gt
+	}
+	return disk.gt[grain%disk.numGTEsPerGT], nil
+}
+
+// readCompressedGrain returns the uncompressed data of the grain stored at
+// grainSector, after a marker giving the size of the compressed data.
+func (disk *vmdkSparseDisk) readCompressedGrain(grainSector uint32) ([]byte, error) {
+	if disk.grain != nil && grainSector == disk.grainSector {
+		return disk.grain, nil
+	}
+	marker := make([]byte, vmdkMarkerSize)
+	if _, err := disk.file.ReadAt(marker, int64(grainSector)*sectorSize); err != nil {
+		return nil, fmt.Errorf("failed to read VMDK grain marker: %w", err)
+	}
+	compressed := io.NewSectionReader(disk.file, int64(grainSector)*sectorSize+vmdkMarkerSize, int64(binary.LittleEndian.Uint32(marker[8:])))
+	reader, err := zlib.NewReader(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress VMDK grain: %w", err)
+	}
+	grain := make([]byte, disk.grainSize)
+	// the last grain of the disk can be smaller than the grain size
+	if _, err := io.ReadFull(reader, grain); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("failed to decompress VMDK grain: %w", err)
+	}
+	disk.grainSector = grainSector
+	disk.grain = grain
+	return grain, nil
+}
+
+func (disk *vmdkSparseDisk) Close() error {
+	return disk.file.Close()
+}
@@ -0,0 +1,104 @@
+package launcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+// State is the state of the virtual machine returned by the GET /vm/state
+// endpoint of the vfkit RESTful service.
+type State struct {
+	// State is the state of the virtual machine, such as
+	// 'VirtualMachineStateRunning'
+	State       string `json:"state"`
+	CanStart    bool   `json:"canStart"`
+	CanPause    bool   `json:"canPause"`
+	CanResume   bool   `json:"canResume"`
+	CanStop     bool   `json:"canStop"`
+	CanHardStop bool   `json:"canHardStop"`
+}
+
+// newRESTClient returns an HTTP client sending all its requests to the unix
+// socket socketPath.
+func newRESTClient(socketPath string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+}
+
+// request sends a request to the vfkit RESTful service. body is sent as JSON
+// if it's not nil, and the JSON response is decoded in response if it's not
+// nil.
+func (vm *VirtualMachine) request(ctx context.Context, method string, path string, body any, response any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	// the host is ignored as requests are sent to the unix socket
+	req, err := http.NewRequestWithContext(ctx, method, "http://vfkit"+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := vm.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var errResponse struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&errResponse); err == nil && errResponse.Error != "" {
+			return fmt.Errorf("%s %s failed: %s", method, path, errResponse.Error)
+		}
+		return fmt.Errorf("%s %s failed: %s", method, path, resp.Status)
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// State returns the state of the virtual machine.
+func (vm *VirtualMachine) State(ctx context.Context) (*State, error) {
+	var state State
+	if err := vm.request(ctx, http.MethodGet, "/vm/state", nil, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Inspect returns the configuration of the running virtual machine.
+func (vm *VirtualMachine) Inspect(ctx context.Context) (*config.VirtualMachine, error) {
+	var vmConfig config.VirtualMachine
+	if err := vm.request(ctx, http.MethodGet, "/vm/inspect", nil, &vmConfig); err != nil {
+		return nil, err
+	}
+	return &vmConfig, nil
+}
+
+// changeState asks vfkit to change the state of the virtual machine.
+func (vm *VirtualMachine) changeState(ctx context.Context, state define.StateChange) error {
+	return vm.request(ctx, http.MethodPost, "/vm/state", define.VMState{State: string(state)}, nil)
+}
@@ -0,0 +1,245 @@
+// Package launcher starts vfkit processes for a config.VirtualMachine, and
+// controls the running virtual machines through the vfkit RESTful service.
+package launcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/rest/define"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultStartTimeout = 30 * time.Second
+	// cancelWaitDelay is the time given to vfkit to stop the virtual
+	// machine when the context passed to Start is done, before it's killed
+	cancelWaitDelay = 10 * time.Second
+	// pollInterval is the delay between two checks of the RESTful service
+	// while waiting for vfkit to start
+	pollInterval = 100 * time.Millisecond
+)
+
+// Options configures how vfkit is started.
+type Options struct {
+	// VfkitPath is the path to the vfkit binary. vfkit is searched in $PATH
+	// when it's empty.
+	VfkitPath string
+	// Version is the version of the vfkit binary, it's used to generate
+	// its command line. It's obtained by running 'vfkit --version' when
+	// it's empty.
+	Version string
+	// RESTSocketPath is the path of the unix socket used by the vfkit
+	// RESTful service. A socket in a temporary directory is used when it's
+	// empty.
+	RESTSocketPath string
+	// PidFilePath is the path of the file where vfkit writes its pid. No
+	// pid file is written when it's empty. It's removed when vfkit exits.
+	PidFilePath string
+	// ExtraArgs are added to the vfkit command line, for example
+	// []string{"--log-level", "debug"}
+	ExtraArgs []string
+	// Stdout and Stderr are the standard output and error of vfkit. The
+	// output is discarded when they are nil.
+	Stdout io.Writer
+	Stderr io.Writer
+	// StartTimeout is the maximum time to wait for the RESTful service to
+	// be ready. It's 30 seconds when it's 0.
+	StartTimeout time.Duration
+}
+
+// VirtualMachine is a handle on a virtual machine run by a vfkit process
+// started with Start.
+type VirtualMachine struct {
+	cmd            *exec.Cmd
+	client         *http.Client
+	restSocketPath string
+	pidFilePath    string
+	// tmpDir is the temporary directory holding the RESTful service socket,
+	// it's empty if the socket path was given in Options
+	tmpDir string
+
+	done    chan struct{}
+	waitErr error
+}
+
+// Start starts vfkit to run the virtual machine vm, and waits until its
+// RESTful service is ready. The extra files needed by vm, such as virtio-net
+// sockets, are passed to vfkit.
+//
+// When ctx is done, vfkit is asked to stop the virtual machine, and it's
+// killed if it's still running after a few seconds. A context which is never
+// done, such as context.Background(), must be used to keep the virtual machine
+// running after Start returns.
+func Start(ctx context.Context, vm *config.VirtualMachine, opts Options) (*VirtualMachine, error) {
+	vfkitPath := opts.VfkitPath
+	if vfkitPath == "" {
+		var err error
+		vfkitPath, err = exec.LookPath("vfkit")
+		if err != nil {
+			return nil, err
+		}
+	}
+	vmCmd, err := vm.CmdFor(vfkitPath, opts.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	launchedVM := &VirtualMachine{
+		restSocketPath: opts.RESTSocketPath,
+		pidFilePath:    opts.PidFilePath,
+		done:           make(chan struct{}),
+	}
+	if launchedVM.restSocketPath == "" {
+		launchedVM.tmpDir, err = os.MkdirTemp("", "vfkit-")
+		if err != nil {
+			return nil, err
+		}
+		launchedVM.restSocketPath = filepath.Join(launchedVM.tmpDir, "rest.sock")
+	}
+	launchedVM.client = newRESTClient(launchedVM.restSocketPath)
+
+	args := append(vmCmd.Args[1:], "--restful-uri", "unix://"+launchedVM.restSocketPath)
+	if opts.PidFilePath != "" {
+		args = append(args, "--pidfile", opts.PidFilePath)
+	}
+	args = append(args, opts.ExtraArgs...)
+
+	cmd := exec.CommandContext(ctx, vfkitPath, args...)
+	cmd.ExtraFiles = vmCmd.ExtraFiles
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	// vfkit stops the virtual machine when it receives SIGTERM
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = cancelWaitDelay
+	launchedVM.cmd = cmd
+
+	log.Debugf("starting %s %v", vfkitPath, args)
+	if err := cmd.Start(); err != nil {
+		launchedVM.cleanup()
+		return nil, err
+	}
+	go func() {
+		launchedVM.waitErr = cmd.Wait()
+		launchedVM.cleanup()
+		close(launchedVM.done)
+	}()
+
+	startTimeout := opts.StartTimeout
+	if startTimeout == 0 {
+		startTimeout = defaultStartTimeout
+	}
+	if err := launchedVM.waitForRESTService(ctx, startTimeout); err != nil {
+		_ = launchedVM.Kill()
+		<-launchedVM.done
+		return nil, err
+	}
+
+	return launchedVM, nil
+}
+
+// waitForRESTService waits until the RESTful service of vfkit answers
+// requests.
+func (vm *VirtualMachine) waitForRESTService(ctx context.Context, timeout time.Duration) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+	for {
+		if _, err := vm.State(ctx); err == nil {
+			return nil
+		}
+		select {
+		case <-vm.done:
+			return fmt.Errorf("vfkit exited before its RESTful service was ready: %w", vm.exitError())
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return fmt.Errorf("timeout waiting for the vfkit RESTful service on %s", vm.restSocketPath)
+		case <-ticker.C:
+		}
+	}
+}
+
+// cleanup removes the files created for the vfkit process once it exited.
+func (vm *VirtualMachine) cleanup() {
+	if vm.tmpDir != "" {
+		if err := os.RemoveAll(vm.tmpDir); err != nil {
+			log.Warnf("failed to remove %s: %v", vm.tmpDir, err)
+		}
+	}
+	if vm.pidFilePath != "" {
+		if err := os.Remove(vm.pidFilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Warnf("failed to remove %s: %v", vm.pidFilePath, err)
+		}
+	}
+}
+
+// exitError returns the error of the vfkit process once it exited, or an
+// error saying it exited successfully.
+func (vm *VirtualMachine) exitError() error {
+	if vm.waitErr != nil {
+		return vm.waitErr
+	}
+	return errors.New("exit status 0")
+}
+
+// Pid returns the pid of the vfkit process.
+func (vm *VirtualMachine) Pid() int {
+	return vm.cmd.Process.Pid
+}
+
+// RESTSocketPath returns the path of the unix socket of the vfkit RESTful
+// service.
+func (vm *VirtualMachine) RESTSocketPath() string {
+	return vm.restSocketPath
+}
+
+// Wait waits until the vfkit process exits, and returns its exit error.
+func (vm *VirtualMachine) Wait() error {
+	<-vm.done
+	return vm.waitErr
+}
+
+// Done returns a channel which is closed when the vfkit process exits.
+func (vm *VirtualMachine) Done() <-chan struct{} {
+	return vm.done
+}
+
+// Stop asks the guest to shut down, and waits until vfkit exits or ctx is
+// done. Kill can be used if the guest does not shut down in time.
+func (vm *VirtualMachine) Stop(ctx context.Context) error {
+	select {
+	case <-vm.done:
+		return nil
+	default:
+	}
+	if err := vm.changeState(ctx, define.Stop); err != nil {
+		return err
+	}
+	select {
+	case <-vm.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Kill kills the vfkit process without stopping the virtual machine.
+func (vm *VirtualMachine) Kill() error {
+	err := vm.cmd.Process.Kill()
+	if errors.Is(err, os.ErrProcessDone) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package launcher
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buildFakeVfkit(t *testing.T) string {
+	out := filepath.Join(t.TempDir(), "vfkit")
+	cmd := exec.Command("go", "build", "-o", out, "./testdata/vfkit")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to build the fake vfkit: %v", err)
+	}
+	return out
+}
+
+func testVM() *config.VirtualMachine {
+	return config.NewVirtualMachine(2, 1024, config.NewEFIBootloader("/efi-store", false))
+}
+
+func TestStartStop(t *testing.T) {
+	vfkitPath := buildFakeVfkit(t)
+	pidFile := filepath.Join(t.TempDir(), "vfkit.pid")
+
+	vm, err := Start(context.Background(), testVM(), Options{VfkitPath: vfkitPath, PidFilePath: pidFile})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = vm.Kill() })
+
+	pid, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(vm.Pid()), string(pid))
+	assert.FileExists(t, vm.RESTSocketPath())
+
+	state, err := vm.State(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &State{State: "VirtualMachineStateRunning", CanStop: true}, state)
+
+	vmConfig, err := vm.Inspect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint(2), vmConfig.Vcpus)
+	assert.Equal(t, testVM().Memory, vmConfig.Memory)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = vm.Stop(ctx)
+	require.NoError(t, err)
+	require.NoError(t, vm.Wait())
+	assert.NoFileExists(t, pidFile)
+	assert.NoDirExists(t, filepath.Dir(vm.RESTSocketPath()))
+
+	_, err = vm.State(context.Background())
+	require.Error(t, err)
+}
+
+func TestInspectSocketNetwork(t *testing.T) {
+	vfkitPath := buildFakeVfkit(t)
+	socket, err := os.CreateTemp(t.TempDir(), "socket")
+	require.NoError(t, err)
+	defer socket.Close()
+
+	vmConfig := testVM()
+	net, err := config.VirtioNetNew("00:11:22:33:44:55")
+	require.NoError(t, err)
+	net.SetSocket(socket)
+	require.NoError(t, vmConfig.AddDevice(net))
+	vm, err := Start(context.Background(), vmConfig, Options{VfkitPath: vfkitPath})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = vm.Kill() })
+
+	// the 'fd' of the virtio-net device is ignored
+	inspected, err := vm.Inspect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, inspected.Devices, 1)
+	require.IsType(t, &config.VirtioNet{}, inspected.Devices[0])
+	assert.Equal(t, "00:11:22:33:44:55", inspected.Devices[0].(*config.VirtioNet).MacAddress.String())
+	assert.Nil(t, inspected.Devices[0].(*config.VirtioNet).Socket)
+}
+
+func TestStartCancel(t *testing.T) {
+	vfkitPath := buildFakeVfkit(t)
+	socketPath := filepath.Join(t.TempDir(), "rest.sock")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	vm, err := Start(ctx, testVM(), Options{VfkitPath: vfkitPath, Version: "v0.7.0", RESTSocketPath: socketPath})
+	require.NoError(t, err)
+	assert.Equal(t, socketPath, vm.RESTSocketPath())
+
+	cancel()
+	select {
+	case <-vm.Done():
+	case <-time.After(10 * time.Second):
+		_ = vm.Kill()
+		t.Fatal("vfkit was not stopped after cancelling the context")
+	}
+	require.ErrorIs(t, vm.Wait(), context.Canceled)
+}
+
+func TestStartFailure(t *testing.T) {
+	vfkitPath := buildFakeVfkit(t)
+	t.Setenv("FAKE_VFKIT_FAIL", "1")
+
+	_, err := Start(context.Background(), testVM(), Options{VfkitPath: vfkitPath, Version: "v0.7.0"})
+	require.EqualError(t, err, "vfkit exited before its RESTful service was ready: exit status 1")
+
+	_, err = Start(context.Background(), testVM(), Options{VfkitPath: vfkitPath, Version: "unknown"})
+	require.EqualError(t, err, "invalid vfkit version: unknown")
+}
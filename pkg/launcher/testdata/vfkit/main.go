@@ -0,0 +1,87 @@
+// fake vfkit binary serving the RESTful endpoints used by the launcher
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+func argValue(name string) string {
+	if values := argValues(name); len(values) != 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func argValues(name string) []string {
+	values := []string{}
+	for i, arg := range os.Args[:len(os.Args)-1] {
+		if arg == name {
+			values = append(values, os.Args[i+1])
+		}
+	}
+	return values
+}
+
+func main() {
+	if len(os.Args) == 2 && os.Args[1] == "--version" {
+		fmt.Println("vfkit version: v0.7.0")
+		return
+	}
+	if os.Getenv("FAKE_VFKIT_FAIL") != "" {
+		fmt.Fprintln(os.Stderr, "failing as requested")
+		os.Exit(1)
+	}
+
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGTERM)
+	go func() {
+		<-signalCh
+		os.Exit(0)
+	}()
+
+	if pidFile := argValue("--pidfile"); pidFile != "" {
+		if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0o600); err != nil {
+			panic(err)
+		}
+	}
+	cpus, _ := strconv.ParseUint(argValue("--cpus"), 10, 32)
+	memory, _ := strconv.ParseUint(argValue("--memory"), 10, 64)
+	vm := config.NewVirtualMachine(uint(cpus), memory, config.NewEFIBootloader("/efi-store", false))
+	if err := vm.AddDevicesFromCmdLine(argValues("--device")); err != nil {
+		panic(err)
+	}
+
+	http.HandleFunc("GET /vm/state", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"state": "VirtualMachineStateRunning", "canStop": true})
+	})
+	http.HandleFunc("POST /vm/state", func(w http.ResponseWriter, r *http.Request) {
+		var state define.VMState
+		if err := json.NewDecoder(r.Body).Decode(&state); err != nil || state.State != string(define.Stop) {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "unexpected state"})
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		go os.Exit(0)
+	})
+	http.HandleFunc("GET /vm/inspect", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(vm)
+	})
+
+	listener, err := net.Listen("unix", strings.TrimPrefix(argValue("--restful-uri"), "unix://"))
+	if err != nil {
+		panic(err)
+	}
+	panic(http.Serve(listener, nil))
+}
@@ -0,0 +1,34 @@
+package rest
+
+import (
+	"github.com/crc-org/vfkit/pkg/cmdline"
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+// NewCapabilities returns the capabilities document of this vfkit binary
+// running on a host whose features are described by host, and serving the
+// restful endpoints listed in endpoints.
+func NewCapabilities(host define.HostCapabilities, endpoints []string) (*define.Capabilities, error) {
+	caps := define.Capabilities{
+		Version:       cmdline.Version(),
+		Devices:       []define.DeviceCapabilities{},
+		Bootloaders:   config.BootloaderTypes(),
+		RESTEndpoints: endpoints,
+		Host:          host,
+	}
+	for _, devType := range config.DeviceTypes() {
+		opts, err := config.DeviceOptions(devType.Name)
+		if err != nil {
+			return nil, err
+		}
+		caps.Devices = append(caps.Devices, define.DeviceCapabilities{
+			Name:        devType.Name,
+			Kind:        devType.Kind,
+			Description: devType.Description,
+			Options:     opts,
+		})
+	}
+
+	return &caps, nil
+}
@@ -0,0 +1,53 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/crc-org/vfkit/pkg/cmdline"
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/rest/define"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testInspector struct{}
+
+func (testInspector) Inspect(_ *gin.Context)    {}
+func (testInspector) GetDevice(_ *gin.Context)  {}
+func (testInspector) GetVMState(_ *gin.Context) {}
+func (testInspector) SetVMState(_ *gin.Context) {}
+func (testInspector) HostCapabilities() define.HostCapabilities {
+	return define.HostCapabilities{Arch: "arm64", Rosetta: define.RosettaInstalled, MacOSGuests: true}
+}
+
+func TestCapabilitiesEndpoint(t *testing.T) {
+	srv, err := NewServer(testInspector{}, testInspector{}, "tcp://localhost:8081")
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/capabilities", nil)
+	srv.router.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var caps define.Capabilities
+	err = json.Unmarshal(recorder.Body.Bytes(), &caps)
+	require.NoError(t, err)
+	assert.Equal(t, cmdline.Version(), caps.Version)
+	assert.Equal(t, testInspector{}.HostCapabilities(), caps.Host)
+	assert.Equal(t, []string{"efi", "linux", "macos"}, caps.Bootloaders)
+	assert.ElementsMatch(t, []string{"GET /capabilities", "GET /vm/state", "POST /vm/state", "GET /vm/inspect", "GET /vm/devices/:id"}, caps.RESTEndpoints)
+	require.Len(t, caps.Devices, len(config.DeviceTypes()))
+	for _, dev := range caps.Devices {
+		if dev.Name != "virtio-vsock" {
+			continue
+		}
+		assert.Equal(t, "virtiosock", dev.Kind)
+		expected, err := config.DeviceOptions("virtio-vsock")
+		require.NoError(t, err)
+		assert.Equal(t, expected, dev.Options)
+	}
+}
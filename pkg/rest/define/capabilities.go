@@ -0,0 +1,44 @@
+package define
+
+import "github.com/crc-org/vfkit/pkg/config"
+
+// Capabilities describes the features supported by a vfkit binary and by the
+// host it runs on. It's printed by 'vfkit capabilities' and returned by the
+// GET /capabilities endpoint of the RESTful service.
+type Capabilities struct {
+	// Version is the version of the vfkit binary
+	Version     string               `json:"version"`
+	Devices     []DeviceCapabilities `json:"devices"`
+	Bootloaders []string             `json:"bootloaders"`
+	// RESTEndpoints are the endpoints of the RESTful service, such as
+	// 'GET /vm/state'
+	RESTEndpoints []string         `json:"restEndpoints"`
+	Host          HostCapabilities `json:"host"`
+}
+
+// DeviceCapabilities describes a device type which can be used with --device.
+type DeviceCapabilities struct {
+	Name        string                `json:"name"`
+	Kind        string                `json:"kind"`
+	Description string                `json:"description"`
+	Options     []config.DeviceOption `json:"options"`
+}
+
+// RosettaAvailability describes whether Rosetta can be used by Linux virtual
+// machines.
+type RosettaAvailability string
+
+const (
+	RosettaUnsupported  RosettaAvailability = "unsupported"
+	RosettaNotInstalled RosettaAvailability = "notInstalled"
+	RosettaInstalled    RosettaAvailability = "installed"
+)
+
+// HostCapabilities describes the virtualization features of the host.
+type HostCapabilities struct {
+	// Arch is the host architecture, such as 'arm64'
+	Arch                 string              `json:"arch"`
+	NestedVirtualization bool                `json:"nestedVirtualization"`
+	Rosetta              RosettaAvailability `json:"rosetta"`
+	MacOSGuests          bool                `json:"macOSGuests"`
+}
@@ -3,10 +3,12 @@ package rest
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"syscall"
 
+	"github.com/crc-org/vfkit/pkg/rest/define"
 	"github.com/crc-org/vfkit/pkg/util"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -96,18 +98,72 @@ func NewServer(inspector VirtualMachineInspector, stateHandler VirtualMachineSta
 		router:   r,
 		Endpoint: ep,
 	}
+	registerRoutes(r, inspector, stateHandler)
+	return &s, nil
+}
 
-	// Handlers for the restful service.  This is where endpoints are defined.
+// registerRoutes adds the handlers of the restful service to r. This is where
+// endpoints are defined.
+func registerRoutes(r *gin.Engine, inspector VirtualMachineInspector, stateHandler VirtualMachineStateHandler) {
+	if capsInspector, ok := inspector.(CapabilitiesInspector); ok {
+		r.GET("/capabilities", getCapabilities(capsInspector, r))
+	}
 	r.GET("/vm/state", stateHandler.GetVMState)
 	r.POST("/vm/state", stateHandler.SetVMState)
 	r.GET("/vm/inspect", inspector.Inspect)
-	return &s, nil
+	if devInspector, ok := inspector.(DeviceInspector); ok {
+		r.GET("/vm/devices/:id", devInspector.GetDevice)
+	}
+}
+
+// Endpoints returns the endpoints served by a restful service using inspector
+// and stateHandler, in the 'METHOD /path' format.
+func Endpoints(inspector VirtualMachineInspector, stateHandler VirtualMachineStateHandler) []string {
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	registerRoutes(r, inspector, stateHandler)
+	return routeEndpoints(r)
+}
+
+func routeEndpoints(r *gin.Engine) []string {
+	endpoints := []string{}
+	for _, route := range r.Routes() {
+		endpoints = append(endpoints, route.Method+" "+route.Path)
+	}
+	return endpoints
 }
 
 type VirtualMachineInspector interface {
 	Inspect(c *gin.Context)
 }
 
+// DeviceInspector can be implemented by a VirtualMachineInspector to serve the
+// GET /vm/devices/:id endpoint, which returns the configuration of a single
+// device.
+type DeviceInspector interface {
+	GetDevice(c *gin.Context)
+}
+
+// CapabilitiesInspector can be implemented by a VirtualMachineInspector to
+// serve the GET /capabilities endpoint.
+type CapabilitiesInspector interface {
+	// HostCapabilities returns the virtualization features of the host
+	HostCapabilities() define.HostCapabilities
+}
+
+// getCapabilities returns the handler of the GET /capabilities endpoint, the
+// endpoints it lists are the routes registered in r.
+func getCapabilities(inspector CapabilitiesInspector, r *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caps, err := NewCapabilities(inspector.HostCapabilities(), routeEndpoints(r))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, caps)
+	}
+}
+
 type VirtualMachineStateHandler interface {
 	GetVMState(c *gin.Context)
 	SetVMState(c *gin.Context)
@@ -5,9 +5,39 @@ import (
 	"net/url"
 	"testing"
 
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// inspectOnlyInspector only implements the mandatory inspector methods
+type inspectOnlyInspector struct{}
+
+func (inspectOnlyInspector) Inspect(_ *gin.Context) {}
+
+func TestNewServerOptionalRoutes(t *testing.T) {
+	hasRoute := func(srv *VFKitService, path string) bool {
+		for _, route := range srv.router.Routes() {
+			if route.Path == path {
+				return true
+			}
+		}
+		return false
+	}
+
+	srv, err := NewServer(testInspector{}, testInspector{}, "tcp://localhost:8081")
+	require.NoError(t, err)
+	assert.True(t, hasRoute(srv, "/vm/devices/:id"))
+	assert.True(t, hasRoute(srv, "/capabilities"))
+
+	srv, err = NewServer(inspectOnlyInspector{}, testInspector{}, "tcp://localhost:8081")
+	require.NoError(t, err)
+	assert.True(t, hasRoute(srv, "/vm/inspect"))
+	assert.False(t, hasRoute(srv, "/vm/devices/:id"))
+	assert.False(t, hasRoute(srv, "/capabilities"))
+	assert.Equal(t, []string{"GET /vm/state", "GET /vm/inspect", "POST /vm/state"}, Endpoints(inspectOnlyInspector{}, testInspector{}))
+}
+
 func TestParseRestfulURI(t *testing.T) {
 	type args struct {
 		inputURI string
@@ -1,6 +1,7 @@
 package rest
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/crc-org/vfkit/pkg/rest/define"
@@ -23,6 +24,23 @@ func (vm *VzVirtualMachine) Inspect(c *gin.Context) {
 	c.JSON(http.StatusOK, vm.Config())
 }
 
+// GetDevice returns the configuration of the device with the ID given in the
+// request path
+func (vm *VzVirtualMachine) GetDevice(c *gin.Context) {
+	id := c.Param("id")
+	dev := vm.Config().DeviceByID(id)
+	if dev == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no device with ID '%s'", id)})
+		return
+	}
+	c.JSON(http.StatusOK, dev)
+}
+
+// HostCapabilities returns the virtualization features of the host
+func (vm *VzVirtualMachine) HostCapabilities() define.HostCapabilities {
+	return vf.HostCapabilities()
+}
+
 // GetVMState retrieves the current vm state
 func (vm *VzVirtualMachine) GetVMState(c *gin.Context) {
 	current := vm.State()
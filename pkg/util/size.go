@@ -0,0 +1,65 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/containers/common/pkg/strongunits"
+)
+
+// sizeUnits associates the lowercase size suffixes with their value in bytes.
+// All units are powers of 1024, 'G', 'GB' and 'GiB' all mean gibibytes.
+var sizeUnits = map[string]uint64{
+	"b": 1,
+	"k": 1 << 10, "kb": 1 << 10, "kib": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20, "mib": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30, "gib": 1 << 30,
+	"t": 1 << 40, "tb": 1 << 40, "tib": 1 << 40,
+}
+
+// ParseSize parses a size such as '4GiB', '2G' or '512 MiB'. Unit suffixes are
+// case-insensitive and are all powers of 1024. A size without unit is
+// multiplied by defaultUnit.
+func ParseSize(str string, defaultUnit strongunits.StorageUnits) (strongunits.B, error) {
+	trimmed := strings.TrimSpace(str)
+	numberEnd := strings.IndexFunc(trimmed, func(r rune) bool { return !unicode.IsDigit(r) })
+	if numberEnd == -1 {
+		numberEnd = len(trimmed)
+	}
+	number, err := strconv.ParseUint(trimmed[:numberEnd], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %s", str)
+	}
+
+	unit := uint64(defaultUnit.ToBytes())
+	if suffix := strings.TrimSpace(trimmed[numberEnd:]); suffix != "" {
+		var ok bool
+		unit, ok = sizeUnits[strings.ToLower(suffix)]
+		if !ok {
+			return 0, fmt.Errorf("invalid size: %s (unknown unit %s)", str, suffix)
+		}
+	}
+
+	hi, size := bits.Mul64(number, unit)
+	if hi != 0 || size > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size: %s (too large)", str)
+	}
+
+	return strongunits.B(size), nil
+}
+
+// FormatSize returns a representation of size which can be parsed by
+// ParseSize, using the largest binary unit which represents it exactly.
+func FormatSize(size strongunits.B) string {
+	for _, unit := range []string{"TiB", "GiB", "MiB", "KiB"} {
+		unitSize := sizeUnits[strings.ToLower(unit)]
+		if size != 0 && uint64(size)%unitSize == 0 {
+			return fmt.Sprintf("%d%s", uint64(size)/unitSize, unit)
+		}
+	}
+	return fmt.Sprintf("%dB", uint64(size))
+}
@@ -0,0 +1,52 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/containers/common/pkg/strongunits"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]strongunits.B{
+		"2048":    strongunits.MiB(2048).ToBytes(),
+		"4GiB":    strongunits.GiB(4).ToBytes(),
+		"2G":      strongunits.GiB(2).ToBytes(),
+		"2gb":     strongunits.GiB(2).ToBytes(),
+		"512 MiB": strongunits.MiB(512).ToBytes(),
+		" 1T ":    strongunits.GiB(1024).ToBytes(),
+		"1536k":   strongunits.KiB(1536).ToBytes(),
+		"100B":    strongunits.B(100),
+		"0":       strongunits.B(0),
+	}
+	for str, expected := range tests {
+		size, err := ParseSize(str, strongunits.MiB(1))
+		require.NoError(t, err, str)
+		assert.Equal(t, expected, size, str)
+	}
+
+	for _, str := range []string{"", "GiB", "-1G", "1.5G", "4GiBs", "4 G B", "18446744073709551616", "9000000T"} {
+		_, err := ParseSize(str, strongunits.MiB(1))
+		require.Error(t, err, str)
+	}
+	_, err := ParseSize("4PiB", strongunits.B(1))
+	require.EqualError(t, err, "invalid size: 4PiB (unknown unit PiB)")
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[strongunits.B]string{
+		strongunits.GiB(4).ToBytes():    "4GiB",
+		strongunits.MiB(1536).ToBytes(): "1536MiB",
+		strongunits.KiB(1).ToBytes():    "1KiB",
+		strongunits.B(1000):             "1000B",
+		strongunits.B(0):                "0B",
+	}
+	for size, expected := range tests {
+		str := FormatSize(size)
+		assert.Equal(t, expected, str)
+		parsed, err := ParseSize(str, strongunits.MiB(1))
+		require.NoError(t, err)
+		assert.Equal(t, size, parsed)
+	}
+}
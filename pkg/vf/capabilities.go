@@ -0,0 +1,18 @@
+package vf
+
+import (
+	"runtime"
+
+	"github.com/Code-Hex/vz/v3"
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+// HostCapabilities returns the virtualization features of the host.
+func HostCapabilities() define.HostCapabilities {
+	return define.HostCapabilities{
+		Arch:                 runtime.GOARCH,
+		NestedVirtualization: vz.IsNestedVirtualizationSupported(),
+		Rosetta:              rosettaAvailability(),
+		MacOSGuests:          macOSGuestsSupported(),
+	}
+}
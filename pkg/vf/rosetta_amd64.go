@@ -2,8 +2,15 @@ package vf
 
 import (
 	"fmt"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
 )
 
+// rosettaAvailability returns whether Rosetta can be used by Linux guests
+func rosettaAvailability() define.RosettaAvailability {
+	return define.RosettaUnsupported
+}
+
 func (dev *RosettaShare) AddToVirtualMachineConfig(_ *VirtualMachineConfiguration) error {
 	return fmt.Errorf("rosetta is unsupported on non-arm64 platforms")
 }
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/Code-Hex/vz/v3"
+	"github.com/crc-org/vfkit/pkg/rest/define"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -13,6 +14,18 @@ var (
 	doInstallRosetta                       = vz.LinuxRosettaDirectoryShareInstallRosetta
 )
 
+// rosettaAvailability returns whether Rosetta can be used by Linux guests
+func rosettaAvailability() define.RosettaAvailability {
+	switch checkRosettaDirectoryShareAvailability() {
+	case vz.LinuxRosettaAvailabilityInstalled:
+		return define.RosettaInstalled
+	case vz.LinuxRosettaAvailabilityNotInstalled:
+		return define.RosettaNotInstalled
+	default:
+		return define.RosettaUnsupported
+	}
+}
+
 func (dev *RosettaShare) checkRosettaAvailability() error {
 	availability := checkRosettaDirectoryShareAvailability()
 	switch availability {
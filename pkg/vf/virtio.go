@@ -5,9 +5,11 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 
 	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/image"
 	"github.com/crc-org/vfkit/pkg/util"
 	"golang.org/x/sys/unix"
 
@@ -461,38 +463,100 @@ func ListenNetworkBlockDevices(vm *VirtualMachine) error {
 	return nil
 }
 
+// deviceConverters associates the Go type of the devices with the function
+// adding them to the virtual machine configuration.
+var deviceConverters = map[reflect.Type]func(vmConfig *VirtualMachineConfiguration, dev config.VirtioDevice) error{}
+
+// RegisterDeviceConverter registers the function used to add the devices of
+// type D to the virtual machine configuration. It's used together with
+// config.RegisterDeviceType to add new device types, and must be called from
+// an init function. The converter of a composite device can add several
+// devices to the virtual machine with AddToVirtualMachineConfig.
+func RegisterDeviceConverter[D config.VirtioDevice](convert func(vmConfig *VirtualMachineConfiguration, dev D) error) error {
+	typ := reflect.TypeFor[D]()
+	if _, ok := deviceConverters[typ]; ok {
+		return fmt.Errorf("a converter is already registered for %s", typ)
+	}
+	deviceConverters[typ] = func(vmConfig *VirtualMachineConfiguration, dev config.VirtioDevice) error {
+		return convert(vmConfig, dev.(D))
+	}
+	return nil
+}
+
+func mustRegisterDeviceConverter[D config.VirtioDevice](convert func(vmConfig *VirtualMachineConfiguration, dev D) error) {
+	if err := RegisterDeviceConverter(convert); err != nil {
+		panic(err)
+	}
+}
+
+func init() {
+	mustRegisterDeviceConverter(func(vmConfig *VirtualMachineConfiguration, dev *config.USBMassStorage) error {
+		return (*USBMassStorage)(dev).AddToVirtualMachineConfig(vmConfig)
+	})
+	mustRegisterDeviceConverter(func(vmConfig *VirtualMachineConfiguration, dev *config.VirtioBlk) error {
+		return (*VirtioBlk)(dev).AddToVirtualMachineConfig(vmConfig)
+	})
+	mustRegisterDeviceConverter(func(vmConfig *VirtualMachineConfiguration, dev *config.RosettaShare) error {
+		return (*RosettaShare)(dev).AddToVirtualMachineConfig(vmConfig)
+	})
+	mustRegisterDeviceConverter(func(vmConfig *VirtualMachineConfiguration, dev *config.NVMExpressController) error {
+		return (*NVMExpressController)(dev).AddToVirtualMachineConfig(vmConfig)
+	})
+	mustRegisterDeviceConverter(func(vmConfig *VirtualMachineConfiguration, dev *config.VirtioFs) error {
+		return (*VirtioFs)(dev).AddToVirtualMachineConfig(vmConfig)
+	})
+	mustRegisterDeviceConverter(func(vmConfig *VirtualMachineConfiguration, dev *config.VirtioNet) error {
+		netDev := VirtioNet{VirtioNet: dev}
+		return netDev.AddToVirtualMachineConfig(vmConfig)
+	})
+	mustRegisterDeviceConverter(func(vmConfig *VirtualMachineConfiguration, dev *config.VirtioRng) error {
+		return (*VirtioRng)(dev).AddToVirtualMachineConfig(vmConfig)
+	})
+	mustRegisterDeviceConverter(func(vmConfig *VirtualMachineConfiguration, dev *config.VirtioSerial) error {
+		return (*VirtioSerial)(dev).AddToVirtualMachineConfig(vmConfig)
+	})
+	mustRegisterDeviceConverter(func(vmConfig *VirtualMachineConfiguration, dev *config.VirtioVsock) error {
+		return (*VirtioVsock)(dev).AddToVirtualMachineConfig(vmConfig)
+	})
+	mustRegisterDeviceConverter(func(vmConfig *VirtualMachineConfiguration, dev *config.VirtioInput) error {
+		return (*VirtioInput)(dev).AddToVirtualMachineConfig(vmConfig)
+	})
+	mustRegisterDeviceConverter(func(vmConfig *VirtualMachineConfiguration, dev *config.VirtioGPU) error {
+		return (*VirtioGPU)(dev).AddToVirtualMachineConfig(vmConfig)
+	})
+	mustRegisterDeviceConverter(func(vmConfig *VirtualMachineConfiguration, dev *config.VirtioBalloon) error {
+		return (*VirtioBalloon)(dev).AddToVirtualMachineConfig(vmConfig)
+	})
+	mustRegisterDeviceConverter(func(vmConfig *VirtualMachineConfiguration, dev *config.NetworkBlockDevice) error {
+		return (*NetworkBlockDevice)(dev).AddToVirtualMachineConfig(vmConfig)
+	})
+}
+
+// CheckDeviceConverters fails if a device type registered with
+// config.RegisterDeviceType has no converter registered with
+// RegisterDeviceConverter, so that a missing converter is reported before the
+// virtual machine is configured rather than when a device is used.
+func CheckDeviceConverters() error {
+	missing := []string{}
+	for _, devType := range config.DeviceTypes() {
+		if _, ok := deviceConverters[reflect.TypeOf(devType.New())]; !ok {
+			missing = append(missing, devType.Name)
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("no converter is registered for the device types: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// AddToVirtualMachineConfig adds dev to the virtual machine configuration
+// using the converter registered for its type.
 func AddToVirtualMachineConfig(vmConfig *VirtualMachineConfiguration, dev config.VirtioDevice) error {
-	switch d := dev.(type) {
-	case *config.USBMassStorage:
-		return (*USBMassStorage)(d).AddToVirtualMachineConfig(vmConfig)
-	case *config.VirtioBlk:
-		return (*VirtioBlk)(d).AddToVirtualMachineConfig(vmConfig)
-	case *config.RosettaShare:
-		return (*RosettaShare)(d).AddToVirtualMachineConfig(vmConfig)
-	case *config.NVMExpressController:
-		return (*NVMExpressController)(d).AddToVirtualMachineConfig(vmConfig)
-	case *config.VirtioFs:
-		return (*VirtioFs)(d).AddToVirtualMachineConfig(vmConfig)
-	case *config.VirtioNet:
-		dev := VirtioNet{VirtioNet: d}
-		return dev.AddToVirtualMachineConfig(vmConfig)
-	case *config.VirtioRng:
-		return (*VirtioRng)(d).AddToVirtualMachineConfig(vmConfig)
-	case *config.VirtioSerial:
-		return (*VirtioSerial)(d).AddToVirtualMachineConfig(vmConfig)
-	case *config.VirtioVsock:
-		return (*VirtioVsock)(d).AddToVirtualMachineConfig(vmConfig)
-	case *config.VirtioInput:
-		return (*VirtioInput)(d).AddToVirtualMachineConfig(vmConfig)
-	case *config.VirtioGPU:
-		return (*VirtioGPU)(d).AddToVirtualMachineConfig(vmConfig)
-	case *config.VirtioBalloon:
-		return (*VirtioBalloon)(d).AddToVirtualMachineConfig(vmConfig)
-	case *config.NetworkBlockDevice:
-		return (*NetworkBlockDevice)(d).AddToVirtualMachineConfig(vmConfig)
-	default:
-		return fmt.Errorf("unexpected virtio device type: %T", d)
+	convert, ok := deviceConverters[reflect.TypeOf(dev)]
+	if !ok {
+		return fmt.Errorf("unexpected virtio device type: %T", dev)
 	}
+	return convert(vmConfig, dev)
 }
 
 func (conf *DiskStorageConfig) toVz() (vz.StorageDeviceAttachment, error) {
@@ -501,9 +565,23 @@ func (conf *DiskStorageConfig) toVz() (vz.StorageDeviceAttachment, error) {
 		if conf.ImagePath == "" {
 			return nil, fmt.Errorf("missing mandatory 'path' option for %s device", conf.DevName)
 		}
+		imagePath := conf.ImagePath
+		if conf.Ephemeral {
+			clonePath, remove, err := image.TemporaryClone(conf.ImagePath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create ephemeral clone of %s: %w", conf.ImagePath, err)
+			}
+			util.RegisterExitHandler(func() {
+				if err := remove(); err != nil {
+					log.Warnf("failed to remove ephemeral clone %s: %v", clonePath, err)
+				}
+			})
+			log.Infof("Using ephemeral clone %s of %s", clonePath, conf.ImagePath)
+			imagePath = clonePath
+		}
 		syncMode := vz.DiskImageSynchronizationModeFsync
 		caching := vz.DiskImageCachingModeCached
-		return vz.NewDiskImageStorageDeviceAttachmentWithCacheAndSync(conf.ImagePath, conf.ReadOnly, caching, syncMode)
+		return vz.NewDiskImageStorageDeviceAttachmentWithCacheAndSync(imagePath, conf.ReadOnly, caching, syncMode)
 	case config.DiskBackendBlockDevice:
 		var stat unix.Stat_t
 		err := unix.Lstat(conf.ImagePath, &stat)
@@ -0,0 +1,19 @@
+package vf
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDeviceConverters(t *testing.T) {
+	require.NoError(t, CheckDeviceConverters())
+
+	typ := reflect.TypeOf(&config.VirtioRng{})
+	convert := deviceConverters[typ]
+	delete(deviceConverters, typ)
+	defer func() { deviceConverters[typ] = convert }()
+	require.EqualError(t, CheckDeviceConverters(), "no converter is registered for the device types: virtio-rng")
+}
@@ -22,6 +22,9 @@ type VirtualMachine struct {
 var PlatformType string
 
 func NewVirtualMachine(vmConfig config.VirtualMachine) (*VirtualMachine, error) {
+	if err := CheckDeviceConverters(); err != nil {
+		return nil, err
+	}
 	vfConfig, err := NewVirtualMachineConfiguration(&vmConfig)
 	if err != nil {
 		return nil, err
@@ -11,6 +11,12 @@ func NewMacPlatformConfiguration(_, _, _ string) (vz.PlatformConfiguration, erro
 	return nil, fmt.Errorf("running macOS guests is only supported on ARM devices")
 }
 
+// macOSGuestsSupported returns true if macOS virtual machines can be started
+// on this host
+func macOSGuestsSupported() bool {
+	return false
+}
+
 func toVzMacOSBootloader(_ *config.MacOSBootloader) (vz.BootLoader, error) {
 	return nil, fmt.Errorf("running macOS guests is only supported on ARM devices")
 }
@@ -47,6 +47,13 @@ func NewMacPlatformConfiguration(machineIdentifierVar, hardwareModelVar, auxilia
 	return platformConfig, nil
 }
 
+// macOSGuestsSupported returns true if macOS virtual machines can be started
+// on this host
+func macOSGuestsSupported() bool {
+	_, err := vz.NewMacOSBootLoader()
+	return err == nil
+}
+
 func toVzMacOSBootloader(_ *config.MacOSBootloader) (vz.BootLoader, error) {
 	return vz.NewMacOSBootLoader()
 }